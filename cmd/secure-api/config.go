@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"iot-insighthub/pkg/auth"
+	"iot-insighthub/pkg/config"
+	"iot-insighthub/pkg/secureapi"
+)
+
+// Config is the secure API's configuration. Every setting can be given in
+// the configuration file, as an environment variable or as a flag; see
+// pkg/config for the precedence.
+type Config struct {
+	Addr       string           `yaml:"addr" toml:"addr" env:"API_ADDR" flag:"addr" usage:"HTTP listen address"`
+	Database   DatabaseConfig   `yaml:"database" toml:"database"`
+	Auth       AuthConfig       `yaml:"auth" toml:"auth"`
+	Validation ValidationConfig `yaml:"validation" toml:"validation"`
+}
+
+// DatabaseConfig configures the TimescaleDB connection.
+type DatabaseConfig struct {
+	DSN             string        `yaml:"dsn" toml:"dsn" env:"DATABASE_DSN" secret:"true" usage:"TimescaleDB DSN"`
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DATABASE_MAX_OPEN_CONNS" flag:"db-max-open-conns" reload:"true" usage:"maximum open database connections"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DATABASE_MAX_IDLE_CONNS" flag:"db-max-idle-conns" reload:"true" usage:"maximum idle database connections"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DATABASE_CONN_MAX_LIFETIME" flag:"db-conn-max-lifetime" reload:"true" usage:"maximum lifetime of a database connection"`
+}
+
+// AuthConfig configures token verification and rate limiting of /ingest.
+type AuthConfig struct {
+	JWTSecret string  `yaml:"jwt_secret" toml:"jwt_secret" env:"JWT_SECRET" secret:"true" usage:"HMAC key used to verify tokens"`
+	RateLimit float64 `yaml:"rate_limit" toml:"rate_limit" env:"RATE_LIMIT" flag:"rate-limit" reload:"true" usage:"requests per second allowed on /ingest"`
+	RateBurst int     `yaml:"rate_burst" toml:"rate_burst" env:"RATE_BURST" flag:"rate-burst" reload:"true" usage:"request burst allowed on /ingest"`
+}
+
+// ValidationConfig configures how readings are validated.
+type ValidationConfig struct {
+	Config string `yaml:"config" toml:"config" env:"VALIDATION_CONFIG" flag:"validation-config" usage:"YAML file with the device ID pattern, clock-skew bounds and value ranges by device type, shared with the ingestor (optional)"`
+}
+
+// defaultConfig returns the configuration used when nothing is overridden.
+func defaultConfig() *Config {
+	return &Config{
+		Addr: ":8080",
+		Database: DatabaseConfig{
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+		},
+		Auth: AuthConfig{RateLimit: 1, RateBurst: 10},
+	}
+}
+
+// Validate reports every invalid setting.
+func (c *Config) Validate() error {
+	var check config.Check
+	check.Require("addr", c.Addr)
+	check.Require("database.dsn", c.Database.DSN)
+	check.Require("auth.jwt_secret", c.Auth.JWTSecret)
+	check.Assert(c.Database.MaxOpenConns > 0, "database.max_open_conns must be positive")
+	check.Assert(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check.Assert(c.Auth.RateLimit > 0, "auth.rate_limit must be positive")
+	check.Assert(c.Auth.RateBurst > 0, "auth.rate_burst must be positive")
+	return check.Err()
+}
+
+// loadConfig loads the configuration from the command line, the environment
+// and the configuration file, exiting with the validation errors if it is
+// invalid.
+func loadConfig() (*Config, *config.Loader) {
+	cfg := defaultConfig()
+	loader, err := config.Load(flag.CommandLine, os.Args[1:], cfg)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	log.Println("Effective configuration:")
+	config.Print(log.Writer(), cfg)
+	return cfg, loader
+}
+
+// applyConfig applies the settings that take effect without a restart.
+func applyConfig(cfg *Config) {
+	secureapi.Configure(secureapi.DBConfig{
+		DSN:             cfg.Database.DSN,
+		MaxOpenConns:    cfg.Database.MaxOpenConns,
+		MaxIdleConns:    cfg.Database.MaxIdleConns,
+		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
+	})
+	auth.SetRateLimit(cfg.Auth.RateLimit, cfg.Auth.RateBurst)
+}
+
+// reloadConfig re-reads the configuration on SIGHUP. The connection pool
+// and rate limit are applied; other changes are logged and wait for the next
+// restart. An invalid configuration is rejected and the current one is kept.
+func reloadConfig(loader *config.Loader, current *Config) {
+	next := defaultConfig()
+	if err := loader.Reload(next); err != nil {
+		log.Printf("Error reloading configuration, keeping the current one: %v", err)
+		return
+	}
+	reloaded, restart := config.Diff(current, next)
+	for _, key := range restart {
+		log.Printf("Configuration %s changed; restart to apply", key)
+	}
+	if len(reloaded) == 0 {
+		log.Println("Configuration reloaded, nothing to apply")
+		return
+	}
+	// Keep the settings that need a restart so the running server stays
+	// consistent with what it was started with.
+	current.Database.MaxOpenConns = next.Database.MaxOpenConns
+	current.Database.MaxIdleConns = next.Database.MaxIdleConns
+	current.Database.ConnMaxLifetime = next.Database.ConnMaxLifetime
+	current.Auth.RateLimit = next.Auth.RateLimit
+	current.Auth.RateBurst = next.Auth.RateBurst
+	applyConfig(current)
+	log.Printf("Configuration reloaded: %v", reloaded)
+}
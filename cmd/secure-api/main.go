@@ -2,32 +2,63 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"time"
 
 	"iot-insighthub/pkg/api"
 	"iot-insighthub/pkg/auth"
+	"iot-insighthub/pkg/codec"
+	"iot-insighthub/pkg/config"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/quarantine"
+	"iot-insighthub/pkg/registry"
 	"iot-insighthub/pkg/secureapi"
+	"iot-insighthub/pkg/validation"
 
-	"github.com/go-playground/validator/v10"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var validate *validator.Validate
+// validate checks readings with the rules shared with the ingestor.
+var validate *validation.Validator
 
-// telemetryHandler processes incoming telemetry data.
+// held receives invalid readings. Without it they are only rejected.
+var held quarantine.Writer
+
+// stageAPI is the stage recorded on readings the API quarantines.
+const stageAPI = "api"
+
+// invalidReading lists the violations of the reading at Index of a request.
+type invalidReading struct {
+	Index      int                    `json:"index"`
+	DeviceID   string                 `json:"device_id"`
+	Violations []validation.Violation `json:"violations"`
+}
+
+// maxBodySize bounds a request body before decompression.
+const maxBodySize = 1 << 20
+
+// telemetryHandler processes incoming telemetry data. The payload format is
+// chosen by the Content-Type and Content-Encoding headers; see pkg/codec.
 func telemetryHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse JSON payload.
-	var data api.TelemetryData
-	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
-		http.Error(w, "invalid payload", http.StatusBadRequest)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, "payload too large", http.StatusRequestEntityTooLarge)
 		return
 	}
 
-	// Validate payload fields.
-	if err := validate.Struct(data); err != nil {
-		http.Error(w, "validation error: "+err.Error(), http.StatusBadRequest)
+	// Decode the payload into one or more readings.
+	readings, err := codec.Default().DecodeHTTP(r.Header.Get("Content-Type"), r.Header.Get("Content-Encoding"), body)
+	if errors.Is(err, codec.ErrUnsupported) {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
 		return
 	}
 
@@ -35,32 +66,128 @@ func telemetryHandler(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 	defer cancel()
 
-	// Persist telemetry data with fault tolerance.
-	if err := secureapi.StoreTelemetryData(ctx, data); err != nil {
-		log.Printf("Error storing telemetry data: %v", err)
-		http.Error(w, "failed to store telemetry", http.StatusInternalServerError)
+	// Validate payload fields. A request with an invalid reading is rejected
+	// as a whole, and its invalid readings are quarantined.
+	arrival := time.Now()
+	var invalid []invalidReading
+	for i, data := range readings {
+		err := validate.Check(ctx, data, "", arrival)
+		if err == nil {
+			continue
+		}
+		violations := validation.Violations(err)
+		if violations == nil {
+			log.Printf("Error validating telemetry data: %v", err)
+			http.Error(w, "validation unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		invalid = append(invalid, invalidReading{Index: i, DeviceID: data.DeviceID, Violations: violations})
+	}
+	if len(invalid) > 0 {
+		quarantineReadings(ctx, readings, invalid)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{"error": "validation failed", "readings": invalid})
 		return
 	}
 
+	// Persist telemetry data with fault tolerance. Readings are upserted, so
+	// a client retrying after a partial failure does not duplicate them.
+	for _, data := range readings {
+		if err := secureapi.StoreTelemetryData(ctx, data); err != nil {
+			log.Printf("Error storing telemetry data: %v", err)
+			http.Error(w, "failed to store telemetry", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	w.WriteHeader(http.StatusAccepted)
 	w.Write([]byte("Telemetry data accepted"))
 }
 
+// quarantineReadings writes the invalid readings to quarantine, one entry
+// per rule broken. A failure is logged: the client is told the readings are
+// invalid either way.
+func quarantineReadings(ctx context.Context, readings []api.TelemetryData, invalid []invalidReading) {
+	if held == nil {
+		return
+	}
+	var entries []quarantine.Entry
+	for _, ir := range invalid {
+		for _, v := range ir.Violations {
+			entries = append(entries, quarantine.Entry{
+				TelemetryData: readings[ir.Index],
+				Stage:         stageAPI,
+				Rule:          v.Rule,
+				Reason:        v.Field + ": " + v.Reason,
+				QuarantinedAt: time.Now().UTC(),
+			})
+		}
+	}
+	if err := held.Write(ctx, entries); err != nil {
+		log.Printf("Error quarantining invalid readings: %v", err)
+		return
+	}
+	for _, e := range entries {
+		metrics.QuarantinedReadings.WithLabelValues(stageAPI, e.Rule).Inc()
+	}
+}
+
+// newValidator builds the validator from the validation settings, and the
+// quarantine writer. Value ranges look device types up in the devices
+// table.
+func newValidator(cfg *Config) (*validation.Validator, quarantine.Writer, error) {
+	var (
+		vc  *validation.Config
+		err error
+	)
+	if cfg.Validation.Config == "" {
+		vc = &validation.Config{}
+	} else if vc, err = validation.LoadConfig(cfg.Validation.Config); err != nil {
+		return nil, nil, err
+	}
+	db, err := sql.Open("postgres", cfg.Database.DSN)
+	if err != nil {
+		return nil, nil, err
+	}
+	var types validation.DeviceTypes
+	if len(vc.Ranges) > 0 {
+		types = registry.NewCache(registry.NewPostgresLookup(db), registry.CacheConfig{})
+	}
+	v, err := validation.New(vc, types)
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, quarantine.NewPostgresWriter(db), nil
+}
+
 // docsHandler serves static Swagger documentation (e.g. swagger.json).
 func docsHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "./docs/swagger.json")
 }
 
 func main() {
+	// Settings come from the configuration file, the environment and flags.
+	cfg, loader := loadConfig()
+	auth.SetJWTSecret(cfg.Auth.JWTSecret)
+	applyConfig(cfg)
+	go config.OnSIGHUP(context.Background(), func() { reloadConfig(loader, cfg) })
+
 	// Initialize the validator instance.
-	validate = validator.New()
+	var err error
+	validate, held, err = newValidator(cfg)
+	if err != nil {
+		log.Fatalf("Error loading validation rules: %v", err)
+	}
 
 	mux := http.NewServeMux()
 	// Apply JWT-based authentication and rate limiting on the /ingest endpoint.
 	mux.Handle("/ingest", auth.AuthMiddleware(http.HandlerFunc(telemetryHandler)))
 	// Serve API documentation at /docs.
 	mux.Handle("/docs", http.HandlerFunc(docsHandler))
+	// Expose Prometheus metrics, including validation failures by rule.
+	mux.Handle("/metrics", promhttp.Handler())
 
-	log.Println("Secure API running on port 8080...")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	log.Printf("Secure API running on %s...", cfg.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Addr, mux))
 }
@@ -11,10 +11,10 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
-	"github.com/go-playground/validator/v10"
 	"iot-insighthub/pkg/api"
 	"iot-insighthub/pkg/auth"
 	"iot-insighthub/pkg/secureapi"
+	"iot-insighthub/pkg/validation"
 )
 
 // generateTestTokenForHandler creates a valid JWT token for testing the API handler.
@@ -34,7 +34,7 @@ func overrideStoreTelemetryData(ctx context.Context, data api.TelemetryData) err
 
 func TestTelemetryHandler_ValidRequest(t *testing.T) {
 	// Ensure the validator is initialized.
-	validate = validator.New()
+	validate = validation.Default()
 
 	// Set environment variable for JWT secret.
 	os.Setenv("JWT_SECRET", "testsecret")
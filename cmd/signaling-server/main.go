@@ -5,9 +5,11 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"os"
 	"sync"
 
 	"github.com/gorilla/websocket"
+	"iot-insighthub/pkg/config"
 )
 
 // SignalingMessage defines the JSON structure for signaling messages.
@@ -147,9 +149,26 @@ func serveWs(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	go client.writePump()
 }
 
+// Config is the signaling server's configuration.
+type Config struct {
+	Addr string `yaml:"addr" toml:"addr" env:"SIGNALING_ADDR" flag:"addr" usage:"http service address"`
+}
+
+// Validate reports every invalid setting.
+func (c *Config) Validate() error {
+	var check config.Check
+	check.Require("addr", c.Addr)
+	return check.Err()
+}
+
 func main() {
-	addr := flag.String("addr", ":8081", "http service address")
-	flag.Parse()
+	cfg := &Config{Addr: ":8081"}
+	if _, err := config.Load(flag.CommandLine, os.Args[1:], cfg); err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	log.Println("Effective configuration:")
+	config.Print(log.Writer(), cfg)
+
 	hub := newHub()
 	go hub.run()
 
@@ -157,8 +176,8 @@ func main() {
 		serveWs(hub, w, r)
 	})
 
-	log.Printf("Signaling server listening on %s", *addr)
-	if err := http.ListenAndServe(*addr, nil); err != nil {
+	log.Printf("Signaling server listening on %s", cfg.Addr)
+	if err := http.ListenAndServe(cfg.Addr, nil); err != nil {
 		log.Fatal("ListenAndServe: ", err)
 	}
 }
@@ -1,17 +1,40 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"net/http"
-	"path/filepath"
+	"os"
+
+	"iot-insighthub/pkg/config"
 )
 
+// Config is the static file server's configuration.
+type Config struct {
+	Addr string `yaml:"addr" toml:"addr" env:"STATIC_ADDR" flag:"addr" usage:"HTTP listen address"`
+	Dir  string `yaml:"dir" toml:"dir" env:"STATIC_DIR" flag:"dir" usage:"directory to serve"`
+}
+
+// Validate reports every invalid setting.
+func (c *Config) Validate() error {
+	var check config.Check
+	check.Require("addr", c.Addr)
+	check.Require("dir", c.Dir)
+	return check.Err()
+}
+
 func main() {
-	// Serve static files from the "web" directory.
-	webDir := filepath.Join(".", "web")
-	fs := http.FileServer(http.Dir(webDir))
+	cfg := &Config{Addr: ":8080", Dir: "web"}
+	if _, err := config.Load(flag.CommandLine, os.Args[1:], cfg); err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	log.Println("Effective configuration:")
+	config.Print(log.Writer(), cfg)
+
+	// Serve static files from the configured directory.
+	fs := http.FileServer(http.Dir(cfg.Dir))
 	http.Handle("/", fs)
 
-	log.Println("Static file server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	log.Printf("Static file server running on %s", cfg.Addr)
+	log.Fatal(http.ListenAndServe(cfg.Addr, nil))
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	awsKinesis "github.com/aws/aws-sdk-go/service/kinesis"
+	"iot-insighthub/pkg/kinesis"
+	"iot-insighthub/pkg/pool"
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/source"
+)
+
+// runBackfill reprocesses the Kinesis records that arrived between -start
+// and -end through the normal pipeline, then exits. The sink upserts on each
+// reading's natural key, so readings already stored are updated rather than
+// duplicated and a window can be backfilled more than once. Records that
+// fail go to the dead-letter queue as usual. Live checkpoints and leases are
+// not touched.
+func runBackfill(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	startFlag := fs.String("start", "", "RFC 3339 arrival time to start reading from (required)")
+	endFlag := fs.String("end", "", "RFC 3339 arrival time to stop at (default now)")
+	shardsFlag := fs.String("shards", "", "comma-separated shard IDs to backfill (default every shard)")
+	readsPerSecond := fs.Float64("reads-per-second", 2, "GetRecords calls per shard per second, leaving the rest of the 5/s limit to the live ingestor")
+	progressInterval := fs.Duration("progress-interval", 10*time.Second, "how often to log per-shard progress")
+	cfg, _ := loadConfig(fs, args)
+
+	if cfg.Source != "kinesis" {
+		log.Fatalf("backfill reads Kinesis; source is %q", cfg.Source)
+	}
+	start, err := time.Parse(time.RFC3339, *startFlag)
+	if err != nil {
+		log.Fatalf("Invalid -start %q: %v", *startFlag, err)
+	}
+	end := time.Now()
+	if *endFlag != "" {
+		if end, err = time.Parse(time.RFC3339, *endFlag); err != nil {
+			log.Fatalf("Invalid -end %q: %v", *endFlag, err)
+		}
+		if end.After(time.Now()) {
+			log.Fatalf("-end %s is in the future", *endFlag)
+		}
+	}
+	var shards []string
+	if *shardsFlag != "" {
+		shards = strings.Split(*shardsFlag, ",")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, halt = context.WithCancel(ctx)
+
+	deadLetters, err = newDLQSink(cfg.DLQ)
+	if err != nil {
+		log.Fatalf("Error creating dead-letter sink: %v", err)
+	}
+	writer, err := newWriter(cfg.Sink)
+	if err != nil {
+		log.Fatalf("Error creating telemetry sink: %v", err)
+	}
+	readings = sink.NewBatcher(writer, cfg.Sink.batchConfig())
+	pipeline, err = newPipeline(cfg, false)
+	if err != nil {
+		log.Fatalf("Error building pipeline: %v", err)
+	}
+
+	backfill := kinesis.NewBackfill(awsKinesis.New(awsSession), cfg.Kinesis.Stream, kinesis.BackfillConfig{
+		Start:  start,
+		End:    end,
+		Shards: shards,
+		Polling: kinesis.PollingConfig{
+			MaxReadsPerSecond: *readsPerSecond,
+			MaxLimit:          cfg.Kinesis.MaxRecords,
+			IdleInterval:      cfg.Kinesis.IdleInterval,
+		},
+	})
+	go func() {
+		ticker := time.NewTicker(*progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, p := range backfill.Progress() {
+					log.Printf("Backfill %s: %d records, at %s (%s)", p.ShardID, p.Records, formatPosition(p.Position), progressState(p, start, end))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	recordChan := make(chan *source.Record, cfg.ChannelSize)
+	workers := pool.New(cfg.workers(), cfg.QueueSize, processRecord)
+	routed := make(chan struct{})
+	go func() {
+		defer close(routed)
+		workers.Run(context.Background(), recordChan)
+	}()
+
+	runErr := backfill.Run(ctx, recordChan)
+	close(recordChan)
+	<-routed
+	workers.Close()
+	if err := readings.Close(context.Background()); err != nil {
+		log.Printf("Error flushing readings: %v", err)
+	}
+	if err := closePipeline(context.Background()); err != nil {
+		log.Printf("Error closing pipeline: %v", err)
+	}
+
+	printBackfillReport(os.Stdout, backfill.Progress(), start, end)
+	if runErr != nil {
+		log.Fatalf("Backfill incomplete: %v", runErr)
+	}
+	log.Println("Backfill finished")
+}
+
+// printBackfillReport writes one line per shard with its final progress.
+func printBackfillReport(w io.Writer, progress []kinesis.ShardProgress, start, end time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARD\tRECORDS\tPOSITION\tSTATUS")
+	for _, p := range progress {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", p.ShardID, p.Records, formatPosition(p.Position), progressState(p, start, end))
+	}
+	tw.Flush()
+}
+
+// progressState describes a shard's progress: done, failed, or the share
+// of the window read so far.
+func progressState(p kinesis.ShardProgress, start, end time.Time) string {
+	switch {
+	case p.Err != nil:
+		return "failed: " + p.Err.Error()
+	case p.Done:
+		return "done"
+	case p.Position.IsZero():
+		return "0%"
+	default:
+		return fmt.Sprintf("%.0f%%", 100*float64(p.Position.Sub(start))/float64(end.Sub(start)))
+	}
+}
+
+// formatPosition formats a shard's position, or "-" before the first record.
+func formatPosition(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
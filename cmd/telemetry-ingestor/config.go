@@ -0,0 +1,401 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"iot-insighthub/pkg/aggregate"
+	"iot-insighthub/pkg/config"
+	"iot-insighthub/pkg/registry"
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// Config is the telemetry ingestor's configuration. Every setting can be
+// given in the configuration file, as an environment variable or as a flag;
+// see pkg/config for the precedence.
+type Config struct {
+	Source  string        `yaml:"source" toml:"source" env:"SOURCE" flag:"source" usage:"record source: kinesis, kafka or file"`
+	Kinesis KinesisConfig `yaml:"kinesis" toml:"kinesis"`
+	Kafka   KafkaConfig   `yaml:"kafka" toml:"kafka"`
+	File    FileConfig    `yaml:"file" toml:"file"`
+
+	Checkpoint CheckpointConfig `yaml:"checkpoint" toml:"checkpoint"`
+	Lease      LeaseConfig      `yaml:"lease" toml:"lease"`
+	DLQ        DLQConfig        `yaml:"dlq" toml:"dlq"`
+	Sink       SinkConfig       `yaml:"sink" toml:"sink"`
+	Health     HealthConfig     `yaml:"health" toml:"health"`
+	Pipeline   PipelineConfig   `yaml:"pipeline" toml:"pipeline"`
+	Validation ValidationConfig `yaml:"validation" toml:"validation"`
+	Registry   RegistryConfig   `yaml:"registry" toml:"registry"`
+	Transform  TransformConfig  `yaml:"transform" toml:"transform"`
+	Anomaly    AnomalyConfig    `yaml:"anomaly" toml:"anomaly"`
+	EventTime  EventTimeConfig  `yaml:"event_time" toml:"event_time"`
+	Aggregate  AggregateConfig  `yaml:"aggregate" toml:"aggregate"`
+	Alert      AlertConfig      `yaml:"alert" toml:"alert"`
+
+	Workers     int `yaml:"workers" toml:"workers" env:"WORKERS" flag:"workers" usage:"number of processing workers (0 = one per core)"`
+	QueueSize   int `yaml:"queue_size" toml:"queue_size" env:"WORKER_QUEUE_SIZE" flag:"queue-size" usage:"records buffered per worker"`
+	ChannelSize int `yaml:"channel_size" toml:"channel_size" env:"RECORD_CHANNEL_SIZE" flag:"channel-size" usage:"records buffered between the source and the workers"`
+
+	MetricsAddr     string        `yaml:"metrics_addr" toml:"metrics_addr" env:"METRICS_ADDR" flag:"metrics-addr" usage:"Prometheus metrics listen address"`
+	PprofAddr       string        `yaml:"pprof_addr" toml:"pprof_addr" env:"PPROF_ADDR" flag:"pprof-addr" usage:"pprof listen address"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" reload:"true" usage:"how long to drain in-flight records on shutdown"`
+}
+
+// KinesisConfig configures the Kinesis source.
+type KinesisConfig struct {
+	Stream       string `yaml:"stream" toml:"stream" env:"KINESIS_STREAM" flag:"kinesis-stream" usage:"Kinesis stream name"`
+	ConsumerMode string `yaml:"consumer_mode" toml:"consumer_mode" env:"CONSUMER_MODE" flag:"consumer-mode" usage:"polling or fanout"`
+	ConsumerName string `yaml:"consumer_name" toml:"consumer_name" env:"EFO_CONSUMER_NAME" flag:"efo-consumer-name" usage:"enhanced fan-out consumer name"`
+
+	ReadsPerSecond float64       `yaml:"reads_per_second" toml:"reads_per_second" env:"POLL_READS_PER_SECOND" flag:"poll-reads-per-second" usage:"GetRecords calls per shard per second in polling mode (Kinesis allows 5 per shard across all consumers)"`
+	MaxRecords     int64         `yaml:"max_records" toml:"max_records" env:"POLL_MAX_RECORDS" flag:"poll-max-records" usage:"largest GetRecords Limit used while a shard is behind"`
+	IdleInterval   time.Duration `yaml:"idle_interval" toml:"idle_interval" env:"POLL_IDLE_INTERVAL" flag:"poll-idle-interval" usage:"wait between polls of a caught-up shard"`
+}
+
+// KafkaConfig configures the Kafka source.
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers" toml:"brokers" env:"KAFKA_BROKERS" flag:"kafka-brokers" usage:"comma-separated Kafka brokers"`
+	Topic   string   `yaml:"topic" toml:"topic" env:"KAFKA_TOPIC" flag:"kafka-topic" usage:"Kafka topic"`
+}
+
+// FileConfig configures the file source.
+type FileConfig struct {
+	Path string `yaml:"path" toml:"path" env:"SOURCE_FILE" flag:"source-file" usage:"newline-delimited input file, or - for stdin"`
+}
+
+// CheckpointConfig selects where consumer positions are stored.
+type CheckpointConfig struct {
+	Store    string        `yaml:"store" toml:"store" env:"CHECKPOINT_STORE" flag:"checkpoint-store" usage:"file, postgres or dynamodb"`
+	File     string        `yaml:"file" toml:"file" env:"CHECKPOINT_FILE" flag:"checkpoint-file" usage:"checkpoint file for the file store"`
+	DSN      string        `yaml:"dsn" toml:"dsn" env:"CHECKPOINT_DSN" secret:"true" usage:"Postgres DSN for the postgres store"`
+	Table    string        `yaml:"table" toml:"table" env:"CHECKPOINT_TABLE" flag:"checkpoint-table" usage:"DynamoDB table for the dynamodb store"`
+	Interval time.Duration `yaml:"interval" toml:"interval" env:"CHECKPOINT_INTERVAL" flag:"checkpoint-interval" usage:"how often checkpoints are written"`
+}
+
+// LeaseConfig selects where shard leases are stored.
+type LeaseConfig struct {
+	Store             string        `yaml:"store" toml:"store" env:"LEASE_STORE" flag:"lease-store" usage:"memory, postgres or dynamodb"`
+	DSN               string        `yaml:"dsn" toml:"dsn" env:"LEASE_DSN" secret:"true" usage:"Postgres DSN for the postgres store"`
+	Table             string        `yaml:"table" toml:"table" env:"LEASE_TABLE" flag:"lease-table" usage:"DynamoDB table for the dynamodb store"`
+	WorkerID          string        `yaml:"worker_id" toml:"worker_id" env:"LEASE_WORKER_ID" flag:"worker-id" usage:"lease owner name (defaults to the hostname)"`
+	Duration          time.Duration `yaml:"duration" toml:"duration" env:"LEASE_DURATION" flag:"lease-duration" usage:"time without renewal after which a lease may be taken"`
+	DiscoveryInterval time.Duration `yaml:"discovery_interval" toml:"discovery_interval" env:"DISCOVERY_INTERVAL" flag:"discovery-interval" usage:"how often shards and partitions are listed"`
+}
+
+// DLQConfig selects where failed records are kept.
+type DLQConfig struct {
+	Sink     string `yaml:"sink" toml:"sink" env:"DLQ_SINK" flag:"dlq-sink" usage:"dir or s3"`
+	Dir      string `yaml:"dir" toml:"dir" env:"DLQ_DIR" flag:"dlq-dir" usage:"directory for the dir sink"`
+	Bucket   string `yaml:"bucket" toml:"bucket" env:"DLQ_BUCKET" flag:"dlq-bucket" usage:"bucket for the s3 sink"`
+	Prefix   string `yaml:"prefix" toml:"prefix" env:"DLQ_PREFIX" flag:"dlq-prefix" usage:"object key prefix for the s3 sink"`
+	Endpoint string `yaml:"endpoint" toml:"endpoint" env:"DLQ_ENDPOINT" flag:"dlq-endpoint" usage:"endpoint of an S3-compatible store"`
+}
+
+// SinkConfig configures how decoded readings are stored.
+type SinkConfig struct {
+	Type            string        `yaml:"type" toml:"type" env:"SINK_TYPE" flag:"sink" usage:"postgres or discard"`
+	DSN             string        `yaml:"dsn" toml:"dsn" env:"SINK_DSN" secret:"true" usage:"TimescaleDB DSN for the postgres sink"`
+	BatchSize       int           `yaml:"batch_size" toml:"batch_size" env:"SINK_BATCH_SIZE" flag:"sink-batch-size" usage:"readings per batch"`
+	FlushInterval   time.Duration `yaml:"flush_interval" toml:"flush_interval" env:"SINK_FLUSH_INTERVAL" flag:"sink-flush-interval" usage:"longest a reading waits before its batch is written"`
+	Writers         int           `yaml:"writers" toml:"writers" env:"SINK_WRITERS" flag:"sink-writers" usage:"batches written concurrently"`
+	MaxRetries      int           `yaml:"max_retries" toml:"max_retries" env:"SINK_MAX_RETRIES" flag:"sink-max-retries" usage:"retries of a failed batch before its records go to the dead-letter queue"`
+	RetryBackoff    time.Duration `yaml:"retry_backoff" toml:"retry_backoff" env:"SINK_RETRY_BACKOFF" flag:"sink-retry-backoff" usage:"delay before the first retry, doubled on each further retry"`
+	DedupeCacheSize int           `yaml:"dedupe_cache_size" toml:"dedupe_cache_size" env:"SINK_DEDUPE_CACHE_SIZE" flag:"sink-dedupe-cache-size" usage:"recently stored readings remembered to drop redeliveries before the database (0 = off)"`
+}
+
+// batchConfig returns the batching settings of c.
+func (c SinkConfig) batchConfig() sink.BatchConfig {
+	return sink.BatchConfig{
+		Size:       c.BatchSize,
+		Interval:   c.FlushInterval,
+		Writers:    c.Writers,
+		MaxRetries: c.MaxRetries,
+		Backoff:    c.RetryBackoff,
+	}
+}
+
+// PipelineConfig configures the chain of stages each record goes through.
+type PipelineConfig struct {
+	Stages []string `yaml:"stages" toml:"stages" env:"PIPELINE_STAGES" flag:"pipeline-stages" usage:"comma-separated stages in order, each name or name:policy with policy drop, dlq or fail"`
+	Routes []string `yaml:"routes" toml:"routes" env:"PIPELINE_ROUTES" flag:"pipeline-routes" usage:"comma-separated route rules, each device-prefix=output with output telemetry or discard"`
+}
+
+// ValidationConfig configures the validate stage.
+type ValidationConfig struct {
+	Config string `yaml:"config" toml:"config" env:"VALIDATION_CONFIG" flag:"validation-config" usage:"YAML file with the device ID pattern, clock-skew bounds and value ranges by device type, shared with the secure API (optional)"`
+}
+
+// RegistryConfig configures the enrich stage.
+type RegistryConfig struct {
+	DSN           string        `yaml:"dsn" toml:"dsn" env:"REGISTRY_DSN" secret:"true" usage:"Postgres DSN of the devices and quarantine tables (defaults to the sink DSN)"`
+	UnknownPolicy string        `yaml:"unknown_policy" toml:"unknown_policy" env:"REGISTRY_UNKNOWN_POLICY" flag:"registry-unknown-policy" usage:"what to do with readings from unregistered devices: tag, quarantine or reject"`
+	CacheSize     int           `yaml:"cache_size" toml:"cache_size" env:"REGISTRY_CACHE_SIZE" flag:"registry-cache-size" usage:"devices cached"`
+	CacheTTL      time.Duration `yaml:"cache_ttl" toml:"cache_ttl" env:"REGISTRY_CACHE_TTL" flag:"registry-cache-ttl" usage:"how long a cached device is used; devices in use are refreshed before then"`
+	NegativeTTL   time.Duration `yaml:"negative_ttl" toml:"negative_ttl" env:"REGISTRY_NEGATIVE_TTL" flag:"registry-negative-ttl" usage:"how long an unregistered device is remembered as unregistered"`
+}
+
+// TransformConfig configures the transform stage.
+type TransformConfig struct {
+	Config string `yaml:"config" toml:"config" env:"TRANSFORM_CONFIG" flag:"transform-config" usage:"YAML file with per-device calibration curves, units and extra unit definitions (optional)"`
+}
+
+// AnomalyConfig configures the detect stage.
+type AnomalyConfig struct {
+	Config       string `yaml:"config" toml:"config" env:"ANOMALY_CONFIG" flag:"anomaly-config" usage:"YAML file choosing the detectors per device and device type"`
+	DSN          string `yaml:"dsn" toml:"dsn" env:"ANOMALY_DSN" secret:"true" usage:"Postgres DSN of the anomalies table (defaults to the sink DSN)"`
+	EventsStream string `yaml:"events_stream" toml:"events_stream" env:"ANOMALY_EVENTS_STREAM" flag:"anomaly-events-stream" usage:"Kinesis stream anomaly events are published to (default: JSON lines on stdout)"`
+}
+
+// EventTimeConfig configures the pipeline's watermark, shared by the
+// watermark, aggregate and alert stages.
+type EventTimeConfig struct {
+	AllowedLateness time.Duration `yaml:"allowed_lateness" toml:"allowed_lateness" env:"EVENT_TIME_ALLOWED_LATENESS" flag:"event-time-allowed-lateness" usage:"how far behind the watermark a reading may be and still count as on time"`
+	TooLate         string        `yaml:"too_late" toml:"too_late" env:"EVENT_TIME_TOO_LATE" flag:"event-time-too-late" usage:"what to do with readings later than that: drop, side-output (to quarantine) or update"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"EVENT_TIME_IDLE_TIMEOUT" flag:"event-time-idle-timeout" usage:"how long a partition may go without readings before it stops holding the watermark back"`
+}
+
+// AggregateConfig configures the aggregate stage.
+type AggregateConfig struct {
+	Windows []string `yaml:"windows" toml:"windows" env:"AGGREGATE_WINDOWS" flag:"aggregate-windows" usage:"comma-separated windows, each a size such as 1m (tumbling) or size/slide such as 5m/1m (sliding)"`
+	DSN     string   `yaml:"dsn" toml:"dsn" env:"AGGREGATE_DSN" secret:"true" usage:"Postgres DSN of the rollup tables (defaults to the sink DSN)"`
+}
+
+// AlertConfig configures the alert stage.
+type AlertConfig struct {
+	Rules      string `yaml:"rules" toml:"rules" env:"ALERT_RULES" flag:"alert-rules" usage:"YAML file with the alerting rules"`
+	WebhookURL string `yaml:"webhook_url" toml:"webhook_url" env:"ALERT_WEBHOOK_URL" secret:"true" usage:"URL alert notifications are posted to (default: JSON lines on stdout)"`
+}
+
+// uses reports whether the pipeline includes the stage called name.
+func (c PipelineConfig) uses(name string) bool {
+	for _, spec := range c.Stages {
+		if stage, _, _ := strings.Cut(strings.TrimSpace(spec), ":"); stage == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthConfig configures the /healthz and /readyz probes served on the
+// metrics address.
+type HealthConfig struct {
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HEALTH_READ_TIMEOUT" flag:"health-read-timeout" usage:"not ready once an owned shard has had no successful GetRecords for this long"`
+	StallTimeout time.Duration `yaml:"stall_timeout" toml:"stall_timeout" env:"HEALTH_STALL_TIMEOUT" flag:"health-stall-timeout" usage:"not ready once a worker has spent this long on one record, or a partition has had no reader for this long"`
+}
+
+// defaultConfig returns the configuration used when nothing is overridden.
+func defaultConfig() *Config {
+	return &Config{
+		Source: "kinesis",
+		Kinesis: KinesisConfig{
+			ConsumerMode:   "polling",
+			ConsumerName:   "telemetry-ingestor",
+			ReadsPerSecond: 5,
+			MaxRecords:     10000,
+			IdleInterval:   time.Second,
+		},
+		File: FileConfig{Path: "-"},
+		Checkpoint: CheckpointConfig{
+			Store:    "file",
+			File:     "checkpoints.json",
+			Table:    "kinesis_checkpoints",
+			Interval: 5 * time.Second,
+		},
+		Lease: LeaseConfig{
+			Store:             "memory",
+			Table:             "kinesis_leases",
+			Duration:          30 * time.Second,
+			DiscoveryInterval: time.Minute,
+		},
+		DLQ: DLQConfig{Sink: "dir", Dir: "dlq"},
+		Sink: SinkConfig{
+			Type:            "postgres",
+			BatchSize:       5000,
+			FlushInterval:   time.Second,
+			Writers:         4,
+			MaxRetries:      5,
+			RetryBackoff:    500 * time.Millisecond,
+			DedupeCacheSize: 100000,
+		},
+		Health: HealthConfig{
+			ReadTimeout:  2 * time.Minute,
+			StallTimeout: 2 * time.Minute,
+		},
+		Pipeline: PipelineConfig{
+			Stages: []string{"decode", "validate", "route"},
+		},
+		Registry: RegistryConfig{
+			UnknownPolicy: registry.UnknownTag,
+			CacheSize:     10000,
+			CacheTTL:      5 * time.Minute,
+			NegativeTTL:   time.Minute,
+		},
+		EventTime: EventTimeConfig{
+			AllowedLateness: time.Minute,
+			TooLate:         telemetry.TooLateDrop,
+			IdleTimeout:     time.Minute,
+		},
+		Aggregate: AggregateConfig{
+			Windows: []string{"1m", "1h", "1d"},
+		},
+		QueueSize:       100,
+		ChannelSize:     1000,
+		MetricsAddr:     ":9090",
+		PprofAddr:       ":6060",
+		ShutdownTimeout: 30 * time.Second,
+	}
+}
+
+// Validate reports every invalid setting.
+func (c *Config) Validate() error {
+	var check config.Check
+	check.OneOf("source", c.Source, "kinesis", "kafka", "file")
+	switch c.Source {
+	case "kinesis":
+		check.Require("kinesis.stream", c.Kinesis.Stream)
+		check.OneOf("kinesis.consumer_mode", c.Kinesis.ConsumerMode, "polling", "fanout")
+		check.Assert(c.Kinesis.ReadsPerSecond > 0 && c.Kinesis.ReadsPerSecond <= 5, "kinesis.reads_per_second must be in (0, 5]")
+		check.Assert(c.Kinesis.MaxRecords >= 100 && c.Kinesis.MaxRecords <= 10000, "kinesis.max_records must be between 100 and 10000")
+		check.Assert(c.Kinesis.IdleInterval > 0, "kinesis.idle_interval must be positive")
+	case "kafka":
+		check.Assert(len(c.Kafka.Brokers) > 0, "kafka.brokers is required")
+		check.Require("kafka.topic", c.Kafka.Topic)
+	}
+	check.OneOf("checkpoint.store", c.Checkpoint.Store, "file", "postgres", "dynamodb")
+	if c.Checkpoint.Store == "postgres" {
+		check.Require("checkpoint.dsn", c.Checkpoint.DSN)
+	}
+	check.Assert(c.Checkpoint.Interval > 0, "checkpoint.interval must be positive")
+	check.OneOf("lease.store", c.Lease.Store, "memory", "postgres", "dynamodb")
+	if c.Lease.Store == "postgres" {
+		check.Require("lease.dsn", c.Lease.DSN)
+	}
+	check.Assert(c.Lease.Duration > 0, "lease.duration must be positive")
+	check.Assert(c.Lease.DiscoveryInterval > 0, "lease.discovery_interval must be positive")
+	check.OneOf("dlq.sink", c.DLQ.Sink, "dir", "s3")
+	if c.DLQ.Sink == "s3" {
+		check.Require("dlq.bucket", c.DLQ.Bucket)
+	}
+	check.OneOf("sink.type", c.Sink.Type, "postgres", "discard")
+	if c.Sink.Type == "postgres" {
+		check.Require("sink.dsn", c.Sink.DSN)
+	}
+	check.Assert(c.Sink.BatchSize > 0, "sink.batch_size must be positive")
+	check.Assert(c.Sink.FlushInterval > 0, "sink.flush_interval must be positive")
+	check.Assert(c.Sink.Writers > 0, "sink.writers must be positive")
+	check.Assert(c.Sink.MaxRetries >= 0, "sink.max_retries must not be negative")
+	check.Assert(c.Sink.DedupeCacheSize >= 0, "sink.dedupe_cache_size must not be negative")
+	check.Assert(c.Health.ReadTimeout > 0, "health.read_timeout must be positive")
+	check.Assert(c.Health.StallTimeout > 0, "health.stall_timeout must be positive")
+	// Closed shards are only dropped at the next discovery.
+	check.Assert(c.Health.StallTimeout > c.Lease.DiscoveryInterval, "health.stall_timeout must exceed lease.discovery_interval")
+	check.Assert(len(c.Pipeline.Stages) > 0, "pipeline.stages is required")
+	for _, route := range c.Pipeline.Routes {
+		_, output, _ := strings.Cut(route, "=")
+		check.OneOf("pipeline.routes output", output, outputTelemetry, outputDiscard)
+	}
+	if c.Pipeline.uses(telemetry.StageEnrich) {
+		check.OneOf("registry.unknown_policy", c.Registry.UnknownPolicy, registry.UnknownTag, registry.UnknownQuarantine, registry.UnknownReject)
+		check.Assert(c.Registry.CacheSize > 0, "registry.cache_size must be positive")
+		check.Assert(c.Registry.CacheTTL > 0, "registry.cache_ttl must be positive")
+		check.Assert(c.Registry.NegativeTTL > 0, "registry.negative_ttl must be positive")
+		check.Assert(c.Registry.DSN != "" || c.Sink.Type == "postgres", "registry.dsn is required without the postgres sink")
+	}
+	if c.Pipeline.uses(telemetry.StageDetect) {
+		check.Require("anomaly.config", c.Anomaly.Config)
+	}
+	if c.Pipeline.uses(telemetry.StageAlert) {
+		check.Require("alert.rules", c.Alert.Rules)
+	}
+	if c.Pipeline.uses(telemetry.StageAggregate) {
+		check.Assert(len(c.Aggregate.Windows) > 0, "aggregate.windows is required")
+		if _, err := aggregate.ParseWindows(c.Aggregate.Windows); err != nil {
+			check.Assert(false, "aggregate.windows: %v", err)
+		}
+		check.Assert(c.Aggregate.DSN != "" || c.Sink.Type == "postgres", "aggregate.dsn is required without the postgres sink")
+	}
+	check.Assert(c.EventTime.AllowedLateness >= 0, "event_time.allowed_lateness must not be negative")
+	check.OneOf("event_time.too_late", c.EventTime.TooLate, telemetry.TooLateDrop, telemetry.TooLateSideOutput, telemetry.TooLateUpdate)
+	check.Assert(c.EventTime.IdleTimeout > 0, "event_time.idle_timeout must be positive")
+	if c.Pipeline.uses(telemetry.StageWatermark) && c.EventTime.TooLate == telemetry.TooLateSideOutput {
+		check.Assert(c.Registry.DSN != "" || c.Sink.Type == "postgres", "registry.dsn is required for side-output without the postgres sink")
+	}
+	check.Assert(c.Workers >= 0, "workers must not be negative")
+	check.Assert(c.QueueSize > 0, "queue_size must be positive")
+	check.Assert(c.ChannelSize > 0, "channel_size must be positive")
+	check.Assert(c.ShutdownTimeout > 0, "shutdown_timeout must be positive")
+	return check.Err()
+}
+
+// sourceName returns the name checkpoints and leases are recorded under:
+// the Kinesis stream, the Kafka topic or a fixed name for files.
+func (c *Config) sourceName() string {
+	switch c.Source {
+	case "kafka":
+		return c.Kafka.Topic
+	case "file":
+		return "file"
+	default:
+		return c.Kinesis.Stream
+	}
+}
+
+// workers returns the number of processing workers, one per core by default.
+func (c *Config) workers() int {
+	if c.Workers > 0 {
+		return c.Workers
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// loadConfig loads the configuration from args, the environment and the
+// configuration file, exiting with the validation errors if it is invalid.
+// Extra flags may be defined on fs beforehand.
+func loadConfig(fs *flag.FlagSet, args []string) (*Config, *config.Loader) {
+	cfg := defaultConfig()
+	loader, err := config.Load(fs, args, cfg)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	log.Println("Effective configuration:")
+	config.Print(log.Writer(), cfg)
+	return cfg, loader
+}
+
+// shutdownTimeout holds Config.ShutdownTimeout so it can change on SIGHUP.
+var shutdownTimeout atomic.Int64
+
+// applyConfig applies the settings that take effect without a restart.
+func applyConfig(cfg *Config) {
+	shutdownTimeout.Store(int64(cfg.ShutdownTimeout))
+}
+
+// reloadConfig re-reads the configuration on SIGHUP. Settings tagged reload
+// are applied; other changes are logged and wait for the next restart. An
+// invalid configuration is rejected and the current one is kept.
+func reloadConfig(loader *config.Loader, current *Config) {
+	next := defaultConfig()
+	if err := loader.Reload(next); err != nil {
+		log.Printf("Error reloading configuration, keeping the current one: %v", err)
+		return
+	}
+	reloaded, restart := config.Diff(current, next)
+	for _, key := range restart {
+		log.Printf("Configuration %s changed; restart to apply", key)
+	}
+	if len(reloaded) == 0 {
+		log.Println("Configuration reloaded, nothing to apply")
+		return
+	}
+	applyConfig(next)
+	// Only reloadable fields are copied, so goroutines started with the
+	// original values keep seeing them.
+	current.ShutdownTimeout = next.ShutdownTimeout
+	log.Printf("Configuration reloaded: %v", reloaded)
+}
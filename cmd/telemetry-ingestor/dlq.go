@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"iot-insighthub/pkg/dlq"
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/source"
+	"iot-insighthub/pkg/telemetry"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// dlqEntries reports how many records are waiting in the dead-letter queue.
+	dlqEntries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dlq_entries",
+		Help: "Number of records currently in the dead-letter queue",
+	})
+	// dlqWrites counts records written to the dead-letter queue.
+	dlqWrites = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dlq_writes_total",
+		Help: "Total number of records written to the dead-letter queue",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(dlqEntries, dlqWrites)
+}
+
+// deadLetters receives records that fail processing.
+var deadLetters dlq.Sink
+
+// newDLQSink builds the dead-letter sink selected by cfg.Sink: "dir" writes
+// one file per entry to cfg.Dir; "s3" writes to cfg.Bucket under cfg.Prefix,
+// using cfg.Endpoint for S3-compatible stores.
+func newDLQSink(cfg DLQConfig) (dlq.Sink, error) {
+	switch cfg.Sink {
+	case "s3":
+		awsCfg := aws.NewConfig()
+		if cfg.Endpoint != "" {
+			awsCfg = awsCfg.WithEndpoint(cfg.Endpoint).WithS3ForcePathStyle(true)
+		}
+		return dlq.NewS3Sink(s3.New(awsSession, awsCfg), cfg.Bucket, cfg.Prefix), nil
+	default:
+		return dlq.NewDirSink(cfg.Dir)
+	}
+}
+
+// deadLetter stores a record that failed processing.
+func deadLetter(ctx context.Context, record *source.Record, err error, attempts int) error {
+	if err := deadLetters.Put(ctx, dlq.NewEntry(record, err, attempts)); err != nil {
+		return err
+	}
+	dlqWrites.Inc()
+	return nil
+}
+
+// refreshDLQSize sets the dlq_entries gauge from the sink every interval,
+// picking up entries removed by a replay in another process. The gauge is
+// only set here: a record that fails again replaces its entry, so writes do
+// not always add one.
+func refreshDLQSize(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := deadLetters.Count(ctx); err != nil {
+			log.Printf("Error counting dead-letter entries: %v", err)
+		} else {
+			dlqEntries.Set(float64(n))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// runReplay implements the "replay" subcommand. It reprocesses every
+// dead-letter entry, deleting the ones that now succeed and recording a new
+// attempt on the ones that still fail.
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	maxAttempts := fs.Int("max-attempts", 0, "skip entries that already failed this many times (0 = no limit)")
+	dryRun := fs.Bool("dry-run", false, "list entries without reprocessing them")
+	cfg, _ := loadConfig(fs, args)
+
+	ctx := context.Background()
+	queue, err := newDLQSink(cfg.DLQ)
+	if err != nil {
+		log.Fatalf("Error creating dead-letter sink: %v", err)
+	}
+	entries, err := queue.List(ctx)
+	if err != nil {
+		log.Fatalf("Error listing dead-letter entries: %v", err)
+	}
+	log.Printf("Found %d dead-letter entries", len(entries))
+
+	writer, err := newWriter(cfg.Sink)
+	if err != nil {
+		log.Fatalf("Error creating telemetry sink: %v", err)
+	}
+	batcher := sink.NewBatcher(writer, cfg.Sink.batchConfig())
+	pipeline, err = newPipeline(cfg, false)
+	if err != nil {
+		log.Fatalf("Error building pipeline: %v", err)
+	}
+
+	var (
+		mu                        sync.Mutex
+		replayed, failed, skipped int
+	)
+	// complete deletes an entry that now succeeds, or records a new failed attempt.
+	complete := func(e dlq.Entry, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed++
+			e.Attempts++
+			e.Reason = err.Error()
+			e.FailedAt = time.Now().UTC()
+			if err := queue.Put(ctx, e); err != nil {
+				log.Printf("Error updating dead-letter entry %s: %v", e.ID, err)
+			}
+			return
+		}
+		if err := queue.Delete(ctx, e.ID); err != nil {
+			log.Printf("Error deleting replayed entry %s: %v", e.ID, err)
+			return
+		}
+		replayed++
+	}
+
+	for _, e := range entries {
+		if *maxAttempts > 0 && e.Attempts >= *maxAttempts {
+			skipped++
+			continue
+		}
+		if *dryRun {
+			log.Printf("%s %s/%s attempts=%d reason=%q", e.ID, e.Partition, e.Offset, e.Attempts, e.Reason)
+			continue
+		}
+		// Entries replay through the same pipeline as live records. A stage
+		// with the fail policy only fails the entry here: there is nothing
+		// to halt.
+		record := e.Record()
+		m := &telemetry.Message{Record: record}
+		if err := pipeline.Process(ctx, m); err != nil {
+			complete(e, err)
+			continue
+		}
+		data := storedReadings(m)
+		if len(data) == 0 {
+			complete(e, nil)
+			continue
+		}
+		e := e
+		addReadings(batcher, record, data, func(err error) { complete(e, err) })
+	}
+	if err := batcher.Close(ctx); err != nil {
+		log.Printf("Error flushing readings: %v", err)
+	}
+	if err := closePipeline(ctx); err != nil {
+		log.Printf("Error closing pipeline: %v", err)
+	}
+	log.Printf("Replay finished: %d replayed, %d still failing, %d skipped", replayed, failed, skipped)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"iot-insighthub/pkg/health"
+	"iot-insighthub/pkg/pool"
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/source"
+)
+
+// probes backs /healthz, /readyz and /statusz on the metrics server.
+var probes = health.NewChecker(2 * time.Second)
+
+// running is set once records are flowing and cleared on shutdown, so the
+// replica is only ready in between.
+var running atomic.Bool
+
+// init registers the checks that do not depend on the pipeline.
+func init() {
+	probes.AddReadiness("lifecycle", func(context.Context) (interface{}, error) {
+		if !running.Load() {
+			return nil, errors.New("not running (starting or shutting down)")
+		}
+		return nil, nil
+	})
+}
+
+// registerHealthChecks adds the checks for the pipeline's components.
+func registerHealthChecks(cfg HealthConfig, src source.Source, sourceType string, writer sink.Writer, workers *pool.Pool) {
+	if r, ok := src.(source.Reporter); ok {
+		probes.AddLiveness("partitions", partitionsCheck(r, cfg.StallTimeout))
+		// Kinesis readers mark every successful GetRecords call or
+		// subscription event, even empty ones. Kafka readers only see
+		// messages, so an idle Kafka partition would look stuck.
+		if sourceType == "kinesis" {
+			probes.AddReadiness("reads", readsCheck(r, cfg.ReadTimeout))
+		}
+	}
+	if p, ok := writer.(sink.Pinger); ok {
+		probes.AddReadiness("sink", func(ctx context.Context) (interface{}, error) {
+			return nil, p.Ping(ctx)
+		})
+	}
+	probes.AddReadiness("workers", workersCheck(workers, cfg.StallTimeout))
+}
+
+// partitionsCheck fails when an owned partition has had no reader for longer
+// than timeout. Readers do not restart while the lease is held, so only a
+// restart (which hands the lease to another replica) recovers the partition.
+// Closed shards are read to the end and then dropped at the next discovery,
+// so timeout must exceed the discovery interval.
+func partitionsCheck(r source.Reporter, timeout time.Duration) health.Check {
+	return func(context.Context) (interface{}, error) {
+		partitions := r.Partitions()
+		var stopped []string
+		for _, p := range partitions {
+			if !p.Reading && !p.Since.IsZero() && time.Since(p.Since) > timeout {
+				stopped = append(stopped, p.Partition)
+			}
+		}
+		if len(stopped) > 0 {
+			sort.Strings(stopped)
+			return partitions, fmt.Errorf("no reader for owned partitions %s", strings.Join(stopped, ", "))
+		}
+		return partitions, nil
+	}
+}
+
+// readsCheck fails when a partition that is being read has had no
+// successful read for longer than timeout.
+func readsCheck(r source.Reporter, timeout time.Duration) health.Check {
+	return func(context.Context) (interface{}, error) {
+		var stale []string
+		for _, p := range r.Partitions() {
+			if !p.Reading {
+				continue
+			}
+			last := p.LastRead
+			if last.IsZero() {
+				last = p.Since
+			}
+			if age := time.Since(last); age > timeout {
+				stale = append(stale, fmt.Sprintf("%s (%s)", p.Partition, age.Round(time.Second)))
+			}
+		}
+		if len(stale) > 0 {
+			sort.Strings(stale)
+			return nil, fmt.Errorf("no successful read from %s", strings.Join(stale, ", "))
+		}
+		return nil, nil
+	}
+}
+
+// workerStatus is the detail reported by the workers check.
+type workerStatus struct {
+	Workers int    `json:"workers"`
+	Busy    int    `json:"busy"`
+	Longest string `json:"longest_active"`
+}
+
+// workersCheck fails when a worker has been handling one record for longer
+// than timeout, which usually means the sink has stopped accepting batches.
+func workersCheck(p *pool.Pool, timeout time.Duration) health.Check {
+	return func(context.Context) (interface{}, error) {
+		status := workerStatus{Workers: p.Workers()}
+		var longest time.Duration
+		for _, d := range p.Active() {
+			if d > 0 {
+				status.Busy++
+			}
+			if d > longest {
+				longest = d
+			}
+		}
+		status.Longest = longest.Round(time.Millisecond).String()
+		if longest > timeout {
+			return status, fmt.Errorf("a worker has been processing one record for %s", longest.Round(time.Second))
+		}
+		return status, nil
+	}
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
-	"math"
 	"net/http"
 	"net/http/pprof"
-	"sync"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	awsKinesis "github.com/aws/aws-sdk-go/service/kinesis"
-	"iot-insighthub/pkg/kinesis"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/config"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/pool"
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/source"
 	"iot-insighthub/pkg/telemetry"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -30,168 +34,231 @@ func init() {
 	prometheus.MustRegister(ingestCounter)
 }
 
-// startPprofServer starts an HTTP server that exposes pprof endpoints.
-func startPprofServer() {
+// startPprofServer starts an HTTP server on addr that exposes pprof endpoints.
+func startPprofServer(addr string) *http.Server {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	log.Println("Starting pprof server on :6060")
-	if err := http.ListenAndServe(":6060", mux); err != nil {
-		log.Fatalf("pprof server failed: %v", err)
-	}
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Starting pprof server on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("pprof server failed: %v", err)
+		}
+	}()
+	return server
 }
 
-// listShards lists all the shard IDs for a given stream.
-func listShards(kc *awsKinesis.Kinesis, streamName string) ([]*string, error) {
-	input := &awsKinesis.DescribeStreamInput{
-		StreamName: aws.String(streamName),
+// startMetricsServer starts an HTTP server on addr that exposes Prometheus
+// metrics, the Kubernetes probes and a JSON view of every health check.
+func startMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/healthz", probes.LiveHandler())
+	mux.Handle("/readyz", probes.ReadyHandler())
+	mux.Handle("/statusz", probes.DetailHandler())
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Printf("Prometheus metrics server running on %s", addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("metrics server failed: %v", err)
+		}
+	}()
+	return server
+}
+
+// processRecord is the worker pool handler. It runs each record through the
+// processing pipeline and queues the resulting readings for storage. The
+// record is acknowledged only once all of its readings are committed, so the
+// checkpoint never moves past a reading that has not been stored.
+func processRecord(workerID int, record *source.Record) {
+	m, err := runPipeline(workerID, record)
+	if telemetry.Fatal(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Worker %d: Error processing record: %v", workerID, err)
+		completeRecord(record, err)
+		return
+	}
+	data := storedReadings(m)
+	if len(data) == 0 {
+		// Dropped, discarded or already stored: nothing left to write.
+		ackRecord(record)
+		return
 	}
-	result, err := kc.DescribeStream(input)
+	addReadings(readings, record, data, func(err error) { completeRecord(record, err) })
+}
+
+// completeRecord records the outcome of a record and acknowledges it.
+// Failed records are kept in the dead-letter queue, so they are acknowledged
+// as well; otherwise a single bad record would stall the checkpoint. A
+// failed record that cannot be dead-lettered is not acknowledged and halts
+// ingestion, so that it is read again rather than lost.
+func completeRecord(record *source.Record, err error) {
 	if err != nil {
-		return nil, err
+		metrics.ProcessingFailures.WithLabelValues(telemetry.Cause(err)).Inc()
+		if dlqErr := deadLetter(context.Background(), record, err, 1); dlqErr != nil {
+			log.Printf("Stopping ingestion: record %s/%s failed and could not be dead-lettered: %v", record.Partition, record.Offset, dlqErr)
+			halt()
+			return
+		}
+	} else {
+		ingestCounter.Inc()
 	}
+	ackRecord(record)
+}
 
-	var shardIDs []*string
-	for _, shard := range result.StreamDescription.Shards {
-		shardIDs = append(shardIDs, shard.ShardId)
+// ackRecord acknowledges a processed record, whatever became of it, and
+// observes its processing latency.
+func ackRecord(record *source.Record) {
+	if !record.ArrivedAt.IsZero() {
+		metrics.ProcessingLatency.Observe(time.Since(record.ArrivedAt).Seconds())
 	}
-	return shardIDs, nil
+	record.Ack()
 }
 
-// processShard continuously fetches records from a given shard.
-// It applies exponential backoff on failures and sends records to recordChan.
-func processShard(ctx context.Context, kc *awsKinesis.Kinesis, streamName string, shardID *string, recordChan chan<- *awsKinesis.Record) {
-	// Get the initial shard iterator.
-	input := &awsKinesis.GetShardIteratorInput{
-		StreamName:        aws.String(streamName),
-		ShardId:           shardID,
-		ShardIteratorType: aws.String("TRIM_HORIZON"),
+func main() {
+	// "telemetry-ingestor replay" reprocesses the dead-letter queue and exits.
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[2:])
+		return
 	}
-	output, err := kc.GetShardIterator(input)
-	if err != nil {
-		log.Printf("Error getting shard iterator for shard %s: %v", *shardID, err)
+	// "telemetry-ingestor backfill" reprocesses a past time window and exits.
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		runBackfill(os.Args[2:])
 		return
 	}
-	iterator := output.ShardIterator
 
-	// Initialize exponential backoff variables.
-	backoff := 1 * time.Second
-	const maxBackoff = 30 * time.Second
+	// Settings come from the configuration file, the environment and flags.
+	cfg, loader := loadConfig(flag.CommandLine, os.Args[1:])
+	applyConfig(cfg)
 
-	for {
-		if iterator == nil {
-			log.Printf("Shard iterator expired for shard %s", *shardID)
-			break
-		}
+	// ctx is cancelled on SIGINT or SIGTERM, which stops reading new records.
+	// Records already read are drained separately below.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// A stage with the fail policy stops reading the same way.
+	ctx, halt = context.WithCancel(ctx)
 
-		getRecordsInput := &awsKinesis.GetRecordsInput{
-			ShardIterator: iterator,
-			Limit:         aws.Int64(100), // Up to 100 records per call.
-		}
-		recordsOutput, err := kc.GetRecords(getRecordsInput)
-		if err != nil {
-			log.Printf("Error fetching records from shard %s: %v", *shardID, err)
-			time.Sleep(backoff)
-			// Increase backoff exponentially, up to maxBackoff.
-			backoff = time.Duration(math.Min(float64(maxBackoff), float64(backoff)*2))
-			continue
-		}
-		// Reset backoff after success.
-		backoff = 1 * time.Second
-
-		// Send each record to the processing channel (with non-blocking send due to buffering).
-		for _, record := range recordsOutput.Records {
-			select {
-			case recordChan <- record:
-			case <-ctx.Done():
-				return
-			}
-		}
+	// SIGHUP re-reads the configuration and applies the reloadable settings.
+	go config.OnSIGHUP(ctx, func() { reloadConfig(loader, cfg) })
 
-		iterator = recordsOutput.NextShardIterator
+	// Start Prometheus metrics server.
+	metricsServer := startMetricsServer(cfg.MetricsAddr)
 
-		// Slow down the loop if no records were returned.
-		if len(recordsOutput.Records) == 0 {
-			time.Sleep(500 * time.Millisecond)
-		}
-	}
-}
+	// Start pprof server for runtime profiling.
+	pprofServer := startPprofServer(cfg.PprofAddr)
 
-// recordWorker processes records received on recordChan.
-// It leverages the telemetry service to handle each record.
-func recordWorker(ctx context.Context, workerID int, recordChan <-chan *awsKinesis.Record, wg *sync.WaitGroup) {
-	defer wg.Done()
-	for {
-		select {
-		case record, ok := <-recordChan:
-			if !ok {
-				// Channel closed; exit worker.
-				return
-			}
-			if err := telemetry.ProcessRecord(record); err != nil {
-				log.Printf("Worker %d: Error processing record: %v", workerID, err)
-			} else {
-				ingestCounter.Inc()
-			}
-		case <-ctx.Done():
-			return
-		}
+	// Checkpoints are flushed periodically from the in-memory trackers.
+	store, err := newCheckpointStore(cfg.Checkpoint)
+	if err != nil {
+		log.Fatalf("Error creating checkpoint store: %v", err)
 	}
-}
-
-func main() {
-	ctx := context.Background()
+	cp := checkpoint.NewCheckpointer(store, cfg.sourceName())
+	go cp.Run(ctx, cfg.Checkpoint.Interval)
 
-	// Start Prometheus metrics server on port 9090.
-	go func() {
-		http.Handle("/metrics", promhttp.Handler())
-		log.Println("Prometheus metrics server running on :9090")
-		log.Fatal(http.ListenAndServe(":9090", nil))
-	}()
-
-	// Start pprof server for runtime profiling on port 6060.
-	go startPprofServer()
+	// Records that fail processing are kept for later replay.
+	deadLetters, err = newDLQSink(cfg.DLQ)
+	if err != nil {
+		log.Fatalf("Error creating dead-letter sink: %v", err)
+	}
+	go refreshDLQSize(ctx, 30*time.Second)
 
-	// Create AWS session and Kinesis client.
-	sess := session.Must(session.NewSession())
-	kc := awsKinesis.New(sess)
-	streamName := "YourKinesisStreamName"
+	// Decoded readings are written to TimescaleDB in batches.
+	writer, err := newWriter(cfg.Sink)
+	if err != nil {
+		log.Fatalf("Error creating telemetry sink: %v", err)
+	}
+	readings = sink.NewBatcher(writer, cfg.Sink.batchConfig())
+	recent = telemetry.NewRecentReadings(cfg.Sink.DedupeCacheSize)
+	pipeline, err = newPipeline(cfg, true)
+	if err != nil {
+		log.Fatalf("Error building pipeline: %v", err)
+	}
+	log.Printf("Pipeline stages: %v", pipeline.Stages())
 
-	// List all shards in the stream.
-	shardIDs, err := listShards(kc, streamName)
+	// Every source feeds the same worker pool, metrics and checkpointing.
+	src, err := newSource(cfg, cp)
 	if err != nil {
-		log.Fatalf("Error listing shards: %v", err)
+		log.Fatalf("Error creating source: %v", err)
 	}
-	log.Printf("Found %d shards", len(shardIDs))
 
 	// Create a buffered channel for records for backpressure.
-	recordChan := make(chan *awsKinesis.Record, 1000)
+	recordChan := make(chan *source.Record, cfg.ChannelSize)
+	registerChannelDepth(recordChan)
+
+	// Start a worker pool, by default with one worker per core. Records are
+	// routed by partition key or device ID so each device is processed in order.
+	// The pool keeps running after ctx is cancelled so buffered records can
+	// still be processed; drainCtx bounds how long that may take.
+	workers := pool.New(cfg.workers(), cfg.QueueSize, processRecord)
+	go sampleWorkerBusy(ctx, workers, 10*time.Second)
+	drainCtx, cancelDrain := context.WithCancel(context.Background())
+	defer cancelDrain()
+	routed := make(chan struct{})
+	go func() {
+		defer close(routed)
+		workers.Run(drainCtx, recordChan)
+	}()
+	registerHealthChecks(cfg.Health, src, cfg.Source, writer, workers)
+	running.Store(true)
 
-	// Start a worker pool to process records concurrently.
-	numWorkers := 10
-	var workerWG sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		workerWG.Add(1)
-		go recordWorker(ctx, i, recordChan, &workerWG)
+	// Read from the source until shutdown (for streams, this runs indefinitely).
+	if err := src.Run(ctx, recordChan); err != nil {
+		log.Printf("Source stopped with error: %v", err)
 	}
+	running.Store(false)
+	timeout := time.Duration(shutdownTimeout.Load())
+	log.Printf("Draining in-flight records (timeout %s)", timeout)
+	drainTimer := time.AfterFunc(timeout, cancelDrain)
+	defer drainTimer.Stop()
 
-	// Spawn a goroutine for each shard to consume records concurrently.
-	var shardWG sync.WaitGroup
-	for _, shardID := range shardIDs {
-		shardWG.Add(1)
-		go func(shardID *string) {
-			defer shardWG.Done()
-			processShard(ctx, kc, streamName, shardID, recordChan)
-		}(shardID)
+	// Close the record channel, wait for workers to finish and write the
+	// last batch. Records that are not stored before the timeout are not
+	// acknowledged, so they are read again by whichever replica takes over
+	// the partition.
+	close(recordChan)
+	<-routed
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		workers.Close()
+		if err := readings.Close(drainCtx); err != nil {
+			log.Printf("Error flushing readings: %v", err)
+		}
+		// Stages holding state, such as open windows, write it out.
+		if err := closePipeline(drainCtx); err != nil {
+			log.Printf("Error closing pipeline: %v", err)
+		}
+	}()
+	select {
+	case <-drained:
+		log.Println("All in-flight records processed")
+	case <-drainCtx.Done():
+		log.Println("Shutdown timeout reached; unprocessed records will be redelivered")
 	}
 
-	// Wait for shard processing to complete (in production, this may run indefinitely).
-	shardWG.Wait()
+	// Persist the final positions of every acknowledged record.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelFlush()
+	if err := cp.Flush(flushCtx); err != nil {
+		log.Printf("Error flushing checkpoints: %v", err)
+	}
 
-	// Close the record channel and wait for workers to finish.
-	close(recordChan)
-	workerWG.Wait()
+	// Hand leases over only after the checkpoints are written, so the next
+	// owner resumes from the final position.
+	if r, ok := src.(source.Releaser); ok {
+		r.Release(flushCtx)
+	}
+
+	for _, server := range []*http.Server{metricsServer, pprofServer} {
+		if err := server.Shutdown(flushCtx); err != nil {
+			log.Printf("Error shutting down %s server: %v", server.Addr, err)
+		}
+	}
+	log.Println("Shutdown complete")
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"iot-insighthub/pkg/pool"
+	"iot-insighthub/pkg/source"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// workerBusySeconds counts the time each worker spends processing records.
+	workerBusySeconds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "worker_busy_seconds_total",
+		Help: "Time each worker spent processing records",
+	}, []string{"worker"})
+	// workerBusyRatio reports the fraction of the last sampling interval each
+	// worker spent processing records.
+	workerBusyRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "worker_busy_ratio",
+		Help: "Fraction of the last sampling interval each worker spent processing records",
+	}, []string{"worker"})
+)
+
+func init() {
+	prometheus.MustRegister(workerBusySeconds, workerBusyRatio)
+}
+
+// registerChannelDepth exports the number of records buffered between the
+// source and the workers, and the buffer's capacity.
+func registerChannelDepth(ch chan *source.Record) {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "record_channel_depth",
+			Help: "Records buffered between the source and the workers",
+		}, func() float64 { return float64(len(ch)) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "record_channel_capacity",
+			Help: "Capacity of the buffer between the source and the workers",
+		}, func() float64 { return float64(cap(ch)) }),
+	)
+}
+
+// sampleWorkerBusy updates the worker busy metrics from p every interval
+// until ctx is cancelled.
+func sampleWorkerBusy(ctx context.Context, p *pool.Pool, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := p.Busy()
+	lastAt := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		busy, now := p.Busy(), time.Now()
+		elapsed := now.Sub(lastAt)
+		for i, d := range busy {
+			worker := strconv.Itoa(i)
+			delta := d - last[i]
+			workerBusySeconds.WithLabelValues(worker).Add(delta.Seconds())
+			workerBusyRatio.WithLabelValues(worker).Set(delta.Seconds() / elapsed.Seconds())
+		}
+		last, lastAt = busy, now
+	}
+}
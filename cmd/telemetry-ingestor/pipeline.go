@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"sync"
+
+	awsKinesis "github.com/aws/aws-sdk-go/service/kinesis"
+	"iot-insighthub/pkg/aggregate"
+	"iot-insighthub/pkg/alert"
+	"iot-insighthub/pkg/anomaly"
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/quarantine"
+	"iot-insighthub/pkg/registry"
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/source"
+	"iot-insighthub/pkg/telemetry"
+	"iot-insighthub/pkg/transform"
+	"iot-insighthub/pkg/validation"
+)
+
+// Outputs the route stage may send readings to.
+const (
+	// outputTelemetry stores readings in the telemetry table. Readings with
+	// no output go there too.
+	outputTelemetry = "telemetry"
+	// outputDiscard drops readings, for example from test devices.
+	outputDiscard = "discard"
+)
+
+// pipeline turns records into readings. It is set up in main before the
+// workers start.
+var pipeline *telemetry.Pipeline
+
+// stageDBs holds the database pools of the pipeline's stages. They are
+// closed by closePipeline.
+var stageDBs *databases
+
+// eventTime is the event-time clock of the pipeline's stages. The source
+// tells it which partitions this replica owns.
+var eventTime *telemetry.EventTime
+
+// halt stops ingestion after a stage with the fail policy rejects a record.
+// main replaces it with the cancel function of the source's context.
+var halt = func() {}
+
+// newPipeline builds the chain of stages configured in cfg.Pipeline.
+// Stages are only created if the chain uses them. Stages using the same DSN
+// share one database pool, closed by closePipeline. The watermark, aggregate
+// and alert stages share one event-time clock, which follows the wall clock
+// through quiet spells if live is set; backfills and replays are not live.
+func newPipeline(cfg *Config, live bool) (*telemetry.Pipeline, error) {
+	dbs := &databases{pools: make(map[string]*sql.DB)}
+	stageDBs = dbs
+	clock := telemetry.NewEventTime(telemetry.EventTimeConfig{
+		AllowedLateness: cfg.EventTime.AllowedLateness,
+		TooLate:         cfg.EventTime.TooLate,
+		IdleTimeout:     cfg.EventTime.IdleTimeout,
+		FollowWallClock: live,
+	})
+	eventTime = clock
+	var devices *registry.Cache
+	deviceCache := func() (*registry.Cache, error) {
+		if devices != nil {
+			return devices, nil
+		}
+		db, err := dbs.open(registryDSN(cfg))
+		if err != nil {
+			return nil, err
+		}
+		devices = registry.NewCache(registry.NewPostgresLookup(db), registry.CacheConfig{
+			Size:        cfg.Registry.CacheSize,
+			TTL:         cfg.Registry.CacheTTL,
+			NegativeTTL: cfg.Registry.NegativeTTL,
+		})
+		return devices, nil
+	}
+	b := telemetry.NewBuilder()
+	b.Register(telemetry.StageValidate, func() (telemetry.Stage, error) {
+		return newValidateStage(cfg, dbs, deviceCache)
+	})
+	b.Register(telemetry.StageWatermark, func() (telemetry.Stage, error) {
+		return newWatermarkStage(cfg, dbs, clock)
+	})
+	b.Register(telemetry.StageEnrich, func() (telemetry.Stage, error) {
+		return newEnrichStage(cfg, dbs, deviceCache)
+	})
+	b.Register(telemetry.StageTransform, func() (telemetry.Stage, error) {
+		return newTransformStage(cfg)
+	})
+	b.Register(telemetry.StageDetect, func() (telemetry.Stage, error) {
+		return newDetectStage(cfg, dbs)
+	})
+	b.Register(telemetry.StageAggregate, func() (telemetry.Stage, error) {
+		return newAggregateStage(cfg, dbs, clock)
+	})
+	b.Register(telemetry.StageAlert, func() (telemetry.Stage, error) {
+		return newAlertStage(cfg, clock)
+	})
+	b.Register(telemetry.StageRoute, func() (telemetry.Stage, error) {
+		rules, err := telemetry.ParseRouteRules(cfg.Pipeline.Routes)
+		if err != nil {
+			return nil, err
+		}
+		return &telemetry.RouteStage{Rules: rules, Default: outputTelemetry}, nil
+	})
+	for _, spec := range cfg.Pipeline.Stages {
+		b.UseSpec(spec)
+	}
+	return b.Build()
+}
+
+// closePipeline closes the pipeline's stages, then their databases.
+func closePipeline(ctx context.Context) error {
+	err := pipeline.Close(ctx)
+	if dbErr := stageDBs.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+// databases opens one pool per DSN, shared by every stage using it.
+type databases struct {
+	pools map[string]*sql.DB
+}
+
+// open returns the pool of dsn, opening it on first use.
+func (d *databases) open(dsn string) (*sql.DB, error) {
+	if db, ok := d.pools[dsn]; ok {
+		return db, nil
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	d.pools[dsn] = db
+	return db, nil
+}
+
+// Close closes every pool.
+func (d *databases) Close() error {
+	var first error
+	for _, db := range d.pools {
+		if err := db.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// registryDSN is the DSN of the database of the devices and quarantine
+// tables: cfg.Registry.DSN, or the sink's DSN.
+func registryDSN(cfg *Config) string {
+	if cfg.Registry.DSN != "" {
+		return cfg.Registry.DSN
+	}
+	return cfg.Sink.DSN
+}
+
+// newValidateStage builds the validate stage from cfg.Validation.Config,
+// or the default rules. Value ranges look device types up in the registry.
+// Invalid readings go to the quarantine table at cfg.Registry.DSN, or at the
+// sink's DSN; with neither, a record holding one is rejected.
+func newValidateStage(cfg *Config, dbs *databases, devices func() (*registry.Cache, error)) (telemetry.Stage, error) {
+	var (
+		vc  *validation.Config
+		err error
+	)
+	if cfg.Validation.Config == "" {
+		vc = &validation.Config{}
+	} else if vc, err = validation.LoadConfig(cfg.Validation.Config); err != nil {
+		return nil, err
+	}
+	var types validation.DeviceTypes
+	if len(vc.Ranges) > 0 {
+		cache, err := devices()
+		if err != nil {
+			return nil, err
+		}
+		types = cache
+	}
+	v, err := validation.New(vc, types)
+	if err != nil {
+		return nil, err
+	}
+	stage := telemetry.ValidateStage{Validator: v}
+	if cfg.Registry.DSN != "" || cfg.Sink.Type == "postgres" {
+		db, err := dbs.open(registryDSN(cfg))
+		if err != nil {
+			return nil, err
+		}
+		stage.Quarantine = quarantine.NewPostgresWriter(db)
+	}
+	return stage, nil
+}
+
+// newWatermarkStage builds the watermark stage. With the side-output
+// policy, too-late readings go to the quarantine table.
+func newWatermarkStage(cfg *Config, dbs *databases, clock *telemetry.EventTime) (telemetry.Stage, error) {
+	stage := &telemetry.WatermarkStage{Clock: clock}
+	if cfg.EventTime.TooLate == telemetry.TooLateSideOutput {
+		db, err := dbs.open(registryDSN(cfg))
+		if err != nil {
+			return nil, err
+		}
+		stage.Quarantine = quarantine.NewPostgresWriter(db)
+	}
+	return stage, nil
+}
+
+// newEnrichStage builds the enrich stage, looking devices up in the
+// devices table at cfg.Registry.DSN, or at the sink's DSN, through the
+// pipeline's device cache. Quarantined readings go to the quarantine table
+// of the same database.
+func newEnrichStage(cfg *Config, dbs *databases, devices func() (*registry.Cache, error)) (telemetry.Stage, error) {
+	cache, err := devices()
+	if err != nil {
+		return nil, err
+	}
+	db, err := dbs.open(registryDSN(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return registry.NewStage(cache, cfg.Registry.UnknownPolicy, quarantine.NewPostgresWriter(db)), nil
+}
+
+// newTransformStage builds the transform stage. Without a configuration
+// file it only converts units.
+func newTransformStage(cfg *Config) (telemetry.Stage, error) {
+	var (
+		tc  *transform.Config
+		err error
+	)
+	if cfg.Transform.Config == "" {
+		tc = &transform.Config{}
+	} else if tc, err = transform.LoadConfig(cfg.Transform.Config); err != nil {
+		return nil, err
+	}
+	return transform.NewStage(tc)
+}
+
+// newDetectStage builds the detect stage. Anomalies are stored in the
+// anomalies table at cfg.Anomaly.DSN, or at the sink's DSN, unless neither
+// is set, and published to cfg.Anomaly.EventsStream, or written to stdout.
+func newDetectStage(cfg *Config, dbs *databases) (telemetry.Stage, error) {
+	detectors, err := anomaly.LoadConfig(cfg.Anomaly.Config)
+	if err != nil {
+		return nil, err
+	}
+	var recorders []anomaly.Recorder
+	dsn := cfg.Anomaly.DSN
+	if dsn == "" && cfg.Sink.Type == "postgres" {
+		dsn = cfg.Sink.DSN
+	}
+	if dsn != "" {
+		db, err := dbs.open(dsn)
+		if err != nil {
+			return nil, err
+		}
+		recorders = append(recorders, anomaly.NewPostgresRecorder(db))
+	}
+	if cfg.Anomaly.EventsStream != "" {
+		recorders = append(recorders, anomaly.NewStreamEmitter(awsKinesis.New(awsSession), cfg.Anomaly.EventsStream))
+	} else {
+		recorders = append(recorders, anomaly.NewWriterEmitter(os.Stdout))
+	}
+	return anomaly.NewStage(detectors, recorders...), nil
+}
+
+// newAggregateStage builds the aggregate stage, writing rollups to the
+// rollup tables at cfg.Aggregate.DSN, or at the sink's DSN.
+func newAggregateStage(cfg *Config, dbs *databases, clock *telemetry.EventTime) (telemetry.Stage, error) {
+	windows, err := aggregate.ParseWindows(cfg.Aggregate.Windows)
+	if err != nil {
+		return nil, err
+	}
+	dsn := cfg.Aggregate.DSN
+	if dsn == "" {
+		dsn = cfg.Sink.DSN
+	}
+	db, err := dbs.open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate.NewStage(aggregate.Config{
+		Windows: windows,
+		Clock:   clock,
+	}, aggregate.NewPostgresWriter(db)), nil
+}
+
+// newAlertStage builds the alert stage, posting notifications to
+// cfg.Alert.WebhookURL, or writing them to stdout.
+func newAlertStage(cfg *Config, clock *telemetry.EventTime) (telemetry.Stage, error) {
+	rules, err := alert.LoadConfig(cfg.Alert.Rules)
+	if err != nil {
+		return nil, err
+	}
+	var notifier alert.Notifier = alert.NewWriterNotifier(os.Stdout)
+	if cfg.Alert.WebhookURL != "" {
+		notifier = alert.NewWebhook(cfg.Alert.WebhookURL)
+	}
+	return alert.NewStage(rules, notifier, clock), nil
+}
+
+// storedReadings returns the readings of m to store: those routed to the
+// telemetry output that are not redeliveries of a reading stored moments ago.
+// Older redeliveries are absorbed by the upsert in the sink.
+func storedReadings(m *telemetry.Message) []api.TelemetryData {
+	var out []api.TelemetryData
+	for _, r := range m.Readings {
+		if r.Output != "" && r.Output != outputTelemetry {
+			continue
+		}
+		if recent.Duplicate(r.TelemetryData) {
+			metrics.Duplicates.WithLabelValues("cache").Inc()
+			continue
+		}
+		out = append(out, r.TelemetryData)
+	}
+	return out
+}
+
+// addReadings queues data on batcher and calls done once every reading has
+// been written, with the first error if any failed. done is not called when
+// data is empty.
+func addReadings(batcher *sink.Batcher, record *source.Record, data []api.TelemetryData, done func(error)) {
+	var (
+		mu       sync.Mutex
+		pending  = len(data)
+		firstErr error
+	)
+	for _, d := range data {
+		d := d
+		batcher.Add(sink.Item{Data: d, Done: func(err error) {
+			if err != nil {
+				log.Printf("Error storing record %s/%s: %v", record.Partition, record.Offset, err)
+				err = &telemetry.ProcessingError{Cause: telemetry.CauseSink, Err: err}
+			} else {
+				recent.Stored(d)
+			}
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			pending--
+			last := pending == 0
+			mu.Unlock()
+			if last {
+				done(firstErr)
+			}
+		}})
+	}
+}
+
+// runPipeline runs record through the pipeline. A record rejected by a stage
+// with the fail policy is not acknowledged and halts ingestion, so that it
+// is read again once the cause has been fixed.
+func runPipeline(workerID int, record *source.Record) (*telemetry.Message, error) {
+	m := &telemetry.Message{Record: record}
+	err := pipeline.Process(context.Background(), m)
+	if telemetry.Fatal(err) {
+		log.Printf("Worker %d: Stopping ingestion: record %s/%s failed: %v", workerID, record.Partition, record.Offset, err)
+		halt()
+	}
+	return m, err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"database/sql"
+
+	"iot-insighthub/pkg/sink"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// readings batches decoded readings on their way to TimescaleDB.
+var readings *sink.Batcher
+
+// recent remembers recently stored readings so redeliveries can be dropped
+// before the sink. It is nil, and drops nothing, if the cache is disabled.
+var recent *telemetry.RecentReadings
+
+// newWriter builds the telemetry writer selected by cfg.Type: "postgres"
+// copies batches into the telemetry table at cfg.DSN; "discard" drops them.
+func newWriter(cfg SinkConfig) (sink.Writer, error) {
+	switch cfg.Type {
+	case "discard":
+		return sink.DiscardWriter{}, nil
+	default:
+		db, err := sql.Open("postgres", cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		// One connection per concurrent batch is enough.
+		db.SetMaxOpenConns(cfg.Writers)
+		db.SetMaxIdleConns(cfg.Writers)
+		return sink.NewPostgresWriter(db), db.Ping()
+	}
+}
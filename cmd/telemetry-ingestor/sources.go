@@ -0,0 +1,118 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	awsKinesis "github.com/aws/aws-sdk-go/service/kinesis"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/kafka"
+	"iot-insighthub/pkg/kinesis"
+	"iot-insighthub/pkg/lease"
+	"iot-insighthub/pkg/source"
+)
+
+// awsSession is shared by the Kinesis client and the DynamoDB stores.
+var awsSession = session.Must(session.NewSession())
+
+// newSource builds the record source selected by cfg.Source.
+func newSource(cfg *Config, cp *checkpoint.Checkpointer) (source.Source, error) {
+	switch cfg.Source {
+	case "kinesis":
+		partitioned, err := partitionedConfig(cfg, cp)
+		if err != nil {
+			return nil, err
+		}
+		return kinesis.NewConsumer(awsKinesis.New(awsSession), cfg.Kinesis.Stream, kinesis.ConsumerConfig{
+			Mode:         cfg.Kinesis.ConsumerMode,
+			ConsumerName: cfg.Kinesis.ConsumerName,
+			Polling: kinesis.PollingConfig{
+				MaxReadsPerSecond: cfg.Kinesis.ReadsPerSecond,
+				MaxLimit:          cfg.Kinesis.MaxRecords,
+				IdleInterval:      cfg.Kinesis.IdleInterval,
+			},
+			PartitionedConfig: partitioned,
+		}), nil
+	case "kafka":
+		partitioned, err := partitionedConfig(cfg, cp)
+		if err != nil {
+			return nil, err
+		}
+		return kafka.NewSource(cfg.Kafka.Brokers, cfg.Kafka.Topic, partitioned), nil
+	case "file":
+		// The file is newline-delimited, or "-" for stdin.
+		return source.NewFileSource(cfg.File.Path, cp), nil
+	default:
+		return nil, fmt.Errorf("unknown source %q", cfg.Source)
+	}
+}
+
+// partitionedConfig returns the lease settings shared by Kinesis and Kafka.
+// Shards and partitions are consumed only while this replica holds their
+// lease, so several replicas can share a stream without duplicating work.
+func partitionedConfig(cfg *Config, cp *checkpoint.Checkpointer) (source.PartitionedConfig, error) {
+	leases, err := newLeaseStore(cfg.Lease, cfg.sourceName())
+	if err != nil {
+		return source.PartitionedConfig{}, err
+	}
+	return source.PartitionedConfig{
+		Checkpointer:      cp,
+		Leases:            leases,
+		WorkerID:          workerID(cfg.Lease),
+		LeaseDuration:     cfg.Lease.Duration,
+		DiscoveryInterval: cfg.Lease.DiscoveryInterval,
+		OnAcquire:         eventTime.Acquire,
+		OnRelease:         eventTime.Release,
+	}, nil
+}
+
+// newCheckpointStore builds the checkpoint store selected by cfg.Store
+// ("file", "postgres" or "dynamodb").
+func newCheckpointStore(cfg CheckpointConfig) (checkpoint.Store, error) {
+	switch cfg.Store {
+	case "postgres":
+		db, err := sql.Open("postgres", cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return checkpoint.NewPostgresStore(db), db.Ping()
+	case "dynamodb":
+		return checkpoint.NewDynamoDBStore(dynamodb.New(awsSession), cfg.Table), nil
+	default:
+		return checkpoint.NewFileStore(cfg.File), nil
+	}
+}
+
+// newLeaseStore builds the lease store selected by cfg.Store ("memory",
+// "postgres" or "dynamodb"; "memory" only coordinates a single replica).
+func newLeaseStore(cfg LeaseConfig, streamName string) (lease.Store, error) {
+	switch cfg.Store {
+	case "postgres":
+		db, err := sql.Open("postgres", cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		return lease.NewPostgresStore(db, streamName), db.Ping()
+	case "dynamodb":
+		return lease.NewDynamoDBStore(dynamodb.New(awsSession), cfg.Table, streamName), nil
+	default:
+		return lease.NewMemoryStore(), nil
+	}
+}
+
+// workerID identifies this replica in the lease table. In Kubernetes the
+// hostname is the pod name, which is unique within the Deployment.
+func workerID(cfg LeaseConfig) string {
+	if cfg.WorkerID != "" {
+		return cfg.WorkerID
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("Error resolving worker ID: %v", err)
+	}
+	return host
+}
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS kinesis_checkpoints (
+    stream_name TEXT NOT NULL,
+    shard_id TEXT NOT NULL,
+    sequence_number TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (stream_name, shard_id)
+);
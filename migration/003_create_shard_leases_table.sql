@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS shard_leases (
+    stream_name TEXT NOT NULL,
+    shard_id TEXT NOT NULL,
+    owner TEXT NOT NULL DEFAULT '',
+    lease_counter BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (stream_name, shard_id)
+);
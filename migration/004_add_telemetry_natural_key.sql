@@ -0,0 +1,16 @@
+-- Readings are identified by device, timestamp and an optional message ID
+-- set by the producer. Both the ingestor and the secure API upsert on this
+-- key, so redelivered or retried readings no longer create duplicate rows.
+ALTER TABLE telemetry ADD COLUMN IF NOT EXISTS message_id TEXT NOT NULL DEFAULT '';
+
+-- Remove the duplicates stored before the key was enforced, keeping the
+-- first copy of each reading.
+DELETE FROM telemetry a
+USING telemetry b
+WHERE a.device_id = b.device_id
+  AND a.timestamp = b.timestamp
+  AND a.message_id = b.message_id
+  AND a.id > b.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_telemetry_natural_key
+    ON telemetry (device_id, timestamp, message_id);
@@ -0,0 +1,19 @@
+-- Anomalies found by the ingestor's detect stage. A reading flagged by a
+-- detector is stored once per detector; detecting it again, on a replay or
+-- backfill, updates the row.
+CREATE TABLE IF NOT EXISTS anomalies (
+    id BIGSERIAL PRIMARY KEY,
+    device_id TEXT NOT NULL,
+    timestamp TIMESTAMPTZ NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    detector TEXT NOT NULL,
+    score DOUBLE PRECISION NOT NULL,
+    reason TEXT NOT NULL,
+    detected_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_anomalies_key
+    ON anomalies (device_id, timestamp, detector);
+
+-- Dashboards list the most recent anomalies, overall or per device.
+CREATE INDEX IF NOT EXISTS idx_anomalies_timestamp ON anomalies (timestamp DESC);
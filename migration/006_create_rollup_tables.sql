@@ -0,0 +1,26 @@
+-- Rollups written by the ingestor's aggregate stage: one row per device and
+-- window, replaced when the window is aggregated again. Dashboards query
+-- these instead of raw readings. A sliding window such as 5m/1m needs a
+-- table of the same shape named telemetry_5m_1m.
+CREATE TABLE IF NOT EXISTS telemetry_1m (
+    device_id TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    window_end TIMESTAMPTZ NOT NULL,
+    count BIGINT NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    min DOUBLE PRECISION NOT NULL,
+    max DOUBLE PRECISION NOT NULL,
+    avg DOUBLE PRECISION NOT NULL,
+    last DOUBLE PRECISION NOT NULL,
+    last_time TIMESTAMPTZ NOT NULL,
+    p95 DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (device_id, window_start)
+);
+
+CREATE TABLE IF NOT EXISTS telemetry_1h (LIKE telemetry_1m INCLUDING ALL);
+CREATE TABLE IF NOT EXISTS telemetry_1d (LIKE telemetry_1m INCLUDING ALL);
+
+-- Dashboards read every device for a time range.
+CREATE INDEX IF NOT EXISTS idx_telemetry_1m_window_start ON telemetry_1m (window_start);
+CREATE INDEX IF NOT EXISTS idx_telemetry_1h_window_start ON telemetry_1h (window_start);
+CREATE INDEX IF NOT EXISTS idx_telemetry_1d_window_start ON telemetry_1d (window_start);
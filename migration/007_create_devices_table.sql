@@ -0,0 +1,14 @@
+-- The device registry: what each device measures and where it is. The
+-- ingestor's enrich stage attaches these fields to every reading as labels.
+-- Tags hold any further labels as strings, such as {"line": "3"}.
+CREATE TABLE IF NOT EXISTS devices (
+    device_id TEXT PRIMARY KEY,
+    device_type TEXT NOT NULL,
+    unit TEXT NOT NULL DEFAULT '',
+    site TEXT NOT NULL DEFAULT '',
+    asset TEXT NOT NULL DEFAULT '',
+    tags JSONB NOT NULL DEFAULT '{}',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_devices_site ON devices (site);
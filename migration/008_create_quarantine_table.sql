@@ -0,0 +1,20 @@
+-- Readings held back from the telemetry table, with the rule that caught
+-- them and why. Quarantining the same reading again for the same rule, on a
+-- replay or backfill, updates the row.
+CREATE TABLE IF NOT EXISTS quarantine (
+    id BIGSERIAL PRIMARY KEY,
+    device_id TEXT NOT NULL,
+    timestamp TIMESTAMPTZ NOT NULL,
+    message_id TEXT NOT NULL DEFAULT '',
+    value DOUBLE PRECISION NOT NULL,
+    stage TEXT NOT NULL,
+    rule TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    quarantined_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quarantine_key
+    ON quarantine (device_id, timestamp, message_id, rule);
+
+-- Operators review the most recent quarantined readings, by rule.
+CREATE INDEX IF NOT EXISTS idx_quarantine_rule_time ON quarantine (rule, quarantined_at DESC);
@@ -0,0 +1,6 @@
+-- The ingestor's transform stage calibrates readings and converts them to
+-- canonical units. value holds the corrected reading; raw_value keeps what
+-- the device sent, and is NULL when the two are the same. unit is the unit
+-- of value, or empty if unknown.
+ALTER TABLE telemetry ADD COLUMN IF NOT EXISTS raw_value DOUBLE PRECISION;
+ALTER TABLE telemetry ADD COLUMN IF NOT EXISTS unit TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,7 @@
+-- Rollups of windows still open when the ingestor shut down are written
+-- with partial set. The next rollup written for the window is added to the
+-- row instead of replacing it, and clears partial. Add the column to every
+-- sliding window table such as telemetry_5m_1m as well.
+ALTER TABLE telemetry_1m ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE telemetry_1h ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE telemetry_1d ADD COLUMN IF NOT EXISTS partial BOOLEAN NOT NULL DEFAULT false;
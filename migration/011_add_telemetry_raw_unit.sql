@@ -0,0 +1,3 @@
+-- The unit a reading was sent in, kept when the transform stage converts it
+-- to the canonical unit in unit. Empty when the two are the same.
+ALTER TABLE telemetry ADD COLUMN IF NOT EXISTS raw_unit TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,143 @@
+package aggregate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PostgresWriter writes rollups to one table per window, such as
+// telemetry_1m (see migration/006_create_rollup_tables.sql and
+// migration/010_add_rollup_partial.sql). Rollups are upserted on device and
+// window start, so rewriting a window, for example after a backfill,
+// replaces it. Merges of late readings and partial rollups are added to it,
+// and so is any rollup written over a partial one.
+type PostgresWriter struct {
+	db *sql.DB
+}
+
+// NewPostgresWriter returns a PostgresWriter that uses db.
+func NewPostgresWriter(db *sql.DB) *PostgresWriter {
+	return &PostgresWriter{db: db}
+}
+
+// rollupColumns are the columns of a rollup table, in argument order.
+const rollupColumns = "device_id, window_start, window_end, count, sum, min, max, avg, last, last_time, p95, partial"
+
+// Write upserts rollups in one transaction, with up to two statements per
+// table: one replacing rows, one adding to them.
+func (w *PostgresWriter) Write(ctx context.Context, rollups []Rollup) error {
+	// An upsert cannot touch a row twice, so each device and window keeps
+	// one rollup: the latest, with any later merges and partial rollups
+	// added to it, and added to any partial rollup before it.
+	type rowKey struct {
+		table, deviceID string
+		start           int64
+	}
+	var tables []string
+	byTable := make(map[string][]Rollup)
+	index := make(map[rowKey]int)
+	for _, r := range rollups {
+		table := r.Window.Table()
+		key := rowKey{table, r.DeviceID, r.Start.Unix()}
+		if i, ok := index[key]; ok {
+			switch prev := byTable[table][i]; {
+			case r.Merge || r.Partial:
+				r = prev.merged(r)
+			case prev.Partial:
+				// Both are added to the stored rollup, and the window is
+				// complete.
+				r = prev.merged(r)
+				r.Merge, r.Partial = true, false
+			}
+			byTable[table][i] = r
+			continue
+		}
+		if _, ok := byTable[table]; !ok {
+			tables = append(tables, table)
+		}
+		index[key] = len(byTable[table])
+		byTable[table] = append(byTable[table], r)
+	}
+
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, table := range tables {
+		var replaced, merged []Rollup
+		for _, r := range byTable[table] {
+			if r.Merge || r.Partial {
+				merged = append(merged, r)
+			} else {
+				replaced = append(replaced, r)
+			}
+		}
+		for _, rows := range [][]Rollup{replaced, merged} {
+			if len(rows) == 0 {
+				continue
+			}
+			query, args := upsertRollups(table, rows)
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return fmt.Errorf("%s: %w", table, err)
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// replaceRollup is the conflict clause of rollups that replace the row. A
+// partial row, written at shutdown, is resumed instead: the rollup is added
+// to it, and its p95 is that of the larger part.
+const replaceRollup = `ON CONFLICT (device_id, window_start) DO UPDATE SET
+    window_end = EXCLUDED.window_end,
+    count = CASE WHEN r.partial THEN r.count + EXCLUDED.count ELSE EXCLUDED.count END,
+    sum = CASE WHEN r.partial THEN r.sum + EXCLUDED.sum ELSE EXCLUDED.sum END,
+    min = CASE WHEN r.partial THEN LEAST(r.min, EXCLUDED.min) ELSE EXCLUDED.min END,
+    max = CASE WHEN r.partial THEN GREATEST(r.max, EXCLUDED.max) ELSE EXCLUDED.max END,
+    avg = CASE WHEN r.partial THEN (r.sum + EXCLUDED.sum) / (r.count + EXCLUDED.count) ELSE EXCLUDED.avg END,
+    last = CASE WHEN r.partial AND r.last_time > EXCLUDED.last_time THEN r.last ELSE EXCLUDED.last END,
+    last_time = CASE WHEN r.partial THEN GREATEST(r.last_time, EXCLUDED.last_time) ELSE EXCLUDED.last_time END,
+    p95 = CASE WHEN r.partial AND r.count > EXCLUDED.count THEN r.p95 ELSE EXCLUDED.p95 END,
+    partial = EXCLUDED.partial`
+
+// mergeRollup is the conflict clause of merges and partial rollups, which
+// add their readings to the row and keep its p95. After a merge the window
+// has closed, so the row is no longer partial; after a partial rollup more
+// may follow.
+const mergeRollup = `ON CONFLICT (device_id, window_start) DO UPDATE SET
+    count = r.count + EXCLUDED.count, sum = r.sum + EXCLUDED.sum,
+    min = LEAST(r.min, EXCLUDED.min), max = GREATEST(r.max, EXCLUDED.max),
+    avg = (r.sum + EXCLUDED.sum) / (r.count + EXCLUDED.count),
+    last = CASE WHEN EXCLUDED.last_time >= r.last_time THEN EXCLUDED.last ELSE r.last END,
+    last_time = GREATEST(r.last_time, EXCLUDED.last_time),
+    partial = EXCLUDED.partial`
+
+// upsertRollups builds the statement writing rows, all added or all
+// replacing, to table.
+func upsertRollups(table string, rows []Rollup) (string, []interface{}) {
+	var (
+		values []string
+		args   []interface{}
+	)
+	for _, r := range rows {
+		n := len(args)
+		placeholders := make([]string, 12)
+		for i := range placeholders {
+			placeholders[i] = fmt.Sprintf("$%d", n+i+1)
+		}
+		values = append(values, "("+strings.Join(placeholders, ", ")+")")
+		args = append(args, r.DeviceID, r.Start, r.End(), r.Count, r.Sum, r.Min, r.Max, r.Avg(), r.Last, time.Unix(r.LastTime, 0).UTC(), r.P95, r.Partial)
+	}
+	conflict := replaceRollup
+	if rows[0].Merge || rows[0].Partial {
+		conflict = mergeRollup
+	}
+	query := `INSERT INTO ` + table + ` AS r (` + rollupColumns + `)
+VALUES ` + strings.Join(values, ", ") + `
+` + conflict
+	return query, args
+}
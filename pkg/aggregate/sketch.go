@@ -0,0 +1,91 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchAccuracy is the relative error of quantiles estimated by a sketch.
+const sketchAccuracy = 0.01
+
+var (
+	sketchGamma   = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLnGamma = math.Log(sketchGamma)
+)
+
+// sketch estimates quantiles of a stream of values with a relative error of
+// sketchAccuracy, in the manner of DDSketch: each value is counted in a
+// logarithmically sized bucket, so memory grows with the range of the
+// values rather than their number.
+type sketch struct {
+	pos, neg map[int]int64
+	zero     int64
+	count    int64
+}
+
+func (s *sketch) add(v float64) {
+	s.count++
+	switch {
+	case v > 0:
+		if s.pos == nil {
+			s.pos = make(map[int]int64)
+		}
+		s.pos[bucketKey(v)]++
+	case v < 0:
+		if s.neg == nil {
+			s.neg = make(map[int]int64)
+		}
+		s.neg[bucketKey(-v)]++
+	default:
+		s.zero++
+	}
+}
+
+// quantile returns the estimated q-quantile, 0 <= q <= 1, or 0 if the
+// sketch is empty.
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(s.count-1))
+	var seen int64
+	// Negative values from the most negative, then zeros, then positives.
+	for _, k := range sortedBuckets(s.neg, true) {
+		if seen += s.neg[k]; seen > rank {
+			return -bucketValue(k)
+		}
+	}
+	if seen += s.zero; seen > rank {
+		return 0
+	}
+	for _, k := range sortedBuckets(s.pos, false) {
+		if seen += s.pos[k]; seen > rank {
+			return bucketValue(k)
+		}
+	}
+	return 0
+}
+
+// bucketKey returns the bucket of a positive value.
+func bucketKey(v float64) int {
+	return int(math.Ceil(math.Log(v) / sketchLnGamma))
+}
+
+// bucketValue returns the value representing bucket k, which is within
+// sketchAccuracy of every value in it.
+func bucketValue(k int) float64 {
+	return 2 * math.Pow(sketchGamma, float64(k)) / (sketchGamma + 1)
+}
+
+func sortedBuckets(buckets map[int]int64, descending bool) []int {
+	keys := make([]int, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	if descending {
+		sort.Sort(sort.Reverse(sort.IntSlice(keys)))
+	} else {
+		sort.Ints(keys)
+	}
+	return keys
+}
@@ -0,0 +1,330 @@
+package aggregate
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// Writer stores rollups. Writing a rollup again for the same device and
+// window replaces it. Implementations must be safe for concurrent use.
+type Writer interface {
+	Write(ctx context.Context, rollups []Rollup) error
+}
+
+// Config configures a Stage.
+type Config struct {
+	Windows []Window
+	// Clock tracks event time; a window closes once the watermark of the
+	// partition its device's readings come from passes its end plus Clock's
+	// allowed lateness. Without one the stage keeps its own, with no
+	// allowed lateness.
+	Clock *telemetry.EventTime
+	// FlushInterval is the longest a closed window waits before it is
+	// written (default 1s).
+	FlushInterval time.Duration
+	// BatchSize is the most rollups written at once (default 1000).
+	BatchSize int
+}
+
+// numShards is the number of independently locked parts of a Stage's state.
+const numShards = 32
+
+// maxRetries is the number of times a failed batch of rollups is retried.
+const maxRetries = 3
+
+// Stage is the pipeline's aggregate stage. It folds every reading into the
+// open windows of its device and writes each window's rollup once the
+// window closes. Readings are passed on unchanged.
+//
+// Windows close by event time, once the watermark of the partition of their
+// latest reading passes their end plus the allowed lateness, so a partition
+// behind the others keeps its windows open. A device's window is one, even
+// if its readings come from two partitions, as after a shard split.
+// Readings for a closed window are dropped, unless the too-late policy is
+// update: then they are written as a merge, added to the stored rollup.
+//
+// Open windows live in memory. On shutdown Close writes them as partial
+// rollups, and so does the release of their partition to another replica.
+// A partial rollup is added to the stored one, and the next rollup written
+// for the window is added to it, so a window that spans a restart or a
+// lease move is complete.
+type Stage struct {
+	cfg      Config
+	clock    *telemetry.EventTime
+	minSlide int64 // seconds
+	lateness int64 // seconds
+	writer   Writer
+
+	shards    [numShards]shard
+	nextSweep sync.Map // partition → *atomic.Int64
+	sweepMu   sync.Mutex
+
+	closed chan Rollup
+	done   chan struct{}
+}
+
+// shard holds the open windows of some devices, and the readings for
+// their closed windows waiting to be merged.
+type shard struct {
+	mu   sync.Mutex
+	open map[windowKey]*accumulator
+	late map[windowKey]*accumulator
+}
+
+// windowKey identifies one open window of one device.
+type windowKey struct {
+	deviceID string
+	window   int // index in Config.Windows
+	start    int64
+}
+
+// NewStage starts an aggregate stage that writes rollups with writer. cfg
+// must name at least one window.
+func NewStage(cfg Config, writer Writer) *Stage {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = telemetry.NewEventTime(telemetry.EventTimeConfig{})
+	}
+	s := &Stage{
+		cfg:      cfg,
+		clock:    cfg.Clock,
+		lateness: int64(cfg.Clock.AllowedLateness() / time.Second),
+		writer:   writer,
+		closed:   make(chan Rollup, cfg.BatchSize),
+		done:     make(chan struct{}),
+	}
+	for _, w := range cfg.Windows {
+		if slide := int64(w.Slide / time.Second); s.minSlide == 0 || slide < s.minSlide {
+			s.minSlide = slide
+		}
+	}
+	for i := range s.shards {
+		s.shards[i].open = make(map[windowKey]*accumulator)
+		s.shards[i].late = make(map[windowKey]*accumulator)
+	}
+	cfg.Clock.OnRelease(s.release)
+	go s.write()
+	return s
+}
+
+// Name returns "aggregate".
+func (*Stage) Name() string { return telemetry.StageAggregate }
+
+// Process adds the readings of m to their windows.
+func (s *Stage) Process(ctx context.Context, m *telemetry.Message) error {
+	s.clock.ObserveMessage(m)
+	partition := m.Partition()
+	wm := s.clock.PartitionWatermark(partition)
+	for _, r := range m.Readings {
+		s.add(r.DeviceID, partition, r.Value, r.Time, wm)
+	}
+	if next := s.next(partition); wm >= next.Load() {
+		s.sweep(next, wm)
+	}
+	return nil
+}
+
+// next returns the partition watermark at which partition's next sweep is
+// due.
+func (s *Stage) next(partition string) *atomic.Int64 {
+	if next, ok := s.nextSweep.Load(partition); ok {
+		return next.(*atomic.Int64)
+	}
+	next, _ := s.nextSweep.LoadOrStore(partition, new(atomic.Int64))
+	return next.(*atomic.Int64)
+}
+
+// add folds one reading from partition into its windows, given the
+// partition's watermark wm.
+func (s *Stage) add(deviceID, partition string, value float64, t, wm int64) {
+	update := s.clock.TooLatePolicy() == telemetry.TooLateUpdate
+	sh := s.shard(deviceID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for i, w := range s.cfg.Windows {
+		for _, start := range w.starts(time.Unix(t, 0)) {
+			windows := sh.open
+			if s.closes(w, start.Unix()) <= wm {
+				metrics.LateReadings.WithLabelValues(telemetry.StageAggregate).Inc()
+				if !update {
+					continue
+				}
+				windows = sh.late
+			}
+			key := windowKey{deviceID: deviceID, window: i, start: start.Unix()}
+			acc, ok := windows[key]
+			if !ok {
+				acc = &accumulator{}
+				windows[key] = acc
+			}
+			acc.partition = partition
+			acc.add(value, t)
+		}
+	}
+}
+
+// closes returns the watermark at which the window starting at start closes.
+func (s *Stage) closes(w Window, start int64) int64 {
+	return start + int64(w.Size/time.Second) + s.lateness
+}
+
+func (s *Stage) shard(deviceID string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	return &s.shards[h.Sum32()%numShards]
+}
+
+// sweep closes every window its partition's watermark has passed, and
+// writes the merges waiting. Windows of partitions released since their
+// last reading, which came in flight, are written as partial. A partition
+// triggers a sweep once per smallest slide of its event time, when windows
+// can have closed; next is when its following sweep is due and wm its
+// watermark.
+func (s *Stage) sweep(next *atomic.Int64, wm int64) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+	if wm < next.Load() {
+		return // another worker swept first
+	}
+	next.Store((floorDiv(wm, s.minSlide) + 1) * s.minSlide)
+	watermarks := make(map[string]int64)
+	s.emit(func(key windowKey, acc *accumulator) (closed, partial bool) {
+		if !s.clock.Owned(acc.partition) {
+			return true, true
+		}
+		wm, ok := watermarks[acc.partition]
+		if !ok {
+			wm = s.clock.PartitionWatermark(acc.partition)
+			watermarks[acc.partition] = wm
+		}
+		return s.closes(s.cfg.Windows[key.window], key.start) <= wm, false
+	})
+}
+
+// release writes the open windows of partition, whose lease another
+// replica now holds, as partial rollups. That replica's rollups of the same
+// windows are added to them, or they to its.
+func (s *Stage) release(partition string) {
+	s.emit(func(key windowKey, acc *accumulator) (closed, partial bool) {
+		return acc.partition == partition, true
+	})
+}
+
+// emit removes the open windows that closing reports closed, and every
+// pending merge, and queues their rollups for writing, marked partial if
+// closing says so.
+func (s *Stage) emit(closing func(key windowKey, acc *accumulator) (closed, partial bool)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		var rollups []Rollup
+		sh.mu.Lock()
+		for key, acc := range sh.open {
+			w := s.cfg.Windows[key.window]
+			if closed, partial := closing(key, acc); closed {
+				r := acc.rollup(key.deviceID, w, time.Unix(key.start, 0).UTC())
+				r.Partial = partial
+				rollups = append(rollups, r)
+				delete(sh.open, key)
+			}
+		}
+		for key, acc := range sh.late {
+			r := acc.rollup(key.deviceID, s.cfg.Windows[key.window], time.Unix(key.start, 0).UTC())
+			r.Merge = true
+			rollups = append(rollups, r)
+			delete(sh.late, key)
+		}
+		sh.mu.Unlock()
+		// Sent outside the lock: a slow writer holds up this worker, not
+		// every worker.
+		for _, r := range rollups {
+			s.closed <- r
+		}
+	}
+}
+
+// OpenWindows returns the number of windows being aggregated.
+func (s *Stage) OpenWindows() int {
+	n := 0
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+		n += len(s.shards[i].open)
+		s.shards[i].mu.Unlock()
+	}
+	return n
+}
+
+// Close writes every open window as a partial rollup, and waits until all
+// rollups are written or ctx is done. The stage must not be used after.
+func (s *Stage) Close(ctx context.Context) error {
+	s.emit(func(windowKey, *accumulator) (bool, bool) { return true, true })
+	close(s.closed)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write writes closed windows in batches until Close.
+func (s *Stage) write() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.FlushInterval)
+	defer ticker.Stop()
+	var batch []Rollup
+	for {
+		select {
+		case r, ok := <-s.closed:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			if batch = append(batch, r); len(batch) >= s.cfg.BatchSize {
+				s.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			s.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush writes batch, retrying with backoff. Rollups that still cannot be
+// written are logged and counted; they can be rebuilt with a backfill.
+func (s *Stage) flush(batch []Rollup) {
+	if len(batch) == 0 {
+		return
+	}
+	backoff := time.Second
+	var err error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = s.writer.Write(context.Background(), batch); err == nil {
+			break
+		}
+	}
+	result := "ok"
+	if err != nil {
+		log.Printf("Error writing %d rollups: %v", len(batch), err)
+		result = "failed"
+	}
+	for _, r := range batch {
+		metrics.Rollups.WithLabelValues(r.Window.Name, result).Inc()
+	}
+}
@@ -0,0 +1,252 @@
+package aggregate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/source"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// memoryWriter keeps written rollups.
+type memoryWriter struct {
+	mu      sync.Mutex
+	rollups []Rollup
+}
+
+func (w *memoryWriter) Write(ctx context.Context, rollups []Rollup) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.rollups = append(w.rollups, rollups...)
+	return nil
+}
+
+// find returns the rollup of deviceID in the window named window starting
+// at start.
+func (w *memoryWriter) find(deviceID, window string, start int64) (Rollup, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, r := range w.rollups {
+		if r.DeviceID == deviceID && r.Window.Name == window && r.Start.Unix() == start {
+			return r, true
+		}
+	}
+	return Rollup{}, false
+}
+
+// base is the start of a minute, and of a five-minute window.
+const base = 1700000100
+
+func newTestStage(t *testing.T, lateness time.Duration, specs ...string) (*Stage, *memoryWriter) {
+	t.Helper()
+	return newClockedStage(t, telemetry.NewEventTime(telemetry.EventTimeConfig{AllowedLateness: lateness}), specs...)
+}
+
+func newClockedStage(t *testing.T, clock *telemetry.EventTime, specs ...string) (*Stage, *memoryWriter) {
+	t.Helper()
+	windows, err := ParseWindows(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &memoryWriter{}
+	s := NewStage(Config{Windows: windows, Clock: clock, FlushInterval: time.Millisecond}, w)
+	return s, w
+}
+
+func send(t *testing.T, s *Stage, deviceID string, value float64, at int64) {
+	t.Helper()
+	sendFrom(t, s, "shard-1", deviceID, value, at)
+}
+
+func sendFrom(t *testing.T, s *Stage, partition, deviceID string, value float64, at int64) {
+	t.Helper()
+	m := &telemetry.Message{Record: &source.Record{Partition: partition}, Readings: []telemetry.Reading{
+		{TelemetryData: api.TelemetryData{DeviceID: deviceID, Value: value, Time: at}},
+	}}
+	if err := s.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Readings) != 1 {
+		t.Fatalf("aggregate stage changed the readings: %+v", m.Readings)
+	}
+}
+
+func TestStage_TumblingWindow(t *testing.T) {
+	s, w := newTestStage(t, 0, "1m")
+	for i, v := range []float64{4, 1, 7, 2} {
+		send(t, s, "sensor-1", v, base+int64(i)*10)
+	}
+	send(t, s, "sensor-2", 9, base+5)
+	send(t, s, "sensor-1", 3, base+60) // closes the first minute
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := w.find("sensor-1", "1m", base)
+	if !ok {
+		t.Fatalf("no rollup for sensor-1 at %d: %+v", base, w.rollups)
+	}
+	if r.Count != 4 || r.Sum != 14 || r.Min != 1 || r.Max != 7 || r.Avg() != 3.5 {
+		t.Errorf("rollup = %+v, want count 4, sum 14, min 1, max 7, avg 3.5", r)
+	}
+	if r.Last != 2 || r.LastTime != base+30 {
+		t.Errorf("last = %g at %d, want 2 at %d", r.Last, r.LastTime, base+30)
+	}
+	if r.End().Unix() != base+60 {
+		t.Errorf("end = %d, want %d", r.End().Unix(), base+60)
+	}
+	if _, ok := w.find("sensor-2", "1m", base); !ok {
+		t.Error("no rollup for sensor-2")
+	}
+	if r, ok := w.find("sensor-1", "1m", base+60); !ok || r.Count != 1 {
+		t.Errorf("Close did not write the open window: %+v", r)
+	}
+}
+
+func TestStage_SlidingWindow(t *testing.T) {
+	s, w := newTestStage(t, 0, "2m/1m")
+	send(t, s, "sensor-1", 1, base+10)
+	send(t, s, "sensor-1", 2, base+70)
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The first reading is in the windows starting a minute before base and
+	// at base; the second in those starting at base and a minute after.
+	for start, want := range map[int64]int64{base - 60: 1, base: 2, base + 60: 1} {
+		if r, ok := w.find("sensor-1", "2m/1m", start); !ok || r.Count != want {
+			t.Errorf("window at %d: count %d, want %d", start, r.Count, want)
+		}
+	}
+}
+
+func TestStage_AllowedLateness(t *testing.T) {
+	s, w := newTestStage(t, 30*time.Second, "1m")
+	send(t, s, "sensor-1", 1, base+10)
+	send(t, s, "sensor-1", 2, base+80) // within the lateness of the first minute
+	send(t, s, "sensor-1", 3, base+50) // late, but accepted
+	if s.OpenWindows() != 2 {
+		t.Fatalf("open windows = %d, want 2", s.OpenWindows())
+	}
+	send(t, s, "sensor-1", 4, base+95) // closes the first minute
+	send(t, s, "sensor-1", 5, base+20) // too late
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := w.find("sensor-1", "1m", base); !ok || r.Count != 2 || r.Sum != 4 {
+		t.Errorf("first minute = %+v, want the readings at 10s and 50s", r)
+	}
+}
+
+func TestStage_LaggingPartitionIsNotLate(t *testing.T) {
+	s, w := newTestStage(t, 0, "1m")
+	// shard-2 starts an hour behind shard-1, as in a backfill.
+	sendFrom(t, s, "shard-1", "sensor-1", 1, base+3600)
+	sendFrom(t, s, "shard-2", "sensor-2", 5, base+10)
+	sendFrom(t, s, "shard-2", "sensor-2", 6, base+20)
+	sendFrom(t, s, "shard-2", "sensor-2", 7, base+60) // closes shard-2's first minute
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := w.find("sensor-2", "1m", base); !ok || r.Count != 2 {
+		t.Errorf("lagging partition's window = %+v, want both readings", r)
+	}
+}
+
+func TestStage_UpdatePolicyMergesLateReadings(t *testing.T) {
+	clock := telemetry.NewEventTime(telemetry.EventTimeConfig{TooLate: telemetry.TooLateUpdate})
+	s, w := newClockedStage(t, clock, "1m")
+	send(t, s, "sensor-1", 1, base+10)
+	send(t, s, "sensor-1", 2, base+70) // closes the first minute
+	send(t, s, "sensor-1", 9, base+30) // too late: merged
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var merges []Rollup
+	w.mu.Lock()
+	for _, r := range w.rollups {
+		if r.Merge {
+			merges = append(merges, r)
+		}
+	}
+	w.mu.Unlock()
+	if len(merges) != 1 || merges[0].Start.Unix() != base || merges[0].Count != 1 || merges[0].Max != 9 {
+		t.Fatalf("merges = %+v, want one for the first minute holding the late reading", merges)
+	}
+	first, _ := w.find("sensor-1", "1m", base)
+	if got := first.merged(merges[0]); got.Count != 2 || got.Sum != 10 || got.Max != 9 || got.Last != 9 {
+		t.Errorf("merged rollup = %+v, want count 2, sum 10, max 9, last 9", got)
+	}
+}
+
+func TestStage_CloseWritesPartialRollups(t *testing.T) {
+	s, w := newTestStage(t, 0, "1m")
+	send(t, s, "sensor-1", 1, base+10)
+	send(t, s, "sensor-1", 2, base+70) // closes the first minute
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := w.find("sensor-1", "1m", base); !ok || r.Partial {
+		t.Errorf("closed window = %+v, want it complete", r)
+	}
+	if r, ok := w.find("sensor-1", "1m", base+60); !ok || !r.Partial {
+		t.Errorf("open window = %+v, want it written as partial", r)
+	}
+}
+
+func TestStage_OneWindowPerDeviceAcrossPartitions(t *testing.T) {
+	s, w := newTestStage(t, 0, "1m")
+	// sensor-1 moves to a child shard after a split, mid-window.
+	sendFrom(t, s, "shard-1", "sensor-1", 1, base+10)
+	sendFrom(t, s, "shard-2", "sensor-1", 2, base+20)
+	sendFrom(t, s, "shard-2", "sensor-1", 3, base+70) // closes the first minute
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var first []Rollup
+	for _, r := range w.rollups {
+		if r.Start.Unix() == base {
+			first = append(first, r)
+		}
+	}
+	if len(first) != 1 || first[0].Count != 2 || first[0].Sum != 3 || first[0].Partial {
+		t.Errorf("first minute = %+v, want one complete rollup of both readings", first)
+	}
+}
+
+func TestStage_ReleaseWritesPartialRollups(t *testing.T) {
+	clock := telemetry.NewEventTime(telemetry.EventTimeConfig{})
+	s, w := newClockedStage(t, clock, "1m")
+	sendFrom(t, s, "shard-1", "sensor-1", 1, base+10)
+	sendFrom(t, s, "shard-2", "sensor-2", 2, base+10)
+	clock.Release("shard-1")
+	if s.OpenWindows() != 1 {
+		t.Errorf("open windows = %d, want only sensor-2's", s.OpenWindows())
+	}
+	sendFrom(t, s, "shard-1", "sensor-1", 3, base+20) // still in flight
+	sendFrom(t, s, "shard-2", "sensor-2", 4, base+70) // closes the first minute
+	if err := s.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var parts int64
+	for _, r := range w.rollups {
+		if r.DeviceID != "sensor-1" {
+			continue
+		}
+		if !r.Partial {
+			t.Errorf("rollup of a released partition = %+v, want it partial", r)
+		}
+		parts += r.Count
+	}
+	if parts != 2 {
+		t.Errorf("partial rollups of sensor-1 hold %d readings, want 2", parts)
+	}
+	if r, ok := w.find("sensor-2", "1m", base); !ok || r.Partial {
+		t.Errorf("owned partition's window = %+v, want it complete", r)
+	}
+}
@@ -0,0 +1,177 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window describes windows of Size starting every Slide, aligned to the Unix
+// epoch (so daily windows are UTC days). Windows with Slide equal to Size
+// are tumbling; with a smaller Slide they are sliding and overlap, so each
+// reading belongs to Size/Slide of them.
+type Window struct {
+	Name        string
+	Size, Slide time.Duration
+}
+
+// Table returns the rollup table the windows are written to, such as
+// telemetry_1m, or telemetry_5m_1m for 5m windows sliding by 1m.
+func (w Window) Table() string {
+	return "telemetry_" + strings.ReplaceAll(w.Name, "/", "_")
+}
+
+// starts returns the starts of the windows holding time t, earliest first.
+func (w Window) starts(t time.Time) []time.Time {
+	slide := int64(w.Slide / time.Second)
+	last := floorDiv(t.Unix(), slide) * slide
+	var starts []time.Time
+	for s := last - int64(w.Size/time.Second) + slide; s <= last; s += slide {
+		starts = append(starts, time.Unix(s, 0).UTC())
+	}
+	return starts
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+var durationSpec = regexp.MustCompile(`^([0-9]+)([smhd])$`)
+
+// parseDuration parses a whole number of seconds, minutes, hours or days,
+// such as 30s, 5m, 1h or 1d.
+func parseDuration(s string) (time.Duration, error) {
+	m := durationSpec.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid duration %q (want a number followed by s, m, h or d)", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	unit := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[m[2]]
+	return time.Duration(n) * unit, nil
+}
+
+// ParseWindows parses window specs: "1m" for tumbling windows of one minute,
+// or "5m/1m" for five-minute windows sliding by one minute. The slide must
+// divide the size.
+func ParseWindows(specs []string) ([]Window, error) {
+	var windows []Window
+	seen := make(map[string]bool)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		sizeSpec, slideSpec, sliding := strings.Cut(spec, "/")
+		size, err := parseDuration(sizeSpec)
+		if err != nil {
+			return nil, err
+		}
+		slide := size
+		if sliding {
+			if slide, err = parseDuration(slideSpec); err != nil {
+				return nil, err
+			}
+			if slide > size || size%slide != 0 {
+				return nil, fmt.Errorf("window %s: the slide must divide the size", spec)
+			}
+		}
+		if seen[spec] {
+			return nil, fmt.Errorf("window %s appears more than once", spec)
+		}
+		seen[spec] = true
+		windows = append(windows, Window{Name: spec, Size: size, Slide: slide})
+	}
+	return windows, nil
+}
+
+// Rollup is the aggregate of one device's readings in one window.
+type Rollup struct {
+	DeviceID string
+	Window   Window
+	Start    time.Time
+	Count    int64
+	Sum      float64
+	Min      float64
+	Max      float64
+	// Last is the value with the latest event time, at LastTime.
+	Last     float64
+	LastTime int64
+	// P95 is the approximate 95th percentile, within 1%.
+	P95 float64
+	// Merge marks a rollup of late readings for a window already written.
+	// It is added to the stored rollup rather than replacing it; the
+	// stored P95 is kept.
+	Merge bool
+	// Partial marks the rollup of a window still open at shutdown, or when
+	// its partition moved to another replica. It is added to the stored
+	// rollup, like a merge, and the next rollup written for the window is
+	// added to it rather than replacing it, so a window spanning a restart
+	// or a lease move ends up complete.
+	Partial bool
+}
+
+// merged returns r with the readings of the merge m added.
+func (r Rollup) merged(m Rollup) Rollup {
+	r.Count += m.Count
+	r.Sum += m.Sum
+	r.Min = math.Min(r.Min, m.Min)
+	r.Max = math.Max(r.Max, m.Max)
+	if m.LastTime >= r.LastTime {
+		r.Last, r.LastTime = m.Last, m.LastTime
+	}
+	return r
+}
+
+// End returns the end of the window, exclusive.
+func (r Rollup) End() time.Time { return r.Start.Add(r.Window.Size) }
+
+// Avg returns the mean value.
+func (r Rollup) Avg() float64 { return r.Sum / float64(r.Count) }
+
+// accumulator builds a Rollup from readings.
+type accumulator struct {
+	// partition is the partition of the latest reading of an open window.
+	// Its watermark closes the window.
+	partition     string
+	count         int64
+	sum, min, max float64
+	last          float64
+	lastTime      int64
+	values        sketch
+}
+
+func (a *accumulator) add(value float64, t int64) {
+	if a.count == 0 {
+		a.min, a.max = math.Inf(1), math.Inf(-1)
+	}
+	a.count++
+	a.sum += value
+	a.min = math.Min(a.min, value)
+	a.max = math.Max(a.max, value)
+	if a.count == 1 || t >= a.lastTime {
+		a.last, a.lastTime = value, t
+	}
+	a.values.add(value)
+}
+
+func (a *accumulator) rollup(deviceID string, w Window, start time.Time) Rollup {
+	return Rollup{
+		DeviceID: deviceID,
+		Window:   w,
+		Start:    start,
+		Count:    a.count,
+		Sum:      a.sum,
+		Min:      a.min,
+		Max:      a.max,
+		Last:     a.last,
+		LastTime: a.lastTime,
+		P95:      a.values.quantile(0.95),
+	}
+}
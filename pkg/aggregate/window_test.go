@@ -0,0 +1,80 @@
+package aggregate
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseWindows_TumblingAndSliding(t *testing.T) {
+	windows, err := ParseWindows([]string{"1m", " 5m/1m", "1d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Window{
+		{Name: "1m", Size: time.Minute, Slide: time.Minute},
+		{Name: "5m/1m", Size: 5 * time.Minute, Slide: time.Minute},
+		{Name: "1d", Size: 24 * time.Hour, Slide: 24 * time.Hour},
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("got %d windows, want %d", len(windows), len(want))
+	}
+	for i := range want {
+		if windows[i] != want[i] {
+			t.Errorf("window %d = %+v, want %+v", i, windows[i], want[i])
+		}
+	}
+	if got := windows[1].Table(); got != "telemetry_5m_1m" {
+		t.Errorf("Table() = %q, want telemetry_5m_1m", got)
+	}
+}
+
+func TestParseWindows_Invalid(t *testing.T) {
+	for _, specs := range [][]string{
+		{"1x"},
+		{"0m"},
+		{"5m/2m"},
+		{"1m/5m"},
+		{"1m", "1m"},
+	} {
+		if _, err := ParseWindows(specs); err == nil {
+			t.Errorf("ParseWindows(%q) succeeded, want an error", specs)
+		}
+	}
+}
+
+func TestWindow_Starts(t *testing.T) {
+	at := time.Unix(1700000130, 0) // 2 minutes 10 seconds past a 5-minute boundary
+	tumbling := Window{Size: time.Minute, Slide: time.Minute}
+	if got := tumbling.starts(at); len(got) != 1 || got[0].Unix() != 1700000100 {
+		t.Errorf("tumbling starts = %v, want [1700000100]", got)
+	}
+	sliding := Window{Size: 3 * time.Minute, Slide: time.Minute}
+	got := sliding.starts(at)
+	want := []int64{1699999980, 1700000040, 1700000100}
+	if len(got) != len(want) {
+		t.Fatalf("sliding starts = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].Unix() != want[i] {
+			t.Errorf("sliding start %d = %d, want %d", i, got[i].Unix(), want[i])
+		}
+	}
+}
+
+func TestSketch_P95WithinAccuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var s sketch
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = rng.ExpFloat64()*20 - 5
+		s.add(values[i])
+	}
+	sort.Float64s(values)
+	exact := values[int(0.95*float64(len(values)-1))]
+	if got := s.quantile(0.95); math.Abs(got-exact) > sketchAccuracy*math.Abs(exact) {
+		t.Errorf("p95 = %g, want %g within %g%%", got, exact, sketchAccuracy*100)
+	}
+}
@@ -0,0 +1,215 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+)
+
+// Notification reports the alerts of one group: those firing, and those
+// resolved since the group was last notified. Status is "firing" if any
+// alert is firing, else "resolved".
+type Notification struct {
+	Status      string            `json:"status"`
+	GroupKey    string            `json:"group_key"`
+	GroupLabels map[string]string `json:"group_labels"`
+	Alerts      []Alert           `json:"alerts"`
+}
+
+// Notifier delivers notifications. Implementations must be safe for
+// concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Webhook posts each notification as JSON to a URL. Any status other than
+// 2xx is an error.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook returns a Webhook posting to url.
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Notify posts n to the webhook.
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// WriterNotifier writes each notification as a line of JSON to an
+// io.Writer. It stands in for a webhook in local development.
+type WriterNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterNotifier returns a WriterNotifier that writes to w.
+func NewWriterNotifier(w io.Writer) *WriterNotifier {
+	return &WriterNotifier{w: w}
+}
+
+// Notify writes n to the writer.
+func (n *WriterNotifier) Notify(ctx context.Context, note Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return json.NewEncoder(n.w).Encode(note)
+}
+
+// dispatcher groups alert changes into notifications. Alerts whose GroupBy
+// labels have the same values share a group; a change to a group is
+// notified after GroupWait, together with any other changes made
+// meanwhile, and a group with firing alerts is notified again every
+// RepeatInterval.
+type dispatcher struct {
+	cfg      *Config
+	notifier Notifier
+
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+type group struct {
+	labels   map[string]string
+	firing   map[string]Alert // by fingerprint
+	resolved map[string]Alert // since the last notification
+	changed  bool
+	due      time.Time
+	lastSent time.Time
+}
+
+func newDispatcher(cfg *Config, notifier Notifier) *dispatcher {
+	return &dispatcher{cfg: cfg, notifier: notifier, groups: make(map[string]*group)}
+}
+
+// add records a firing or resolved alert at wall time now.
+func (d *dispatcher) add(a Alert, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	key, labels := d.groupOf(a)
+	g, ok := d.groups[key]
+	if !ok {
+		g = &group{labels: labels, firing: make(map[string]Alert), resolved: make(map[string]Alert)}
+		d.groups[key] = g
+	}
+	if a.State == StateFiring {
+		g.firing[a.Fingerprint] = a
+		delete(g.resolved, a.Fingerprint)
+	} else {
+		delete(g.firing, a.Fingerprint)
+		g.resolved[a.Fingerprint] = a
+	}
+	if !g.changed {
+		g.changed = true
+		g.due = now.Add(d.cfg.GroupWait)
+	}
+}
+
+// groupOf returns the key and labels of the group a belongs to.
+func (d *dispatcher) groupOf(a Alert) (string, map[string]string) {
+	labels := make(map[string]string, len(d.cfg.GroupBy))
+	parts := make([]string, len(d.cfg.GroupBy))
+	for i, name := range d.cfg.GroupBy {
+		labels[name] = a.Labels[name]
+		parts[i] = fmt.Sprintf("%s=%q", name, a.Labels[name])
+	}
+	return "{" + strings.Join(parts, ",") + "}", labels
+}
+
+// flush sends the notifications due at now, or every pending one if all
+// is set. A group whose notification fails is retried after GroupWait.
+func (d *dispatcher) flush(ctx context.Context, now time.Time, all bool) {
+	d.mu.Lock()
+	var notes []Notification
+	for key, g := range d.groups {
+		repeat := len(g.firing) > 0 && !g.lastSent.IsZero() && now.Sub(g.lastSent) >= d.cfg.RepeatInterval
+		if !(g.changed && (all || !now.Before(g.due))) && !repeat {
+			continue
+		}
+		notes = append(notes, g.notification(key))
+		g.changed = false
+		g.resolved = make(map[string]Alert)
+		g.lastSent = now
+		if len(g.firing) == 0 {
+			delete(d.groups, key)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, n := range notes {
+		if err := d.notifier.Notify(ctx, n); err != nil {
+			log.Printf("Error notifying alert group %s: %v", n.GroupKey, err)
+			metrics.AlertNotifications.WithLabelValues("failed").Inc()
+			d.retry(n, now)
+			continue
+		}
+		metrics.AlertNotifications.WithLabelValues("ok").Inc()
+	}
+}
+
+// retry puts the changes notified by n back into their group, unless the
+// alerts have changed again since.
+func (d *dispatcher) retry(n Notification, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	g, ok := d.groups[n.GroupKey]
+	if !ok {
+		g = &group{labels: n.GroupLabels, firing: make(map[string]Alert), resolved: make(map[string]Alert)}
+		d.groups[n.GroupKey] = g
+	}
+	for _, a := range n.Alerts {
+		_, firing := g.firing[a.Fingerprint]
+		_, resolved := g.resolved[a.Fingerprint]
+		if a.State == StateResolved && !firing && !resolved {
+			g.resolved[a.Fingerprint] = a
+		}
+	}
+	g.changed = true
+	g.due = now.Add(d.cfg.GroupWait)
+	g.lastSent = time.Time{}
+}
+
+func (g *group) notification(key string) Notification {
+	n := Notification{Status: string(StateResolved), GroupKey: key, GroupLabels: g.labels}
+	if len(g.firing) > 0 {
+		n.Status = string(StateFiring)
+	}
+	for _, a := range g.firing {
+		n.Alerts = append(n.Alerts, a)
+	}
+	for _, a := range g.resolved {
+		n.Alerts = append(n.Alerts, a)
+	}
+	sort.Slice(n.Alerts, func(i, j int) bool { return n.Alerts[i].Fingerprint < n.Alerts[j].Fingerprint })
+	return n
+}
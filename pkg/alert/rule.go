@@ -0,0 +1,173 @@
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"iot-insighthub/pkg/config"
+)
+
+// LabelDeviceID and LabelAlertName are the labels every alert carries,
+// naming its device and its rule. Rules can match on the device ID label
+// like any other.
+const (
+	LabelDeviceID  = "device_id"
+	LabelAlertName = "alertname"
+)
+
+// Config holds the alerting rules and how their alerts are notified.
+//
+//	group_by: [alertname, site]
+//	rules:
+//	  - name: boiler-overheating
+//	    match: {site: A}
+//	    condition: value > 80
+//	    for: 5m
+//	    labels: {severity: critical}
+//	  - name: device-silent
+//	    absent: 10m
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+	// GroupBy lists the labels whose values put alerts into the same
+	// notification (default alertname).
+	GroupBy []string `yaml:"group_by"`
+	// GroupWait is how long changes to a group are collected before they are
+	// notified (default 30s).
+	GroupWait time.Duration `yaml:"group_wait"`
+	// RepeatInterval is how often a group with firing alerts is notified
+	// again while nothing changes (default 4h).
+	RepeatInterval time.Duration `yaml:"repeat_interval"`
+}
+
+// Rule is one alerting rule, on the devices whose labels include Match. A
+// rule has either a Condition, which must hold for For before the alert
+// fires, or Absent, which fires when a device sends nothing for that long.
+type Rule struct {
+	Name  string            `yaml:"name"`
+	Match map[string]string `yaml:"match"`
+	// Condition compares a reading's value with a number, such as
+	// "value > 80". The operators are >, >=, <, <=, == and !=.
+	Condition string `yaml:"condition"`
+	// For is how long, in event time, the condition must hold before the
+	// alert fires. Until then the alert is pending.
+	For time.Duration `yaml:"for"`
+	// Absent is how long, in event time, a device must be silent for the
+	// alert to fire. Only devices that have sent a reading are watched.
+	Absent time.Duration `yaml:"absent"`
+	// Labels are added to the alert's labels, replacing reading labels of
+	// the same name.
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	cond condition
+}
+
+// condition is a parsed Rule.Condition.
+type condition struct {
+	op    string
+	value float64
+}
+
+var conditionSpec = regexp.MustCompile(`^\s*value\s*(>=|<=|==|!=|>|<)\s*(\S+)\s*$`)
+
+func parseCondition(s string) (condition, error) {
+	m := conditionSpec.FindStringSubmatch(s)
+	if m == nil {
+		return condition{}, fmt.Errorf("invalid condition %q (want value, an operator and a number, such as value > 80)", s)
+	}
+	v, err := strconv.ParseFloat(m[2], 64)
+	if err != nil {
+		return condition{}, fmt.Errorf("invalid condition %q: %q is not a number", s, m[2])
+	}
+	return condition{op: m[1], value: v}, nil
+}
+
+func (c condition) holds(v float64) bool {
+	switch c.op {
+	case ">":
+		return v > c.value
+	case ">=":
+		return v >= c.value
+	case "<":
+		return v < c.value
+	case "<=":
+		return v <= c.value
+	case "==":
+		return v == c.value
+	default:
+		return v != c.value
+	}
+}
+
+// matches reports whether labels include every label of r.Match.
+func (r *Rule) matches(labels map[string]string) bool {
+	for k, v := range r.Match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadConfig reads a Config from the YAML file at path with
+// config.LoadYAML.
+func LoadConfig(path string) (*Config, error) {
+	var c Config
+	if err := config.LoadYAML(path, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate reports every invalid rule, and parses the conditions of the
+// valid ones. Unset settings take their defaults.
+func (c *Config) Validate() error {
+	if len(c.GroupBy) == 0 {
+		c.GroupBy = []string{LabelAlertName}
+	}
+	if c.GroupWait <= 0 {
+		c.GroupWait = 30 * time.Second
+	}
+	if c.RepeatInterval <= 0 {
+		c.RepeatInterval = 4 * time.Hour
+	}
+	var problems []string
+	seen := make(map[string]bool)
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		where := fmt.Sprintf("rules[%d]", i)
+		if r.Name == "" {
+			problems = append(problems, fmt.Sprintf("%s: name is required", where))
+		} else if seen[r.Name] {
+			problems = append(problems, fmt.Sprintf("%s: rule %s appears more than once", where, r.Name))
+		}
+		seen[r.Name] = true
+		switch {
+		case r.Condition != "" && r.Absent > 0:
+			problems = append(problems, fmt.Sprintf("%s: a rule has a condition or absent, not both", where))
+		case r.Condition != "":
+			cond, err := parseCondition(r.Condition)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+			}
+			r.cond = cond
+		case r.Absent > 0:
+			if r.For != 0 {
+				problems = append(problems, fmt.Sprintf("%s: for does not apply to absent", where))
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s: condition or absent is required", where))
+		}
+		if r.For < 0 {
+			problems = append(problems, fmt.Sprintf("%s: for must not be negative", where))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package alert
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/config/configtest"
+)
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	var cfg Config
+	err := configtest.LoadYAML(t, `
+rules:
+  - name: hot
+    match: {site: A}
+    condition: value >= 80.5
+    for: 5m
+  - name: silent
+    absent: 10m
+`, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Rules) != 2 || cfg.Rules[0].For != 5*time.Minute || cfg.Rules[1].Absent != 10*time.Minute {
+		t.Fatalf("rules = %+v", cfg.Rules)
+	}
+	if c := cfg.Rules[0].cond; c.op != ">=" || c.value != 80.5 {
+		t.Errorf("condition = %+v, want >= 80.5", c)
+	}
+	if len(cfg.GroupBy) != 1 || cfg.GroupBy[0] != LabelAlertName || cfg.GroupWait != 30*time.Second || cfg.RepeatInterval != 4*time.Hour {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	for yaml, want := range map[string]string{
+		"rules:\n  - condition: value > 1\n":                                 "name is required",
+		"rules:\n  - name: a\n    condition: value ~ 1\n":                    "invalid condition",
+		"rules:\n  - name: a\n    condition: value > x\n":                    "not a number",
+		"rules:\n  - name: a\n":                                              "condition or absent is required",
+		"rules:\n  - name: a\n    condition: value > 1\n    absent: 1m\n":    "not both",
+		"rules:\n  - name: a\n    absent: 1m\n  - name: a\n    absent: 2m\n": "more than once",
+	} {
+		err := configtest.LoadYAML(t, yaml, &Config{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadConfig(%q) error = %v, want %q", yaml, err, want)
+		}
+	}
+}
+
+func TestCondition_Operators(t *testing.T) {
+	for spec, want := range map[string][3]bool{
+		"value > 1":  {false, false, true},
+		"value>=1":   {false, true, true},
+		"value < 1":  {true, false, false},
+		"value <= 1": {true, true, false},
+		"value == 1": {false, true, false},
+		"value != 1": {true, false, true},
+	} {
+		c, err := parseCondition(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range []float64{0, 1, 2} {
+			if got := c.holds(v); got != want[i] {
+				t.Errorf("%q holds(%g) = %v, want %v", spec, v, got, want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package alert
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// State is where an alert is in its lifecycle: pending while its condition
+// holds for less than the rule's For, then firing until the condition no
+// longer holds, then resolved.
+type State string
+
+// Alert states.
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert is the alert of one rule on one device. There is at most one
+// active alert per rule and device, identified by Fingerprint, so repeated
+// readings breaching a rule do not raise new alerts.
+type Alert struct {
+	Rule        string            `json:"rule"`
+	DeviceID    string            `json:"device_id"`
+	Fingerprint string            `json:"fingerprint"`
+	State       State             `json:"state"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Value is the latest value for which the condition held. Absence
+	// alerts have none.
+	Value float64 `json:"value"`
+	// ActiveAt is when the condition began to hold, in event time, or when
+	// the device last sent a reading, for an absence alert.
+	ActiveAt   time.Time  `json:"active_at"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// evalInterval is how often absence rules are evaluated and due
+// notifications sent.
+const evalInterval = time.Second
+
+// Stage is the pipeline's alert stage. It evaluates every reading against
+// the rules matching its device's labels (the reading's labels plus
+// device_id) and moves each rule's alert for the device through its
+// lifecycle. Changes to firing and resolved are notified in groups by a
+// background loop, which also fires absence alerts. Readings are passed on
+// unchanged.
+//
+// Alerts follow event time, so a backfill raises the alerts its readings
+// would have raised live. A device's readings are evaluated in time order:
+// one older than the latest evaluated for the device cannot change its
+// alerts and is skipped. A device is absent once the pipeline's watermark
+// is Absent past its latest reading.
+//
+// Alert state lives in memory: after a restart, conditions that still hold
+// are pending again, and absence is only watched for devices heard from
+// since. A device is watched by the replica reading the partition of its
+// latest reading: its state is forgotten when the partition's lease moves
+// to another replica.
+type Stage struct {
+	cfg      *Config
+	clock    *telemetry.EventTime
+	dispatch *dispatcher
+	now      func() time.Time // wall time, for notifications
+
+	mu      sync.RWMutex
+	devices map[string]*deviceState
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// deviceState holds a device's alerts by rule name.
+type deviceState struct {
+	mu        sync.Mutex
+	partition string // of the latest reading
+	labels    map[string]string
+	lastEvent time.Time
+	alerts    map[string]*Alert
+}
+
+// NewStage starts an alert stage using cfg, which must be valid, and
+// sending notifications with notifier. clock is the pipeline's event time;
+// if nil, the stage keeps its own, which follows the wall clock when the
+// stream goes quiet.
+func NewStage(cfg *Config, notifier Notifier, clock *telemetry.EventTime) *Stage {
+	if clock == nil {
+		clock = telemetry.NewEventTime(telemetry.EventTimeConfig{FollowWallClock: true})
+	}
+	s := &Stage{
+		cfg:      cfg,
+		clock:    clock,
+		dispatch: newDispatcher(cfg, notifier),
+		now:      time.Now,
+		devices:  make(map[string]*deviceState),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	clock.OnRelease(s.release)
+	go s.loop()
+	return s
+}
+
+// Name returns "alert".
+func (*Stage) Name() string { return telemetry.StageAlert }
+
+// Process evaluates the readings of m.
+func (s *Stage) Process(ctx context.Context, m *telemetry.Message) error {
+	s.clock.ObserveMessage(m)
+	now := s.now()
+	for _, r := range m.Readings {
+		s.observe(m.Partition(), r, now)
+	}
+	return nil
+}
+
+// observe evaluates every rule against r, which arrived from partition at
+// now.
+func (s *Stage) observe(partition string, r telemetry.Reading, now time.Time) {
+	labels := make(map[string]string, len(r.Labels)+1)
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	labels[LabelDeviceID] = r.DeviceID
+	at := time.Unix(r.Time, 0).UTC()
+
+	state := s.state(r.DeviceID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if at.Before(state.lastEvent) {
+		metrics.LateReadings.WithLabelValues(telemetry.StageAlert).Inc()
+		return
+	}
+	state.partition = partition
+	state.labels = labels
+	state.lastEvent = at
+	for i := range s.cfg.Rules {
+		rule := &s.cfg.Rules[i]
+		a := state.alerts[rule.Name]
+		switch {
+		case rule.Absent > 0:
+			// The device is no longer silent.
+			if a != nil && at.After(a.ActiveAt) {
+				s.end(state, a, at, now)
+			}
+		case !rule.matches(labels) || !rule.cond.holds(r.Value):
+			if a != nil {
+				s.end(state, a, at, now)
+			}
+		default:
+			if a == nil {
+				a = newAlert(rule, r.DeviceID, labels, at)
+				state.alerts[rule.Name] = a
+				metrics.AlertTransitions.WithLabelValues(rule.Name, string(StatePending)).Inc()
+			}
+			if at.Before(a.ActiveAt) {
+				a.ActiveAt = at
+			}
+			a.Value = r.Value
+			if a.State == StatePending && at.Sub(a.ActiveAt) >= rule.For {
+				s.fire(a, at, now)
+			}
+		}
+	}
+}
+
+func newAlert(rule *Rule, deviceID string, labels map[string]string, activeAt time.Time) *Alert {
+	a := &Alert{
+		Rule:        rule.Name,
+		DeviceID:    deviceID,
+		Fingerprint: rule.Name + "/" + deviceID,
+		State:       StatePending,
+		Labels:      make(map[string]string, len(labels)+len(rule.Labels)+1),
+		Annotations: rule.Annotations,
+		ActiveAt:    activeAt,
+	}
+	for k, v := range labels {
+		a.Labels[k] = v
+	}
+	for k, v := range rule.Labels {
+		a.Labels[k] = v
+	}
+	a.Labels[LabelAlertName] = rule.Name
+	return a
+}
+
+// fire moves a to firing at time at, notifying the change at wall time now.
+func (s *Stage) fire(a *Alert, at, now time.Time) {
+	a.State = StateFiring
+	a.FiredAt = &at
+	metrics.AlertTransitions.WithLabelValues(a.Rule, string(StateFiring)).Inc()
+	s.dispatch.add(*a, now)
+}
+
+// end removes a from state. A firing alert is resolved at time at, and the
+// change notified; a pending one is dropped.
+func (s *Stage) end(state *deviceState, a *Alert, at, now time.Time) {
+	delete(state.alerts, a.Rule)
+	if a.State != StateFiring {
+		return
+	}
+	a.State = StateResolved
+	a.ResolvedAt = &at
+	metrics.AlertTransitions.WithLabelValues(a.Rule, string(StateResolved)).Inc()
+	s.dispatch.add(*a, now)
+}
+
+// state returns the state of deviceID, creating it if needed.
+func (s *Stage) state(deviceID string) *deviceState {
+	s.mu.RLock()
+	state, ok := s.devices[deviceID]
+	s.mu.RUnlock()
+	if ok {
+		return state
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok = s.devices[deviceID]; !ok {
+		state = &deviceState{alerts: make(map[string]*Alert)}
+		s.devices[deviceID] = state
+	}
+	return state
+}
+
+// release forgets the devices whose latest reading came from partition,
+// which another replica now reads.
+func (s *Stage) release(partition string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for deviceID, state := range s.devices {
+		state.mu.Lock()
+		if state.partition == partition {
+			delete(s.devices, deviceID)
+		}
+		state.mu.Unlock()
+	}
+}
+
+// loop runs tick every evalInterval until Close.
+func (s *Stage) loop() {
+	defer close(s.done)
+	ticker := time.NewTicker(evalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.tick(s.now())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// tick fires the absence alerts of devices silent at the watermark and
+// sends the notifications due at wall time now. Devices whose readings
+// still in flight come from a released partition are not watched.
+func (s *Stage) tick(now time.Time) {
+	wm := time.Unix(s.clock.Watermark(), 0).UTC()
+	s.mu.RLock()
+	states := make([]*deviceState, 0, len(s.devices))
+	for _, state := range s.devices {
+		states = append(states, state)
+	}
+	s.mu.RUnlock()
+
+	for _, state := range states {
+		state.mu.Lock()
+		if !s.clock.Owned(state.partition) {
+			state.mu.Unlock()
+			continue
+		}
+		for i := range s.cfg.Rules {
+			rule := &s.cfg.Rules[i]
+			if rule.Absent <= 0 || state.alerts[rule.Name] != nil || !rule.matches(state.labels) {
+				continue
+			}
+			if wm.Sub(state.lastEvent) >= rule.Absent {
+				a := newAlert(rule, state.labels[LabelDeviceID], state.labels, state.lastEvent)
+				state.alerts[rule.Name] = a
+				s.fire(a, state.lastEvent.Add(rule.Absent), now)
+			}
+		}
+		state.mu.Unlock()
+	}
+	s.dispatch.flush(context.Background(), now, false)
+}
+
+// Alerts returns the pending and firing alerts, by fingerprint.
+func (s *Stage) Alerts() []Alert {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var alerts []Alert
+	for _, state := range s.devices {
+		state.mu.Lock()
+		for _, a := range state.alerts {
+			alerts = append(alerts, *a)
+		}
+		state.mu.Unlock()
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Fingerprint < alerts[j].Fingerprint })
+	return alerts
+}
+
+// Close stops evaluating and sends every pending notification, without
+// waiting for its group. The stage must not be used after.
+func (s *Stage) Close(ctx context.Context) error {
+	close(s.stop)
+	<-s.done
+	s.dispatch.flush(ctx, s.now(), true)
+	return ctx.Err()
+}
@@ -0,0 +1,298 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/config/configtest"
+	"iot-insighthub/pkg/source"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// memoryNotifier keeps sent notifications.
+type memoryNotifier struct {
+	mu    sync.Mutex
+	notes []Notification
+	err   error
+}
+
+func (n *memoryNotifier) Notify(ctx context.Context, note Notification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.notes = append(n.notes, note)
+	return nil
+}
+
+// start is the wall time the tests begin at.
+var start = time.Unix(1700000000, 0)
+
+// newTestStage returns a stage without its background loop, whose wall
+// clock the test sets with *now and advances with tick. Event time advances
+// with the readings sent.
+func newTestStage(t *testing.T, yaml string) (*Stage, *memoryNotifier, *time.Time) {
+	t.Helper()
+	cfg := &Config{}
+	if err := configtest.LoadYAML(t, yaml, cfg); err != nil {
+		t.Fatal(err)
+	}
+	n := &memoryNotifier{}
+	now := start
+	s := &Stage{
+		cfg:      cfg,
+		clock:    telemetry.NewEventTime(telemetry.EventTimeConfig{}),
+		dispatch: newDispatcher(cfg, n),
+		now:      func() time.Time { return now },
+		devices:  make(map[string]*deviceState),
+	}
+	s.clock.OnRelease(s.release)
+	return s, n, &now
+}
+
+func send(t *testing.T, s *Stage, deviceID, site string, value float64, at int64) {
+	t.Helper()
+	sendFrom(t, s, "shard-1", deviceID, site, value, at)
+}
+
+func sendFrom(t *testing.T, s *Stage, partition, deviceID, site string, value float64, at int64) {
+	t.Helper()
+	m := &telemetry.Message{Record: &source.Record{Partition: partition}, Readings: []telemetry.Reading{{
+		TelemetryData: api.TelemetryData{DeviceID: deviceID, Value: value, Time: at},
+		Labels:        map[string]string{"site": site},
+	}}}
+	if err := s.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const thresholdRules = `
+group_wait: 10s
+group_by: [alertname, site]
+rules:
+  - name: hot
+    match: {site: A}
+    condition: value > 80
+    for: 5m
+    labels: {severity: critical}
+`
+
+func TestStage_PendingFiringResolved(t *testing.T) {
+	s, n, now := newTestStage(t, thresholdRules)
+	at := start.Unix()
+
+	send(t, s, "boiler-1", "A", 85, at)
+	send(t, s, "boiler-2", "B", 99, at) // not on site A
+	alerts := s.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StatePending || alerts[0].Fingerprint != "hot/boiler-1" {
+		t.Fatalf("alerts = %+v, want boiler-1 pending", alerts)
+	}
+
+	send(t, s, "boiler-1", "A", 90, at+240)
+	if s.Alerts()[0].State != StatePending {
+		t.Fatal("alert fired before the condition held for 5m")
+	}
+	send(t, s, "boiler-1", "A", 95, at+300)
+	send(t, s, "boiler-1", "A", 96, at+310) // still firing: no new alert
+	a := s.Alerts()[0]
+	if a.State != StateFiring || a.FiredAt.Unix() != at+300 || a.Value != 96 {
+		t.Fatalf("alert = %+v, want firing since %d", a, at+300)
+	}
+	if a.Labels["severity"] != "critical" || a.Labels[LabelAlertName] != "hot" || a.Labels[LabelDeviceID] != "boiler-1" {
+		t.Errorf("labels = %v", a.Labels)
+	}
+
+	s.tick(*now)
+	if len(n.notes) != 0 {
+		t.Fatal("notified before group_wait")
+	}
+	*now = now.Add(10 * time.Second)
+	s.tick(*now)
+	if len(n.notes) != 1 || n.notes[0].Status != "firing" || len(n.notes[0].Alerts) != 1 {
+		t.Fatalf("notifications = %+v, want one firing", n.notes)
+	}
+	if got := n.notes[0].GroupLabels; got["alertname"] != "hot" || got["site"] != "A" {
+		t.Errorf("group labels = %v", got)
+	}
+
+	send(t, s, "boiler-1", "A", 70, at+320)
+	if len(s.Alerts()) != 0 {
+		t.Fatalf("alerts = %+v, want none after resolving", s.Alerts())
+	}
+	*now = now.Add(10 * time.Second)
+	s.tick(*now)
+	if len(n.notes) != 2 || n.notes[1].Status != "resolved" || n.notes[1].Alerts[0].ResolvedAt.Unix() != at+320 {
+		t.Fatalf("notifications = %+v, want firing then resolved", n.notes)
+	}
+}
+
+func TestStage_PendingAlertDroppedSilently(t *testing.T) {
+	s, n, now := newTestStage(t, thresholdRules)
+	send(t, s, "boiler-1", "A", 85, start.Unix())
+	send(t, s, "boiler-1", "A", 60, start.Unix()+60)
+	*now = now.Add(time.Minute)
+	s.tick(*now)
+	if len(s.Alerts()) != 0 || len(n.notes) != 0 {
+		t.Errorf("alerts = %+v, notifications = %+v, want none", s.Alerts(), n.notes)
+	}
+}
+
+func TestStage_GroupsAlerts(t *testing.T) {
+	s, n, now := newTestStage(t, `
+group_wait: 10s
+rules:
+  - name: hot
+    condition: value > 80
+`)
+	send(t, s, "boiler-1", "A", 85, start.Unix())
+	send(t, s, "boiler-2", "B", 85, start.Unix())
+	*now = now.Add(10 * time.Second)
+	s.tick(*now)
+	if len(n.notes) != 1 || len(n.notes[0].Alerts) != 2 {
+		t.Fatalf("notifications = %+v, want one with both alerts", n.notes)
+	}
+}
+
+func TestStage_RepeatsAndRetries(t *testing.T) {
+	s, n, now := newTestStage(t, `
+group_wait: 10s
+repeat_interval: 1h
+rules:
+  - name: hot
+    condition: value > 80
+`)
+	send(t, s, "boiler-1", "A", 85, start.Unix())
+	n.err = errors.New("unavailable")
+	*now = now.Add(10 * time.Second)
+	s.tick(*now)
+	n.err = nil
+	*now = now.Add(10 * time.Second)
+	s.tick(*now)
+	if len(n.notes) != 1 {
+		t.Fatalf("notifications = %d, want the failed one retried", len(n.notes))
+	}
+	*now = now.Add(30 * time.Minute)
+	s.tick(*now)
+	*now = now.Add(30 * time.Minute)
+	s.tick(*now)
+	if len(n.notes) != 2 || n.notes[1].Status != "firing" {
+		t.Fatalf("notifications = %+v, want a repeat after 1h", n.notes)
+	}
+}
+
+func TestStage_Absent(t *testing.T) {
+	s, n, now := newTestStage(t, `
+group_wait: 1s
+rules:
+  - name: silent
+    match: {site: A}
+    absent: 10m
+`)
+	// boiler-2 is not watched, but its readings move event time on.
+	send(t, s, "boiler-1", "A", 1, start.Unix())
+	send(t, s, "boiler-2", "B", 1, start.Unix()+540)
+	s.tick(*now)
+	if len(s.Alerts()) != 0 {
+		t.Fatal("absence alert fired early")
+	}
+	send(t, s, "boiler-2", "B", 1, start.Unix()+660)
+	s.tick(*now)
+	alerts := s.Alerts()
+	if len(alerts) != 1 || alerts[0].DeviceID != "boiler-1" || alerts[0].State != StateFiring || alerts[0].FiredAt.Unix() != start.Unix()+600 {
+		t.Fatalf("alerts = %+v, want boiler-1 firing since 10m after its reading", alerts)
+	}
+
+	send(t, s, "boiler-1", "A", 1, start.Unix()+670)
+	*now = now.Add(time.Second)
+	s.tick(*now)
+	if len(s.Alerts()) != 0 {
+		t.Fatalf("alerts = %+v, want the absence resolved", s.Alerts())
+	}
+	if len(n.notes) != 1 || n.notes[0].Status != "resolved" {
+		t.Fatalf("notifications = %+v, want the firing and resolution in one", n.notes)
+	}
+}
+
+func TestStage_ReleasedPartitionIsNotWatched(t *testing.T) {
+	s, n, now := newTestStage(t, `
+group_wait: 1s
+rules:
+  - name: silent
+    match: {site: A}
+    absent: 10m
+`)
+	sendFrom(t, s, "shard-1", "boiler-1", "A", 1, start.Unix())
+	sendFrom(t, s, "shard-2", "boiler-2", "A", 1, start.Unix())
+	// Another replica takes shard-1, and reads boiler-1 from now on.
+	s.clock.Release("shard-1")
+	sendFrom(t, s, "shard-1", "boiler-3", "A", 1, start.Unix()) // still in flight
+	sendFrom(t, s, "shard-2", "boiler-2", "A", 1, start.Unix()+660)
+	s.tick(*now)
+	if alerts := s.Alerts(); len(alerts) != 0 {
+		t.Fatalf("alerts = %+v, want none for devices of the released partition", alerts)
+	}
+	if len(n.notes) != 0 {
+		t.Fatalf("notifications = %+v, want none", n.notes)
+	}
+}
+
+func TestStage_OutOfOrderReadingsSkipped(t *testing.T) {
+	s, _, _ := newTestStage(t, thresholdRules)
+	at := start.Unix()
+	send(t, s, "boiler-1", "A", 85, at)
+	send(t, s, "boiler-1", "A", 95, at+300)
+	send(t, s, "boiler-1", "A", 70, at+100) // delayed in transit
+	alerts := s.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StateFiring || alerts[0].Value != 95 {
+		t.Fatalf("alerts = %+v, want firing, unchanged by the older reading", alerts)
+	}
+}
+
+func TestStage_BackfillFollowsEventTime(t *testing.T) {
+	s, _, _ := newTestStage(t, thresholdRules)
+	// A day-old hour of readings, replayed in moments of wall time.
+	at := start.Unix() - 86400
+	for i := int64(0); i <= 6; i++ {
+		send(t, s, "boiler-1", "A", 85, at+i*60)
+	}
+	a := s.Alerts()
+	if len(a) != 1 || a[0].State != StateFiring || a[0].FiredAt.Unix() != at+300 {
+		t.Fatalf("alerts = %+v, want firing 5m into the backfill", a)
+	}
+}
+
+func TestWebhook_PostsJSON(t *testing.T) {
+	var got Notification
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	note := Notification{Status: "firing", GroupKey: `{alertname="hot"}`, Alerts: []Alert{{Rule: "hot", State: StateFiring}}}
+	if err := NewWebhook(srv.URL).Notify(context.Background(), note); err != nil {
+		t.Fatal(err)
+	}
+	if got.GroupKey != note.GroupKey || len(got.Alerts) != 1 {
+		t.Errorf("received %+v", got)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := NewWebhook(failing.URL).Notify(context.Background(), note); err == nil {
+		t.Error("Notify succeeded on a 502")
+	}
+}
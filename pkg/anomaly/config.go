@@ -0,0 +1,91 @@
+package anomaly
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"iot-insighthub/pkg/config"
+)
+
+// Config chooses the detectors for each device. The most specific entry
+// applies: the device's own, else its device type's, else Defaults. Entries
+// are not merged.
+//
+//	defaults:
+//	  - type: threshold
+//	    max: 75
+//	device_types:
+//	  temperature:
+//	    - type: zscore
+//	      window: 120
+//	devices:
+//	  pump-7:
+//	    - type: mad
+//	      threshold: 4
+type Config struct {
+	Defaults    []DetectorConfig            `yaml:"defaults"`
+	DeviceTypes map[string][]DetectorConfig `yaml:"device_types"`
+	Devices     map[string][]DetectorConfig `yaml:"devices"`
+}
+
+// LoadConfig reads a Config from the YAML file at path with
+// config.LoadYAML.
+func LoadConfig(path string) (*Config, error) {
+	var c Config
+	if err := config.LoadYAML(path, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate reports every invalid detector.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(where string, detectors []DetectorConfig) {
+		seen := make(map[string]bool)
+		for i, d := range detectors {
+			if err := d.validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %v", where, i, err))
+			}
+			// Anomalies are stored per detector type.
+			if seen[d.Type] {
+				problems = append(problems, fmt.Sprintf("%s[%d]: more than one %s detector", where, i, d.Type))
+			}
+			seen[d.Type] = true
+		}
+	}
+	check("defaults", c.Defaults)
+	for _, name := range sortedKeys(c.DeviceTypes) {
+		check("device_types."+name, c.DeviceTypes[name])
+	}
+	for _, name := range sortedKeys(c.Devices) {
+		check("devices."+name, c.Devices[name])
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// profile returns the detectors for a device and a key naming the entry
+// they come from.
+func (c *Config) profile(deviceID, deviceType string) (string, []DetectorConfig) {
+	if d, ok := c.Devices[deviceID]; ok {
+		return "device:" + deviceID, d
+	}
+	if d, ok := c.DeviceTypes[deviceType]; ok && deviceType != "" {
+		return "type:" + deviceType, d
+	}
+	return "defaults", c.Defaults
+}
+
+func sortedKeys(m map[string][]DetectorConfig) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,272 @@
+package anomaly
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Detector types.
+const (
+	TypeThreshold = "threshold"
+	TypeZScore    = "zscore"
+	TypeEWMA      = "ewma"
+	TypeMAD       = "mad"
+)
+
+// DetectorConfig configures one detector. Which fields apply depends on
+// Type; unset fields take the defaults given below.
+type DetectorConfig struct {
+	// Type is threshold, zscore, ewma or mad.
+	Type string `yaml:"type"`
+	// Min and Max bound the values a threshold detector accepts. Either may
+	// be omitted.
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+	// Window is the number of recent values a zscore or mad detector
+	// compares against (default 60).
+	Window int `yaml:"window"`
+	// Alpha is the smoothing factor of an ewma detector, in (0, 1]
+	// (default 0.1).
+	Alpha float64 `yaml:"alpha"`
+	// Threshold is the score above which a zscore, ewma or mad detector
+	// reports an anomaly (default 3, or 3.5 for mad).
+	Threshold float64 `yaml:"threshold"`
+	// Warmup is the number of values a zscore, ewma or mad detector learns
+	// from before it reports (default and at most Window, or 1/Alpha for
+	// ewma).
+	Warmup int `yaml:"warmup"`
+}
+
+// withDefaults returns c with unset fields defaulted.
+func (c DetectorConfig) withDefaults() DetectorConfig {
+	if c.Window <= 0 {
+		c.Window = 60
+	}
+	if c.Alpha <= 0 {
+		c.Alpha = 0.1
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 3
+		if c.Type == TypeMAD {
+			c.Threshold = 3.5
+		}
+	}
+	if c.Warmup <= 0 {
+		c.Warmup = c.Window
+		if c.Type == TypeEWMA {
+			c.Warmup = int(math.Ceil(1 / c.Alpha))
+		}
+	}
+	if c.Type != TypeEWMA && c.Warmup > c.Window {
+		// The window never holds more values than that.
+		c.Warmup = c.Window
+	}
+	return c
+}
+
+// validate reports a problem with c.
+func (c DetectorConfig) validate() error {
+	switch c.Type {
+	case TypeThreshold:
+		if c.Min == nil && c.Max == nil {
+			return fmt.Errorf("threshold detector needs min or max")
+		}
+		if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+			return fmt.Errorf("threshold detector min %g is above max %g", *c.Min, *c.Max)
+		}
+	case TypeZScore, TypeMAD:
+	case TypeEWMA:
+		if c.Alpha > 1 {
+			return fmt.Errorf("ewma alpha %g must be in (0, 1]", c.Alpha)
+		}
+	default:
+		return fmt.Errorf("unknown detector type %q (want threshold, zscore, ewma or mad)", c.Type)
+	}
+	return nil
+}
+
+// Detector scores the values of one device. Observe is called with each
+// value in turn; it returns the value's score and, if the value is an
+// anomaly, the reason. A detector keeps per-device state and is not safe
+// for concurrent use.
+type Detector interface {
+	Observe(value float64) (score float64, reason string)
+}
+
+// NewDetector returns a detector with no history configured by c.
+func NewDetector(c DetectorConfig) (Detector, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	c = c.withDefaults()
+	switch c.Type {
+	case TypeThreshold:
+		return &threshold{min: c.Min, max: c.Max}, nil
+	case TypeZScore:
+		return &zScore{cfg: c, window: newRing(c.Window)}, nil
+	case TypeEWMA:
+		return &ewma{cfg: c}, nil
+	default:
+		return &mad{cfg: c, window: newRing(c.Window)}, nil
+	}
+}
+
+// threshold flags values outside [min, max]. The score is the distance to
+// the bound crossed.
+type threshold struct {
+	min, max *float64
+}
+
+func (d *threshold) Observe(v float64) (float64, string) {
+	switch {
+	case d.max != nil && v > *d.max:
+		return v - *d.max, fmt.Sprintf("value %g is above the maximum %g", v, *d.max)
+	case d.min != nil && v < *d.min:
+		return *d.min - v, fmt.Sprintf("value %g is below the minimum %g", v, *d.min)
+	}
+	return 0, ""
+}
+
+// zScore flags values more than Threshold standard deviations from the mean
+// of the last Window values.
+type zScore struct {
+	cfg    DetectorConfig
+	window *ring
+}
+
+func (d *zScore) Observe(v float64) (float64, string) {
+	defer d.window.add(v)
+	if d.window.len() < d.cfg.Warmup {
+		return 0, ""
+	}
+	mean, std := d.window.meanStd()
+	if std == 0 {
+		return 0, ""
+	}
+	score := math.Abs(v-mean) / std
+	if score <= d.cfg.Threshold {
+		return score, ""
+	}
+	return score, fmt.Sprintf("value %g is %.1f standard deviations from the rolling mean %g", v, score, mean)
+}
+
+// ewma flags values more than Threshold standard deviations from an
+// exponentially weighted moving mean, with the deviation weighted the same
+// way. It adapts to drift and keeps constant state.
+type ewma struct {
+	cfg        DetectorConfig
+	n          int
+	mean, vari float64
+}
+
+func (d *ewma) Observe(v float64) (float64, string) {
+	var score float64
+	if d.n >= d.cfg.Warmup && d.vari > 0 {
+		score = math.Abs(v-d.mean) / math.Sqrt(d.vari)
+	}
+	mean := d.mean
+	if d.n == 0 {
+		d.mean = v
+	} else {
+		diff := v - d.mean
+		incr := d.cfg.Alpha * diff
+		d.mean += incr
+		d.vari = (1 - d.cfg.Alpha) * (d.vari + diff*incr)
+	}
+	d.n++
+	if score <= d.cfg.Threshold {
+		return score, ""
+	}
+	return score, fmt.Sprintf("value %g is %.1f standard deviations from the moving average %g", v, score, mean)
+}
+
+// mad flags values whose modified z-score, based on the median and the
+// median absolute deviation of the last Window values, exceeds Threshold.
+// Unlike zScore it is not skewed by earlier outliers.
+type mad struct {
+	cfg    DetectorConfig
+	window *ring
+}
+
+// madScale makes the MAD of normally distributed values comparable to their
+// standard deviation.
+const madScale = 0.6745
+
+func (d *mad) Observe(v float64) (float64, string) {
+	defer d.window.add(v)
+	if d.window.len() < d.cfg.Warmup {
+		return 0, ""
+	}
+	values := d.window.values()
+	median := medianOf(values)
+	for i, x := range values {
+		values[i] = math.Abs(x - median)
+	}
+	dev := medianOf(values)
+	if dev == 0 {
+		return 0, ""
+	}
+	score := madScale * math.Abs(v-median) / dev
+	if score <= d.cfg.Threshold {
+		return score, ""
+	}
+	return score, fmt.Sprintf("value %g has modified z-score %.1f against the rolling median %g", v, score, median)
+}
+
+// medianOf returns the median of values, reordering them.
+func medianOf(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// ring holds the most recent values, up to its capacity.
+type ring struct {
+	buf  []float64
+	next int
+	full bool
+}
+
+func newRing(size int) *ring {
+	return &ring{buf: make([]float64, size)}
+}
+
+func (r *ring) add(v float64) {
+	r.buf[r.next] = v
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+func (r *ring) len() int {
+	if r.full {
+		return len(r.buf)
+	}
+	return r.next
+}
+
+// values returns a copy of the held values, in no particular order.
+func (r *ring) values() []float64 {
+	return append([]float64(nil), r.buf[:r.len()]...)
+}
+
+// meanStd returns the mean and population standard deviation of the held
+// values.
+func (r *ring) meanStd() (float64, float64) {
+	values := r.buf[:r.len()]
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(values)))
+}
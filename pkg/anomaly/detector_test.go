@@ -0,0 +1,104 @@
+package anomaly
+
+import (
+	"strings"
+	"testing"
+)
+
+func mustDetector(t *testing.T, c DetectorConfig) Detector {
+	t.Helper()
+	d, err := NewDetector(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// observeAll feeds values to d and returns the indexes flagged.
+func observeAll(d Detector, values []float64) []int {
+	var flagged []int
+	for i, v := range values {
+		if _, reason := d.Observe(v); reason != "" {
+			flagged = append(flagged, i)
+		}
+	}
+	return flagged
+}
+
+// noisy returns n values alternating around base.
+func noisy(n int, base float64) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = base + float64(i%5) - 2
+	}
+	return values
+}
+
+func TestThreshold_FlagsValuesOutsideBounds(t *testing.T) {
+	max, min := 75.0, -10.0
+	d := mustDetector(t, DetectorConfig{Type: TypeThreshold, Min: &min, Max: &max})
+
+	if score, reason := d.Observe(75); reason != "" || score != 0 {
+		t.Errorf("the bound itself is not an anomaly, got %g %q", score, reason)
+	}
+	score, reason := d.Observe(80)
+	if score != 5 || !strings.Contains(reason, "above the maximum 75") {
+		t.Errorf("unexpected result %g %q", score, reason)
+	}
+	score, reason = d.Observe(-12)
+	if score != 2 || !strings.Contains(reason, "below the minimum -10") {
+		t.Errorf("unexpected result %g %q", score, reason)
+	}
+}
+
+func TestRollingDetectors_FlagSpikeAfterWarmup(t *testing.T) {
+	for _, typ := range []string{TypeZScore, TypeEWMA, TypeMAD} {
+		d := mustDetector(t, DetectorConfig{Type: typ, Window: 20})
+		values := append(noisy(40, 20), 60, 20)
+		flagged := observeAll(d, values)
+		if len(flagged) != 1 || flagged[0] != 40 {
+			t.Errorf("%s: expected only the spike at 40 to be flagged, got %v", typ, flagged)
+		}
+	}
+}
+
+func TestRollingDetectors_QuietDuringWarmup(t *testing.T) {
+	for _, typ := range []string{TypeZScore, TypeEWMA, TypeMAD} {
+		d := mustDetector(t, DetectorConfig{Type: typ, Window: 20, Warmup: 15})
+		values := append(noisy(10, 20), 60)
+		if flagged := observeAll(d, values); len(flagged) != 0 {
+			t.Errorf("%s: expected nothing flagged during warmup, got %v", typ, flagged)
+		}
+	}
+}
+
+func TestMAD_IgnoresEarlierOutliers(t *testing.T) {
+	// One outlier inflates the standard deviation enough to hide a second
+	// one from the z-score, but not from the median-based detector.
+	values := append(noisy(20, 20), 200)
+	values = append(values, noisy(5, 20)...)
+	values = append(values, 35)
+
+	z := mustDetector(t, DetectorConfig{Type: TypeZScore, Window: 20})
+	m := mustDetector(t, DetectorConfig{Type: TypeMAD, Window: 20})
+	if flagged := observeAll(z, values); len(flagged) != 1 {
+		t.Errorf("expected the z-score to miss the second outlier, got %v", flagged)
+	}
+	if flagged := observeAll(m, values); len(flagged) != 2 {
+		t.Errorf("expected MAD to flag both outliers, got %v", flagged)
+	}
+}
+
+func TestNewDetector_RejectsInvalidConfigs(t *testing.T) {
+	max, min := 1.0, 2.0
+	for _, c := range []DetectorConfig{
+		{Type: "median"},
+		{Type: TypeThreshold},
+		{Type: TypeThreshold, Min: &min, Max: &max},
+		{Type: TypeEWMA, Alpha: 1.5},
+	} {
+		if _, err := NewDetector(c); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package anomaly
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+)
+
+// PostgresRecorder writes anomalies to the anomalies table (see
+// migration/005_create_anomalies_table.sql). Anomalies are keyed by device,
+// time and detector, so detecting the same anomaly again on a replay or
+// backfill updates the stored row rather than adding one.
+type PostgresRecorder struct {
+	db *sql.DB
+}
+
+// NewPostgresRecorder returns a PostgresRecorder that uses db.
+func NewPostgresRecorder(db *sql.DB) *PostgresRecorder {
+	return &PostgresRecorder{db: db}
+}
+
+// Record inserts anomalies in one statement.
+func (p *PostgresRecorder) Record(ctx context.Context, anomalies []Anomaly) error {
+	anomalies = dedupe(anomalies)
+	if len(anomalies) == 0 {
+		return nil
+	}
+	var (
+		rows []string
+		args []interface{}
+	)
+	for _, a := range anomalies {
+		n := len(args)
+		rows = append(rows, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, a.DeviceID, time.Unix(a.Time, 0).UTC(), a.Value, a.Detector, a.Score, a.Reason, a.DetectedAt)
+	}
+	query := `INSERT INTO anomalies (device_id, timestamp, value, detector, score, reason, detected_at)
+VALUES ` + strings.Join(rows, ", ") + `
+ON CONFLICT (device_id, timestamp, detector) DO UPDATE
+SET value = EXCLUDED.value, score = EXCLUDED.score, reason = EXCLUDED.reason, detected_at = EXCLUDED.detected_at`
+	_, err := p.db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// anomalyKey is the key of the anomalies table.
+type anomalyKey struct {
+	deviceID string
+	time     int64
+	detector string
+}
+
+// dedupe returns anomalies with one per key, the last. An upsert cannot
+// touch a row twice, and a message can hold two flagged readings from a
+// device in the same second, such as a SenML pack or readings with
+// different message IDs.
+func dedupe(anomalies []Anomaly) []Anomaly {
+	index := make(map[anomalyKey]int, len(anomalies))
+	rows := make([]Anomaly, 0, len(anomalies))
+	for _, a := range anomalies {
+		k := anomalyKey{a.DeviceID, a.Time, a.Detector}
+		if i, ok := index[k]; ok {
+			rows[i] = a
+			continue
+		}
+		index[k] = len(rows)
+		rows = append(rows, a)
+	}
+	return rows
+}
+
+// StreamEmitter publishes each anomaly as a JSON event to a Kinesis stream,
+// partitioned by device, for consumers such as dashboards and alerting.
+type StreamEmitter struct {
+	client kinesisiface.KinesisAPI
+	stream string
+}
+
+// NewStreamEmitter returns a StreamEmitter that writes to stream.
+func NewStreamEmitter(client kinesisiface.KinesisAPI, stream string) *StreamEmitter {
+	return &StreamEmitter{client: client, stream: stream}
+}
+
+// Record puts anomalies to the stream. It fails if any of them is rejected;
+// the retry that follows may publish the others twice, so consumers should
+// treat events as at-least-once.
+func (e *StreamEmitter) Record(ctx context.Context, anomalies []Anomaly) error {
+	entries := make([]*kinesis.PutRecordsRequestEntry, len(anomalies))
+	for i, a := range anomalies {
+		data, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		entries[i] = &kinesis.PutRecordsRequestEntry{Data: data, PartitionKey: aws.String(a.DeviceID)}
+	}
+	out, err := e.client.PutRecordsWithContext(ctx, &kinesis.PutRecordsInput{
+		StreamName: aws.String(e.stream),
+		Records:    entries,
+	})
+	if err != nil {
+		return err
+	}
+	if n := aws.Int64Value(out.FailedRecordCount); n > 0 {
+		return fmt.Errorf("%d of %d anomaly events rejected by %s", n, len(entries), e.stream)
+	}
+	return nil
+}
+
+// WriterEmitter writes each anomaly as a line of JSON to an io.Writer. It
+// stands in for a stream in local development.
+type WriterEmitter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterEmitter returns a WriterEmitter that writes to w.
+func NewWriterEmitter(w io.Writer) *WriterEmitter {
+	return &WriterEmitter{w: w}
+}
+
+// Record writes anomalies to the writer.
+func (e *WriterEmitter) Record(ctx context.Context, anomalies []Anomaly) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, a := range anomalies {
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// Anomaly is a reading a detector flagged.
+type Anomaly struct {
+	DeviceID   string    `json:"device_id"`
+	Time       int64     `json:"time"`
+	Value      float64   `json:"value"`
+	Detector   string    `json:"detector"`
+	Score      float64   `json:"score"`
+	Reason     string    `json:"reason"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// Recorder stores or publishes anomalies. Implementations must be safe for
+// concurrent use.
+type Recorder interface {
+	Record(ctx context.Context, anomalies []Anomaly) error
+}
+
+// Stage is the pipeline's detect stage. It runs every reading through the
+// detectors configured for its device, keeping their state per device, and
+// hands the anomalies found to each Recorder before the message moves on.
+// A recorder failure fails the stage, so the stage's error policy decides
+// what happens to the record. Readings are passed on unchanged.
+type Stage struct {
+	cfg       *Config
+	recorders []Recorder
+	now       func() time.Time
+
+	mu      sync.RWMutex
+	devices map[string]*deviceState
+}
+
+// deviceState holds a device's detectors. Workers process a device's
+// records in order, but replays may not, so it is locked.
+type deviceState struct {
+	mu        sync.Mutex
+	profile   string
+	detectors []namedDetector
+}
+
+type namedDetector struct {
+	name string
+	Detector
+}
+
+// NewStage returns a detect stage using cfg, which must be valid.
+func NewStage(cfg *Config, recorders ...Recorder) *Stage {
+	return &Stage{
+		cfg:       cfg,
+		recorders: recorders,
+		now:       time.Now,
+		devices:   make(map[string]*deviceState),
+	}
+}
+
+// Name returns "detect".
+func (*Stage) Name() string { return telemetry.StageDetect }
+
+// Process scores every reading of m and records the anomalies found.
+func (s *Stage) Process(ctx context.Context, m *telemetry.Message) error {
+	var found []Anomaly
+	for _, r := range m.Readings {
+		found = append(found, s.observe(r)...)
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	for _, rec := range s.recorders {
+		if err := rec.Record(ctx, found); err != nil {
+			return fmt.Errorf("recording anomalies: %w", err)
+		}
+	}
+	return nil
+}
+
+// observe runs r through its device's detectors.
+func (s *Stage) observe(r telemetry.Reading) []Anomaly {
+	profile, configs := s.cfg.profile(r.DeviceID, r.Labels[telemetry.LabelDeviceType])
+	if len(configs) == 0 {
+		return nil
+	}
+	state := s.state(r.DeviceID)
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.profile != profile {
+		// First reading, or the device's type changed: start afresh.
+		state.profile = profile
+		state.detectors = state.detectors[:0]
+		for _, c := range configs {
+			d, _ := NewDetector(c) // validated with the config
+			state.detectors = append(state.detectors, namedDetector{name: c.Type, Detector: d})
+		}
+	}
+
+	var found []Anomaly
+	for _, d := range state.detectors {
+		score, reason := d.Observe(r.Value)
+		if reason == "" {
+			continue
+		}
+		metrics.AnomaliesDetected.WithLabelValues(d.name).Inc()
+		found = append(found, Anomaly{
+			DeviceID:   r.DeviceID,
+			Time:       r.Time,
+			Value:      r.Value,
+			Detector:   d.name,
+			Score:      score,
+			Reason:     reason,
+			DetectedAt: s.now().UTC(),
+		})
+	}
+	return found
+}
+
+// state returns the state of deviceID, creating it if needed.
+func (s *Stage) state(deviceID string) *deviceState {
+	s.mu.RLock()
+	state, ok := s.devices[deviceID]
+	s.mu.RUnlock()
+	if ok {
+		return state
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if state, ok = s.devices[deviceID]; !ok {
+		state = &deviceState{}
+		s.devices[deviceID] = state
+	}
+	return state
+}
+
+// Devices returns the number of devices with detector state.
+func (s *Stage) Devices() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.devices)
+}
@@ -0,0 +1,145 @@
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/config/configtest"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// memoryRecorder keeps recorded anomalies.
+type memoryRecorder struct {
+	mu        sync.Mutex
+	anomalies []Anomaly
+	err       error
+}
+
+func (r *memoryRecorder) Record(ctx context.Context, anomalies []Anomaly) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.anomalies = append(r.anomalies, anomalies...)
+	return r.err
+}
+
+func message(deviceID, deviceType string, value float64) *telemetry.Message {
+	r := telemetry.Reading{TelemetryData: api.TelemetryData{DeviceID: deviceID, Value: value, Time: 1700000000}}
+	if deviceType != "" {
+		r.Labels = map[string]string{telemetry.LabelDeviceType: deviceType}
+	}
+	return &telemetry.Message{Readings: []telemetry.Reading{r}}
+}
+
+func loadTestConfig(t *testing.T, yaml string) *Config {
+	t.Helper()
+	var cfg Config
+	if err := configtest.LoadYAML(t, yaml, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	return &cfg
+}
+
+const testConfig = `
+defaults:
+  - type: threshold
+    max: 75
+device_types:
+  pressure:
+    - type: threshold
+      max: 10
+devices:
+  boiler-1:
+    - type: threshold
+      max: 100
+`
+
+func TestStage_MostSpecificProfileApplies(t *testing.T) {
+	rec := &memoryRecorder{}
+	s := NewStage(loadTestConfig(t, testConfig), rec)
+	ctx := context.Background()
+
+	for _, m := range []*telemetry.Message{
+		message("sensor-1", "", 80),         // defaults: flagged
+		message("sensor-2", "pressure", 12), // device type: flagged
+		message("boiler-1", "pressure", 80), // device: not flagged
+	} {
+		if err := s.Process(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(rec.anomalies) != 2 {
+		t.Fatalf("expected 2 anomalies, got %+v", rec.anomalies)
+	}
+	a := rec.anomalies[0]
+	if a.DeviceID != "sensor-1" || a.Detector != TypeThreshold || a.Score != 5 || a.Reason == "" || a.DetectedAt.IsZero() {
+		t.Errorf("unexpected anomaly %+v", a)
+	}
+	if rec.anomalies[1].DeviceID != "sensor-2" {
+		t.Errorf("unexpected anomaly %+v", rec.anomalies[1])
+	}
+	if s.Devices() != 3 {
+		t.Errorf("expected state for 3 devices, got %d", s.Devices())
+	}
+}
+
+func TestStage_KeepsStatePerDevice(t *testing.T) {
+	rec := &memoryRecorder{}
+	s := NewStage(&Config{Defaults: []DetectorConfig{{Type: TypeZScore, Window: 10}}}, rec)
+	ctx := context.Background()
+
+	// Device a is steady around 20, device b around 200: b's values must
+	// not count as anomalies against a's history.
+	for i := 0; i < 20; i++ {
+		s.Process(ctx, message("a", "", 20+float64(i%3)))
+		s.Process(ctx, message("b", "", 200+float64(i%3)))
+	}
+	if len(rec.anomalies) != 0 {
+		t.Fatalf("expected no anomalies, got %+v", rec.anomalies)
+	}
+	s.Process(ctx, message("a", "", 200))
+	if len(rec.anomalies) != 1 || rec.anomalies[0].DeviceID != "a" {
+		t.Errorf("expected one anomaly for a, got %+v", rec.anomalies)
+	}
+}
+
+func TestStage_RecorderFailureFailsTheStage(t *testing.T) {
+	rec := &memoryRecorder{err: errors.New("database down")}
+	s := NewStage(loadTestConfig(t, testConfig), rec)
+	err := s.Process(context.Background(), message("sensor-1", "", 80))
+	if err == nil || !strings.Contains(err.Error(), "database down") {
+		t.Errorf("expected the recorder error, got %v", err)
+	}
+	if err := s.Process(context.Background(), message("sensor-1", "", 10)); err != nil {
+		t.Errorf("nothing is recorded for a normal reading, got %v", err)
+	}
+}
+
+func TestLoadConfig_RejectsInvalidDetectors(t *testing.T) {
+	err := configtest.LoadYAML(t, `
+devices:
+  pump-1:
+    - type: threshold
+    - type: zscore
+    - type: zscore
+`, &Config{})
+	if err == nil || !strings.Contains(err.Error(), "devices.pump-1[0]: threshold detector needs min or max") ||
+		!strings.Contains(err.Error(), "more than one zscore detector") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestDedupe_KeepsTheLastAnomalyPerKey(t *testing.T) {
+	got := dedupe([]Anomaly{
+		{DeviceID: "pump-1", Time: 100, Detector: "threshold", Value: 1},
+		{DeviceID: "pump-1", Time: 100, Detector: "zscore", Value: 1},
+		{DeviceID: "pump-1", Time: 100, Detector: "threshold", Value: 2},
+		{DeviceID: "pump-2", Time: 100, Detector: "threshold", Value: 3},
+	})
+	if len(got) != 3 || got[0].Value != 2 || got[1].Detector != "zscore" || got[2].DeviceID != "pump-2" {
+		t.Errorf("dedupe = %+v, want one anomaly per key, the last", got)
+	}
+}
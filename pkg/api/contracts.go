@@ -1,9 +1,36 @@
 package api
 
 // TelemetryData defines the structure for incoming telemetry data.
-// The struct tags include JSON mappings and validation tags.
+// The struct tags include JSON mappings and validation tags, checked by
+// pkg/validation. A zero value is a valid reading, so Value has none.
 type TelemetryData struct {
-	DeviceID string  `json:"device_id" validate:"required"`
-	Value    float64 `json:"value" validate:"required"`
+	DeviceID string  `json:"device_id" validate:"required,device_id"`
+	Value    float64 `json:"value"`
 	Time     int64   `json:"time" validate:"required"`
+	// MessageID optionally distinguishes readings a device sends with the
+	// same timestamp. Together with DeviceID and Time it identifies a reading.
+	MessageID string `json:"message_id,omitempty"`
+	// Unit is the unit of Value, if known. The ingestor's transform stage
+	// sets it to the canonical unit it converted Value to.
+	Unit string `json:"unit,omitempty"`
+	// RawValue is the value as the device sent it, kept when the transform
+	// stage calibrates or converts Value. It is nil if Value is unchanged.
+	RawValue *float64 `json:"raw_value,omitempty"`
+	// RawUnit is the unit the device sent the reading in, kept when the
+	// transform stage converts it to another unit. It is empty if Unit is
+	// unchanged.
+	RawUnit string `json:"raw_unit,omitempty"`
+}
+
+// ReadingKey is the natural key of a reading: the stores keep at most one
+// row per key.
+type ReadingKey struct {
+	DeviceID  string
+	Time      int64
+	MessageID string
+}
+
+// Key returns the natural key of d.
+func (d TelemetryData) Key() ReadingKey {
+	return ReadingKey{DeviceID: d.DeviceID, Time: d.Time, MessageID: d.MessageID}
 }
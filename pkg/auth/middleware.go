@@ -29,6 +29,19 @@ func getJWTSecret() string {
 	return secret
 }
 
+// SetJWTSecret replaces the key used to verify tokens. It must be called
+// before the middleware starts serving requests.
+func SetJWTSecret(secret string) {
+	jwtSecret = []byte(secret)
+}
+
+// SetRateLimit changes the global rate limit to perSecond requests per second
+// with bursts of up to burst requests. It is safe to call while serving.
+func SetRateLimit(perSecond float64, burst int) {
+	limiter.SetLimit(rate.Limit(perSecond))
+	limiter.SetBurst(burst)
+}
+
 // AuthMiddleware validates the Bearer token and enforces rate limiting.
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
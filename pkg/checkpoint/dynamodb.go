@@ -0,0 +1,57 @@
+package checkpoint
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDBStore keeps checkpoints in a DynamoDB table (or any service that
+// speaks the DynamoDB API, such as DynamoDB Local or ScyllaDB Alternator).
+// The table must have a string partition key "stream_name" and a string
+// sort key "shard_id".
+type DynamoDBStore struct {
+	client    dynamodbiface.DynamoDBAPI
+	tableName string
+}
+
+// NewDynamoDBStore returns a DynamoDBStore that writes to tableName.
+func NewDynamoDBStore(client dynamodbiface.DynamoDBAPI, tableName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName}
+}
+
+// Get returns the stored sequence number for the shard.
+func (s *DynamoDBStore) Get(ctx context.Context, streamName, shardID string) (string, error) {
+	out, err := s.client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.tableName),
+		ConsistentRead: aws.Bool(true),
+		Key: map[string]*dynamodb.AttributeValue{
+			"stream_name": {S: aws.String(streamName)},
+			"shard_id":    {S: aws.String(shardID)},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if attr, ok := out.Item["sequence_number"]; ok && attr.S != nil {
+		return *attr.S, nil
+	}
+	return "", nil
+}
+
+// Put stores the sequence number for the shard.
+func (s *DynamoDBStore) Put(ctx context.Context, streamName, shardID, sequenceNumber string) error {
+	_, err := s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"stream_name":     {S: aws.String(streamName)},
+			"shard_id":        {S: aws.String(shardID)},
+			"sequence_number": {S: aws.String(sequenceNumber)},
+			"updated_at":      {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+	})
+	return err
+}
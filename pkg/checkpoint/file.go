@@ -0,0 +1,91 @@
+package checkpoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileStore keeps checkpoints in a single JSON file on local disk.
+// It is intended for development and single-replica deployments.
+type FileStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileStore returns a FileStore backed by the file at path.
+// The file is created on the first Put.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Get returns the stored sequence number for the shard.
+func (s *FileStore) Get(ctx context.Context, streamName, shardID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	return entries[fileKey(streamName, shardID)], nil
+}
+
+// Put stores the sequence number for the shard.
+// The file is rewritten atomically so a crash never leaves it truncated.
+func (s *FileStore) Put(ctx context.Context, streamName, shardID, sequenceNumber string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+	entries[fileKey(streamName, shardID)] = sequenceNumber
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// load reads all checkpoints from disk. A missing file yields an empty map.
+func (s *FileStore) load() (map[string]string, error) {
+	entries := make(map[string]string)
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// fileKey builds the map key used for a stream/shard pair.
+func fileKey(streamName, shardID string) string {
+	return streamName + "/" + shardID
+}
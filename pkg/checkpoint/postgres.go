@@ -0,0 +1,45 @@
+package checkpoint
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore keeps checkpoints in the kinesis_checkpoints table of a
+// Postgres/TimescaleDB database (see migration/002_create_checkpoints_table.sql).
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore returns a PostgresStore that uses db.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get returns the stored sequence number for the shard.
+func (s *PostgresStore) Get(ctx context.Context, streamName, shardID string) (string, error) {
+	var seq string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT sequence_number FROM kinesis_checkpoints WHERE stream_name = $1 AND shard_id = $2`,
+		streamName, shardID,
+	).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return seq, err
+}
+
+// Put upserts the sequence number for the shard.
+func (s *PostgresStore) Put(ctx context.Context, streamName, shardID, sequenceNumber string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO kinesis_checkpoints (stream_name, shard_id, sequence_number, updated_at)
+		 VALUES ($1, $2, $3, now())
+		 ON CONFLICT (stream_name, shard_id)
+		 DO UPDATE SET sequence_number = EXCLUDED.sequence_number, updated_at = EXCLUDED.updated_at`,
+		streamName, shardID, sequenceNumber,
+	)
+	return err
+}
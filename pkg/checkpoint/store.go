@@ -0,0 +1,18 @@
+package checkpoint
+
+import "context"
+
+// ShardEnd is stored as the sequence number of a closed shard once every one
+// of its records has been processed. Child shards created by a split or merge
+// are only consumed after their parents reach ShardEnd.
+const ShardEnd = "SHARD_END"
+
+// Store persists the last processed sequence number per stream and shard.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Get returns the last checkpointed sequence number for the shard,
+	// or an empty string if the shard has never been checkpointed.
+	Get(ctx context.Context, streamName, shardID string) (string, error)
+	// Put records sequenceNumber as the last processed record for the shard.
+	Put(ctx context.Context, streamName, shardID, sequenceNumber string) error
+}
@@ -0,0 +1,199 @@
+package checkpoint
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Tracker follows the records of a single shard through the worker pool.
+// Records are tracked in the order they were read; the checkpoint only
+// advances past a record once it and every record before it are done, so
+// a restart never skips a record that was still in flight.
+type Tracker struct {
+	streamName string
+	shardID    string
+
+	mu      sync.Mutex
+	pending []*entry
+	last    string // highest contiguous done sequence number
+	flushed string // last sequence number written to the store
+	ended   bool   // the shard is closed and every record has been read
+}
+
+type entry struct {
+	sequenceNumber string
+	done           bool
+}
+
+// Track registers a record that has been read from the shard and returns a
+// function the worker must call once it has finished with the record.
+func (t *Tracker) Track(sequenceNumber string) func() {
+	e := &entry{sequenceNumber: sequenceNumber}
+	t.mu.Lock()
+	t.pending = append(t.pending, e)
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { t.markDone(e) })
+	}
+}
+
+// markDone flags e as finished and advances past any finished prefix.
+func (t *Tracker) markDone(e *entry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e.done = true
+	n := 0
+	for n < len(t.pending) && t.pending[n].done {
+		t.last = t.pending[n].sequenceNumber
+		n++
+	}
+	if n > 0 {
+		// Let the finished entries be collected before the slice is reused.
+		clear(t.pending[:n])
+		t.pending = t.pending[n:]
+	}
+	t.checkEnd()
+}
+
+// End records that the shard is closed and no more records will be tracked.
+// Once the remaining records are done the position becomes ShardEnd.
+func (t *Tracker) End() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ended = true
+	t.checkEnd()
+}
+
+// checkEnd moves the position to ShardEnd when the shard is fully drained.
+// The caller must hold t.mu.
+func (t *Tracker) checkEnd() {
+	if t.ended && len(t.pending) == 0 {
+		t.last = ShardEnd
+	}
+}
+
+// Position returns the highest sequence number that is safe to checkpoint.
+func (t *Tracker) Position() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// Pending returns the number of records that are tracked but not yet safe
+// to checkpoint.
+func (t *Tracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// Checkpointer owns one Tracker per shard of a stream and periodically
+// writes their positions to a Store.
+type Checkpointer struct {
+	store      Store
+	streamName string
+
+	mu       sync.Mutex
+	trackers map[string]*Tracker
+}
+
+// NewCheckpointer returns a Checkpointer for streamName backed by store.
+func NewCheckpointer(store Store, streamName string) *Checkpointer {
+	return &Checkpointer{
+		store:      store,
+		streamName: streamName,
+		trackers:   make(map[string]*Tracker),
+	}
+}
+
+// Resume returns the stored sequence number to resume the shard from,
+// or an empty string if the shard should be read from the beginning.
+func (c *Checkpointer) Resume(ctx context.Context, shardID string) (string, error) {
+	seq, err := c.store.Get(ctx, c.streamName, shardID)
+	if err != nil {
+		return "", err
+	}
+	// Start from a fresh tracker: the shard may have been processed by another
+	// worker since this one last owned it.
+	c.mu.Lock()
+	c.trackers[shardID] = &Tracker{streamName: c.streamName, shardID: shardID, last: seq, flushed: seq}
+	c.mu.Unlock()
+	return seq, nil
+}
+
+// Stored returns the sequence number currently persisted for shardID
+// without affecting its tracker.
+func (c *Checkpointer) Stored(ctx context.Context, shardID string) (string, error) {
+	return c.store.Get(ctx, c.streamName, shardID)
+}
+
+// Release stops tracking shardID without writing its position. It is used
+// when the shard's lease moves to another worker, which then owns the
+// checkpoint; acknowledgements for records still in flight are ignored.
+func (c *Checkpointer) Release(shardID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.trackers, shardID)
+}
+
+// Tracker returns the tracker for shardID, creating it on first use.
+func (c *Checkpointer) Tracker(shardID string) *Tracker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.trackers[shardID]
+	if !ok {
+		t = &Tracker{streamName: c.streamName, shardID: shardID}
+		c.trackers[shardID] = t
+	}
+	return t
+}
+
+// Flush writes every shard position that changed since the last flush.
+// It returns the first error encountered but still attempts all shards.
+func (c *Checkpointer) Flush(ctx context.Context) error {
+	c.mu.Lock()
+	trackers := make([]*Tracker, 0, len(c.trackers))
+	for _, t := range c.trackers {
+		trackers = append(trackers, t)
+	}
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, t := range trackers {
+		t.mu.Lock()
+		seq, flushed := t.last, t.flushed
+		t.mu.Unlock()
+		if seq == "" || seq == flushed {
+			continue
+		}
+		if err := c.store.Put(ctx, c.streamName, t.shardID, seq); err != nil {
+			log.Printf("Error writing checkpoint for shard %s: %v", t.shardID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		t.mu.Lock()
+		t.flushed = seq
+		t.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Run flushes checkpoints every interval until ctx is cancelled.
+func (c *Checkpointer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
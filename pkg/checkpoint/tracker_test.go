@@ -0,0 +1,80 @@
+package checkpoint
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestTracker_AdvancesOnlyPastContiguousAcks(t *testing.T) {
+	cp := NewCheckpointer(NewFileStore(filepath.Join(t.TempDir(), "cp.json")), "stream")
+	tracker := cp.Tracker("shard-0")
+
+	ack1 := tracker.Track("1")
+	ack2 := tracker.Track("2")
+	ack3 := tracker.Track("3")
+
+	// Finishing a later record must not move the checkpoint past an earlier one.
+	ack3()
+	if pos := tracker.Position(); pos != "" {
+		t.Errorf("expected no position while record 1 is in flight, got %q", pos)
+	}
+
+	ack1()
+	if pos := tracker.Position(); pos != "1" {
+		t.Errorf("expected position 1, got %q", pos)
+	}
+
+	ack2()
+	if pos := tracker.Position(); pos != "3" {
+		t.Errorf("expected position 3, got %q", pos)
+	}
+	if n := tracker.Pending(); n != 0 {
+		t.Errorf("expected no pending records, got %d", n)
+	}
+}
+
+func TestCheckpointer_FlushAndResume(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "cp.json"))
+	ctx := context.Background()
+
+	cp := NewCheckpointer(store, "stream")
+	cp.Tracker("shard-0").Track("42")()
+	if err := cp.Flush(ctx); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+
+	// A new checkpointer (e.g. after a restart) resumes from the stored position.
+	restarted := NewCheckpointer(store, "stream")
+	seq, err := restarted.Resume(ctx, "shard-0")
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if seq != "42" {
+		t.Errorf("expected to resume after 42, got %q", seq)
+	}
+
+	seq, err = restarted.Resume(ctx, "shard-1")
+	if err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if seq != "" {
+		t.Errorf("expected empty position for unknown shard, got %q", seq)
+	}
+}
+
+func TestTracker_EndWaitsForInFlightRecords(t *testing.T) {
+	cp := NewCheckpointer(NewFileStore(filepath.Join(t.TempDir(), "cp.json")), "stream")
+	tracker := cp.Tracker("shard-0")
+
+	ack := tracker.Track("7")
+	tracker.End()
+	if pos := tracker.Position(); pos == ShardEnd {
+		t.Fatal("shard must not be marked finished while a record is in flight")
+	}
+
+	ack()
+	if pos := tracker.Position(); pos != ShardEnd {
+		t.Errorf("expected %s after the last record, got %q", ShardEnd, pos)
+	}
+}
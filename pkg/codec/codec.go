@@ -0,0 +1,159 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"iot-insighthub/pkg/api"
+)
+
+// Codec decodes one payload format into readings. A payload may hold one
+// reading or several.
+type Codec interface {
+	Name() string
+	Decode(data []byte) ([]api.TelemetryData, error)
+}
+
+// Header bytes select a codec for a stream record. The producer prepends
+// one to the payload. None of them can start a valid JSON, Protobuf or
+// compressed payload, and a CBOR or MessagePack payload holding readings
+// never starts with one either, so headerless records are still recognised.
+const (
+	HeaderJSON      byte = 0x01
+	HeaderProtobuf  byte = 0x02
+	HeaderCBOR      byte = 0x03
+	HeaderMsgPack   byte = 0x04
+	HeaderSenMLJSON byte = 0x05
+	HeaderSenMLCBOR byte = 0x06
+)
+
+// headerLimit bounds the header bytes: a record starting with a lower byte
+// has a header.
+const headerLimit byte = 0x08
+
+// ErrUnsupported is returned for a content type, content encoding or
+// record header that no registered codec handles.
+var ErrUnsupported = errors.New("unsupported payload format")
+
+// Registry picks the codec for a payload, by HTTP Content-Type or by the
+// record's header byte, and undoes gzip or zstd compression first.
+// Codecs are registered before the registry is used; it is then safe for
+// concurrent use.
+type Registry struct {
+	codecs       map[string]Codec
+	contentTypes map[string]Codec
+	headers      map[byte]Codec
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		codecs:       make(map[string]Codec),
+		contentTypes: make(map[string]Codec),
+		headers:      make(map[byte]Codec),
+	}
+}
+
+// Register makes c available under header, which may be zero for none, and
+// under each of contentTypes.
+func (r *Registry) Register(c Codec, header byte, contentTypes ...string) {
+	r.codecs[c.Name()] = c
+	if header != 0 {
+		r.headers[header] = c
+	}
+	for _, ct := range contentTypes {
+		r.contentTypes[ct] = c
+	}
+}
+
+// std is the registry returned by Default.
+var std = newDefault()
+
+// Default returns a registry with every built-in codec: JSON, Protobuf, CBOR,
+// MessagePack and SenML in its JSON and CBOR forms.
+func Default() *Registry { return std }
+
+// newDefault builds the registry returned by Default.
+func newDefault() *Registry {
+	r := NewRegistry()
+	r.Register(JSON{}, HeaderJSON, "application/json", "text/json")
+	r.Register(Protobuf{}, HeaderProtobuf, "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf")
+	r.Register(CBOR{}, HeaderCBOR, "application/cbor")
+	r.Register(MsgPack{}, HeaderMsgPack, "application/msgpack", "application/x-msgpack", "application/vnd.msgpack")
+	r.Register(SenMLJSON{}, HeaderSenMLJSON, "application/senml+json")
+	r.Register(SenMLCBOR{}, HeaderSenMLCBOR, "application/senml+cbor")
+	return r
+}
+
+// DecodeHTTP decodes a request body. contentEncoding may be empty, "gzip",
+// "zstd" or "identity"; a compressed body is also recognised by its magic
+// number. An empty contentType is treated like a stream record.
+func (r *Registry) DecodeHTTP(contentType, contentEncoding string, body []byte) ([]api.TelemetryData, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity", "gzip", "zstd", "x-gzip":
+	default:
+		return nil, fmt.Errorf("%w: content encoding %q", ErrUnsupported, contentEncoding)
+	}
+	body, err := decompress(body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType == "" {
+		return r.decodeRecord(body)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: content type %q", ErrUnsupported, contentType)
+	}
+	c, ok := r.contentTypes[mediaType]
+	if !ok {
+		return nil, fmt.Errorf("%w: content type %q", ErrUnsupported, mediaType)
+	}
+	return c.Decode(body)
+}
+
+// DecodeRecord decodes a stream record. The codec is chosen by the record's
+// header byte if it has one. Otherwise a payload starting with "{" is JSON
+// and one starting with "[" is SenML JSON. Compressed records are
+// recognised by their gzip or zstd magic number.
+func (r *Registry) DecodeRecord(data []byte) ([]api.TelemetryData, error) {
+	data, err := decompress(data)
+	if err != nil {
+		return nil, err
+	}
+	return r.decodeRecord(data)
+}
+
+// decodeRecord is DecodeRecord on an uncompressed payload.
+func (r *Registry) decodeRecord(data []byte) ([]api.TelemetryData, error) {
+	if len(data) == 0 {
+		return nil, errors.New("empty payload")
+	}
+	if data[0] < headerLimit {
+		c, ok := r.headers[data[0]]
+		if !ok {
+			return nil, fmt.Errorf("%w: header byte %#02x", ErrUnsupported, data[0])
+		}
+		// The payload after the header may itself be compressed.
+		payload, err := decompress(data[1:])
+		if err != nil {
+			return nil, err
+		}
+		return c.Decode(payload)
+	}
+	var name string
+	switch trimmed := bytes.TrimLeft(data, " \t\r\n"); {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		name = JSON{}.Name()
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		name = SenMLJSON{}.Name()
+	}
+	c, ok := r.codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no header byte and not JSON", ErrUnsupported)
+	}
+	return c.Decode(data)
+}
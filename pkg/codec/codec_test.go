@@ -0,0 +1,233 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"iot-insighthub/pkg/api"
+)
+
+var want = []api.TelemetryData{
+	{DeviceID: "dev-1", Value: 21.5, Time: 1700000000, MessageID: "m-1", Unit: "degC"},
+	{DeviceID: "dev-2", Value: -3, Time: 1700000060},
+}
+
+func encodeProtobuf(readings []api.TelemetryData) []byte {
+	var batch []byte
+	for _, r := range readings {
+		var msg []byte
+		msg = protowire.AppendTag(msg, readingDeviceID, protowire.BytesType)
+		msg = protowire.AppendString(msg, r.DeviceID)
+		msg = protowire.AppendTag(msg, readingValue, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(r.Value))
+		msg = protowire.AppendTag(msg, readingTime, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(r.Time))
+		if r.MessageID != "" {
+			msg = protowire.AppendTag(msg, readingMessageID, protowire.BytesType)
+			msg = protowire.AppendString(msg, r.MessageID)
+		}
+		if r.Unit != "" {
+			msg = protowire.AppendTag(msg, readingUnit, protowire.BytesType)
+			msg = protowire.AppendString(msg, r.Unit)
+		}
+		// An unknown field, as sent by a newer producer.
+		msg = protowire.AppendTag(msg, 9, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, 7)
+		batch = protowire.AppendTag(batch, batchReadings, protowire.BytesType)
+		batch = protowire.AppendBytes(batch, msg)
+	}
+	return batch
+}
+
+func wireReadings(readings []api.TelemetryData) []reading {
+	out := make([]reading, len(readings))
+	for i, r := range readings {
+		out[i] = reading{DeviceID: r.DeviceID, Value: r.Value, Time: r.Time, MessageID: r.MessageID, Unit: r.Unit}
+	}
+	return out
+}
+
+func mustMarshal(b []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestRegistry_DecodeHTTPByContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/json", []byte(`[{"device_id":"dev-1","value":21.5,"time":1700000000,"message_id":"m-1","unit":"degC"},{"device_id":"dev-2","value":-3,"time":1700000060}]`)},
+		{"application/x-protobuf", encodeProtobuf(want)},
+		{"application/cbor", mustMarshal(cbor.Marshal(wireReadings(want)))},
+		{"application/msgpack", mustMarshal(msgpack.Marshal(wireReadings(want)))},
+	}
+	for _, tt := range tests {
+		got, err := Default().DecodeHTTP(tt.contentType+"; charset=utf-8", "", tt.body)
+		if err != nil {
+			t.Errorf("%s: %v", tt.contentType, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v", tt.contentType, got)
+		}
+	}
+}
+
+func TestRegistry_DecodesSingleReadings(t *testing.T) {
+	one := want[:1]
+	bodies := map[string][]byte{
+		"application/json":    []byte(`{"device_id":"dev-1","value":21.5,"time":1700000000,"message_id":"m-1","unit":"degC"}`),
+		"application/cbor":    mustMarshal(cbor.Marshal(wireReadings(one)[0])),
+		"application/msgpack": mustMarshal(msgpack.Marshal(wireReadings(one)[0])),
+	}
+	for contentType, body := range bodies {
+		got, err := Default().DecodeHTTP(contentType, "", body)
+		if err != nil || !reflect.DeepEqual(got, one) {
+			t.Errorf("%s: got %+v, %v", contentType, got, err)
+		}
+	}
+}
+
+func TestRegistry_UnsupportedContentType(t *testing.T) {
+	_, err := Default().DecodeHTTP("text/csv", "", []byte("dev-1,1,2"))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported, got %v", err)
+	}
+	_, err = Default().DecodeHTTP("application/json", "br", []byte("{}"))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for brotli, got %v", err)
+	}
+}
+
+func TestRegistry_DecodeRecordByHeaderByte(t *testing.T) {
+	payloads := map[byte][]byte{
+		HeaderProtobuf: encodeProtobuf(want),
+		HeaderCBOR:     mustMarshal(cbor.Marshal(wireReadings(want))),
+		HeaderMsgPack:  mustMarshal(msgpack.Marshal(wireReadings(want))),
+	}
+	for header, payload := range payloads {
+		got, err := Default().DecodeRecord(append([]byte{header}, payload...))
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("header %#02x: got %+v, %v", header, got, err)
+		}
+	}
+
+	if _, err := Default().DecodeRecord([]byte{0x07, 0x00}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for an unassigned header byte, got %v", err)
+	}
+	if _, err := Default().DecodeRecord(encodeProtobuf(want)); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for headerless Protobuf, got %v", err)
+	}
+}
+
+func TestRegistry_DecodeRecordSniffsJSON(t *testing.T) {
+	got, err := Default().DecodeRecord([]byte(` {"device_id":"dev-1","value":1,"time":5}`))
+	if err != nil || len(got) != 1 || got[0].DeviceID != "dev-1" {
+		t.Errorf("expected a JSON reading, got %+v, %v", got, err)
+	}
+	got, err = Default().DecodeRecord([]byte(`[{"n":"dev-1","v":1,"t":1700000000}]`))
+	if err != nil || len(got) != 1 || got[0].DeviceID != "dev-1" {
+		t.Errorf("expected a SenML reading, got %+v, %v", got, err)
+	}
+}
+
+func TestRegistry_Decompresses(t *testing.T) {
+	plain := append([]byte{HeaderCBOR}, mustMarshal(cbor.Marshal(wireReadings(want)))...)
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(plain)
+	zw.Close()
+
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zs := enc.EncodeAll(plain, nil)
+
+	for name, data := range map[string][]byte{"gzip": gz.Bytes(), "zstd": zs} {
+		got, err := Default().DecodeRecord(data)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %+v, %v", name, got, err)
+		}
+	}
+
+	// A record may also compress only the payload after its header.
+	got, err := Default().DecodeRecord(append([]byte{HeaderProtobuf}, enc.EncodeAll(encodeProtobuf(want), nil)...))
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("compressed after header: got %+v, %v", got, err)
+	}
+
+	got, err = Default().DecodeHTTP("application/x-protobuf", "zstd", enc.EncodeAll(encodeProtobuf(want), nil))
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("zstd over HTTP: got %+v, %v", got, err)
+	}
+}
+
+func TestRegistry_LimitsDecompressedSize(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(make([]byte, MaxDecompressedSize+1))
+	zw.Close()
+	if _, err := Default().DecodeRecord(gz.Bytes()); err == nil {
+		t.Error("expected an oversized payload to be rejected")
+	}
+}
+
+func TestSenML_ResolvesBaseFields(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	pack := `[
+		{"bn":"urn:dev:ow:10e2073a01080063:","bt":1.320067464e+09,"bu":"Cel","bv":10,"n":"temp","v":11.5},
+		{"n":"hum","u":"%RH","v":2,"t":60},
+		{"n":"label","vs":"kitchen"},
+		{"bn":"dev-2/","bt":0,"bv":0,"n":"temp","v":20,"t":-5}
+	]`
+	got, err := Default().DecodeHTTP("application/senml+json", "", []byte(pack))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []api.TelemetryData{
+		{DeviceID: "urn:dev:ow:10e2073a01080063:temp", Value: 21.5, Time: 1320067464, Unit: "Cel"},
+		{DeviceID: "urn:dev:ow:10e2073a01080063:hum", Value: 12, Time: 1320067524, Unit: "%RH"},
+		{DeviceID: "dev-2/temp", Value: 20, Time: 1699999995, Unit: "Cel"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestSenML_CBORUsesIntegerLabels(t *testing.T) {
+	pack := []map[int]interface{}{
+		{-2: "dev-1/", -3: 1700000000.0, -4: "Cel", 0: "temp", 2: 21.5},
+		{0: "hum", 1: "%RH", 2: 40, 6: 10},
+	}
+	got, err := Default().DecodeHTTP("application/senml+cbor", "", mustMarshal(cbor.Marshal(pack)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []api.TelemetryData{
+		{DeviceID: "dev-1/temp", Value: 21.5, Time: 1700000000, Unit: "Cel"},
+		{DeviceID: "dev-1/hum", Value: 40, Time: 1700000010, Unit: "%RH"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %+v", got)
+	}
+
+	if _, err := Default().DecodeHTTP("application/senml+json", "", []byte(`[{"n":"x","vs":"text"}]`)); err == nil {
+		t.Error("expected an error for a pack without numeric values")
+	}
+}
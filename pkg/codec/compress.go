@@ -0,0 +1,51 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MaxDecompressedSize bounds a decompressed payload, so that a small
+// compressed record cannot exhaust memory.
+const MaxDecompressedSize = 4 << 20
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// zstdDecoder is shared by every decompress call; DecodeAll is safe for
+// concurrent use.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(MaxDecompressedSize))
+
+// decompress returns data with gzip or zstd compression undone, recognised
+// by its magic number. Uncompressed data is returned as is.
+func decompress(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		out, err := io.ReadAll(io.LimitReader(zr, MaxDecompressedSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		if len(out) > MaxDecompressedSize {
+			return nil, fmt.Errorf("gzip: payload exceeds %d bytes", MaxDecompressedSize)
+		}
+		return out, nil
+	case bytes.HasPrefix(data, zstdMagic):
+		out, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("zstd: %w", err)
+		}
+		return out, nil
+	default:
+		return data, nil
+	}
+}
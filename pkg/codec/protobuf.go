@@ -0,0 +1,107 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"iot-insighthub/pkg/api"
+)
+
+// Protobuf decodes the Batch message of telemetry.proto. Fields are read
+// with protowire rather than generated code; unknown fields are skipped so
+// that producers can add fields first.
+type Protobuf struct{}
+
+// Name returns "protobuf".
+func (Protobuf) Name() string { return "protobuf" }
+
+// Field numbers of telemetry.proto.
+const (
+	batchReadings = 1
+
+	readingDeviceID  = 1
+	readingValue     = 2
+	readingTime      = 3
+	readingMessageID = 4
+	readingUnit      = 5
+)
+
+// Decode decodes a Batch.
+func (Protobuf) Decode(data []byte) ([]api.TelemetryData, error) {
+	var out []api.TelemetryData
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num != batchReadings || typ != protowire.BytesType {
+			return nil
+		}
+		r, err := decodeReading(field)
+		if err != nil {
+			return fmt.Errorf("reading %d: %w", len(out), err)
+		}
+		out = append(out, r)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return nil, errors.New("protobuf batch holds no readings")
+	}
+	return out, nil
+}
+
+// decodeReading decodes a Reading message.
+func decodeReading(data []byte) (api.TelemetryData, error) {
+	var r api.TelemetryData
+	err := walkFields(data, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		switch {
+		case num == readingDeviceID && typ == protowire.BytesType:
+			r.DeviceID = string(field)
+		case num == readingMessageID && typ == protowire.BytesType:
+			r.MessageID = string(field)
+		case num == readingUnit && typ == protowire.BytesType:
+			r.Unit = string(field)
+		case num == readingValue && typ == protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(field)
+			r.Value = math.Float64frombits(v)
+		case num == readingTime && typ == protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(field)
+			r.Time = int64(v)
+		}
+		return nil
+	})
+	return r, err
+}
+
+// walkFields calls fn with each field of the message in data. For
+// length-delimited fields, field is the content; otherwise it is the
+// encoded value.
+func walkFields(data []byte, fn func(num protowire.Number, typ protowire.Type, field []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		var field []byte
+		if typ == protowire.BytesType {
+			v, m := protowire.ConsumeBytes(data)
+			if m < 0 {
+				return protowire.ParseError(m)
+			}
+			field, n = v, m
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field = data[:n]
+		}
+		if err := fn(num, typ, field); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
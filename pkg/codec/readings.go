@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"iot-insighthub/pkg/api"
+)
+
+// reading is api.TelemetryData as encoded by the CBOR and MessagePack
+// codecs: a map with the same keys as the JSON form.
+type reading struct {
+	DeviceID  string  `cbor:"device_id" msgpack:"device_id"`
+	Value     float64 `cbor:"value" msgpack:"value"`
+	Time      int64   `cbor:"time" msgpack:"time"`
+	MessageID string  `cbor:"message_id,omitempty" msgpack:"message_id,omitempty"`
+	Unit      string  `cbor:"unit,omitempty" msgpack:"unit,omitempty"`
+}
+
+// toReadings converts decoded readings to the internal model.
+func toReadings(in []reading) []api.TelemetryData {
+	out := make([]api.TelemetryData, len(in))
+	for i, r := range in {
+		out[i] = api.TelemetryData{DeviceID: r.DeviceID, Value: r.Value, Time: r.Time, MessageID: r.MessageID, Unit: r.Unit}
+	}
+	return out
+}
+
+// JSON decodes api.TelemetryData objects, one or an array of them.
+type JSON struct{}
+
+// Name returns "json".
+func (JSON) Name() string { return "json" }
+
+// Decode decodes data.
+func (JSON) Decode(data []byte) ([]api.TelemetryData, error) {
+	if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+		var out []api.TelemetryData
+		if err := json.Unmarshal(data, &out); err != nil {
+			return nil, err
+		}
+		return out, nil
+	}
+	var one api.TelemetryData
+	if err := json.Unmarshal(data, &one); err != nil {
+		return nil, err
+	}
+	return []api.TelemetryData{one}, nil
+}
+
+// CBOR decodes RFC 8949 maps with the keys of the JSON form, one or an
+// array of them.
+type CBOR struct{}
+
+// Name returns "cbor".
+func (CBOR) Name() string { return "cbor" }
+
+// Decode decodes data.
+func (CBOR) Decode(data []byte) ([]api.TelemetryData, error) {
+	var in []reading
+	if len(data) > 0 && data[0]>>5 == 4 { // major type 4: array
+		if err := cbor.Unmarshal(data, &in); err != nil {
+			return nil, err
+		}
+	} else {
+		var r reading
+		if err := cbor.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		in = []reading{r}
+	}
+	return toReadings(in), nil
+}
+
+// MsgPack decodes MessagePack maps with the keys of the JSON form, one or
+// an array of them.
+type MsgPack struct{}
+
+// Name returns "msgpack".
+func (MsgPack) Name() string { return "msgpack" }
+
+// Decode decodes data.
+func (MsgPack) Decode(data []byte) ([]api.TelemetryData, error) {
+	var in []reading
+	if len(data) > 0 && (data[0]&0xf0 == 0x90 || data[0] == 0xdc || data[0] == 0xdd) { // fixarray, array 16, array 32
+		if err := msgpack.Unmarshal(data, &in); err != nil {
+			return nil, err
+		}
+	} else {
+		var r reading
+		if err := msgpack.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		in = []reading{r}
+	}
+	return toReadings(in), nil
+}
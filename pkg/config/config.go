@@ -0,0 +1,374 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration structs describe every setting with struct tags:
+//
+//	yaml, toml  key in the configuration file (nested structs become sections)
+//	env         environment variable
+//	flag        command-line flag
+//	usage       help text for the flag
+//	secret      "true" to redact the value when the configuration is printed
+//	reload      "true" if the binary applies the value on SIGHUP without a restart
+//
+// Values are applied in increasing order of precedence: the defaults already
+// set on the struct, the configuration file, environment variables and
+// finally command-line flags.
+
+// FileEnv is the environment variable naming the configuration file when the
+// -config flag is not given.
+const FileEnv = "CONFIG_FILE"
+
+// Validator is implemented by configuration structs that check their own
+// values once every source has been applied.
+type Validator interface {
+	Validate() error
+}
+
+// Loader loads a configuration struct and remembers where it came from so
+// the same sources can be read again on reload.
+type Loader struct {
+	file  string
+	flags map[string]string
+}
+
+// Load registers a flag for every field of cfg tagged with flag, plus
+// -config, on fs, parses args and fills cfg, which must be a pointer to a
+// struct holding the defaults. fs may already define flags of its own.
+func Load(fs *flag.FlagSet, args []string, cfg interface{}) (*Loader, error) {
+	fields, err := walk(cfg)
+	if err != nil {
+		return nil, err
+	}
+	file := fs.String("config", os.Getenv(FileEnv), "configuration file (.yaml, .yml or .toml)")
+	values := make(map[string]*flagValue)
+	for _, f := range fields {
+		if f.flag == "" {
+			continue
+		}
+		v := &flagValue{def: format(f.value)}
+		values[f.flag] = v
+		fs.Var(v, f.flag, f.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	l := &Loader{file: *file, flags: make(map[string]string)}
+	for name, v := range values {
+		if v.set {
+			l.flags[name] = v.value
+		}
+	}
+	return l, l.Reload(cfg)
+}
+
+// File returns the configuration file in use, or an empty string.
+func (l *Loader) File() string {
+	return l.file
+}
+
+// Reload fills cfg from the configuration file, the environment and the flags
+// given to Load, on top of the defaults already set on cfg. The file is read
+// again, so edits made since Load are picked up.
+func (l *Loader) Reload(cfg interface{}) error {
+	if l.file != "" {
+		if err := decodeFile(l.file, cfg); err != nil {
+			return err
+		}
+	}
+	fields, err := walk(cfg)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, f := range fields {
+		if f.env == "" {
+			continue
+		}
+		if s, ok := os.LookupEnv(f.env); ok {
+			if err := set(f.value, s); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", f.env, err))
+			}
+		}
+	}
+	for _, f := range fields {
+		if s, ok := l.flags[f.flag]; ok && f.flag != "" {
+			if err := set(f.value, s); err != nil {
+				problems = append(problems, fmt.Sprintf("-%s: %v", f.flag, err))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	if v, ok := cfg.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// LoadYAML reads the YAML file at path into cfg, a pointer to a struct,
+// and validates it if it is a Validator. Unknown keys are rejected so that
+// typos do not silently disable a setting. An empty file leaves cfg as it
+// is. Errors name the file.
+func LoadYAML(path string, cfg interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := decodeYAML(f, cfg); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if v, ok := cfg.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// decodeFile reads path into cfg, choosing the format by extension. Keys that
+// do not match any field are reported, since they are usually typos.
+func decodeFile(path string, cfg interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		if err := decodeYAML(f, cfg); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.NewDecoder(f).Decode(cfg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, k := range undecoded {
+				keys[i] = k.String()
+			}
+			return fmt.Errorf("%s: unknown keys %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return fmt.Errorf("%s: unsupported configuration format %q", path, ext)
+	}
+	return nil
+}
+
+// decodeYAML decodes the YAML document in r into cfg, rejecting keys that
+// do not match any field. An empty document leaves cfg as it is.
+func decodeYAML(r io.Reader, cfg interface{}) error {
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// field is a leaf setting of a configuration struct.
+type field struct {
+	key    string // dotted file key, e.g. "checkpoint.store"
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	reload bool
+	value  reflect.Value
+}
+
+// walk returns the leaf fields of the struct cfg points to, in declaration order.
+func walk(cfg interface{}) ([]field, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config: expected a pointer to a struct, got %T", cfg)
+	}
+	var fields []field
+	walkStruct(v.Elem(), "", &fields)
+	return fields, nil
+}
+
+// walkStruct appends the leaf fields of v to fields, prefixing keys with prefix.
+func walkStruct(v reflect.Value, prefix string, fields *[]field) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(sf.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		key := prefix + name
+		if sf.Type.Kind() == reflect.Struct {
+			walkStruct(v.Field(i), key+".", fields)
+			continue
+		}
+		*fields = append(*fields, field{
+			key:    key,
+			env:    sf.Tag.Get("env"),
+			flag:   sf.Tag.Get("flag"),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			reload: sf.Tag.Get("reload") == "true",
+			value:  v.Field(i),
+		})
+	}
+}
+
+// set parses s into v according to v's type. Lists are comma-separated.
+func set(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// format renders v the way set parses it.
+func format(v reflect.Value) string {
+	switch x := v.Interface().(type) {
+	case time.Duration:
+		return x.String()
+	case []string:
+		return strings.Join(x, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// flagValue records whether a flag was given so that unset flags do not
+// override the file or the environment.
+type flagValue struct {
+	def   string
+	value string
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	if v.set {
+		return v.value
+	}
+	return v.def
+}
+
+func (v *flagValue) Set(s string) error {
+	v.value = s
+	v.set = true
+	return nil
+}
+
+// ValidationError lists every problem found in a configuration so they can
+// all be fixed at once.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Check collects validation problems for a Validate method.
+type Check struct {
+	problems []string
+}
+
+// Require reports key as missing if value is empty.
+func (c *Check) Require(key, value string) {
+	if value == "" {
+		c.problems = append(c.problems, key+" is required")
+	}
+}
+
+// Assert reports the formatted problem unless ok.
+func (c *Check) Assert(ok bool, format string, args ...interface{}) {
+	if !ok {
+		c.problems = append(c.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+// OneOf reports key as invalid unless value is one of allowed.
+func (c *Check) OneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	c.problems = append(c.problems, fmt.Sprintf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+}
+
+// Err returns a *ValidationError with the collected problems, or nil.
+func (c *Check) Err() error {
+	if len(c.problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: c.problems}
+}
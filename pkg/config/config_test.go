@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name     string        `yaml:"name" toml:"name" env:"TEST_CONFIG_NAME" flag:"name"`
+	Workers  int           `yaml:"workers" toml:"workers" env:"TEST_CONFIG_WORKERS" flag:"workers"`
+	Interval time.Duration `yaml:"interval" toml:"interval" env:"TEST_CONFIG_INTERVAL" reload:"true"`
+	DB       struct {
+		DSN string `yaml:"dsn" toml:"dsn" env:"TEST_CONFIG_DSN" secret:"true"`
+	} `yaml:"db" toml:"db"`
+}
+
+func (c *testConfig) Validate() error {
+	var check Check
+	check.Require("name", c.Name)
+	check.Assert(c.Workers > 0, "workers must be positive")
+	return check.Err()
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoad_Precedence checks that flags override the environment, which
+// overrides the file, which overrides the defaults.
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, "config.yaml", "name: from-file\nworkers: 2\ninterval: 10s\ndb:\n  dsn: postgres://file\n")
+	t.Setenv("TEST_CONFIG_WORKERS", "3")
+	t.Setenv("TEST_CONFIG_NAME", "from-env")
+
+	cfg := &testConfig{Name: "default", Workers: 1, Interval: time.Second}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := Load(fs, []string{"-config", path, "-name", "from-flag"}, cfg); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Name != "from-flag" || cfg.Workers != 3 || cfg.Interval != 10*time.Second || cfg.DB.DSN != "postgres://file" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+// TestLoad_ValidationErrors checks that every problem is reported at once.
+func TestLoad_ValidationErrors(t *testing.T) {
+	t.Setenv("TEST_CONFIG_INTERVAL", "soon")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, nil, &testConfig{Workers: 1})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || !strings.Contains(err.Error(), "TEST_CONFIG_INTERVAL") {
+		t.Fatalf("expected a parse error for TEST_CONFIG_INTERVAL, got %v", err)
+	}
+
+	t.Setenv("TEST_CONFIG_INTERVAL", "1s")
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err = Load(fs, []string{"-workers", "0"}, &testConfig{})
+	if !errors.As(err, &verr) || len(verr.Problems) != 2 {
+		t.Fatalf("expected two validation problems, got %v", err)
+	}
+}
+
+// TestLoad_UnknownTOMLKey checks that misspelled keys are rejected.
+func TestLoad_UnknownTOMLKey(t *testing.T) {
+	path := writeFile(t, "config.toml", "name = \"x\"\nworkerz = 2\n")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	_, err := Load(fs, []string{"-config", path}, &testConfig{Workers: 1})
+	if err == nil || !strings.Contains(err.Error(), "workerz") {
+		t.Fatalf("expected an unknown key error, got %v", err)
+	}
+}
+
+// TestLoadYAML_RejectsUnknownKeysAndValidates checks that a YAML file is
+// decoded strictly and validated, with errors naming the file.
+func TestLoadYAML_RejectsUnknownKeysAndValidates(t *testing.T) {
+	var cfg testConfig
+	if err := LoadYAML(writeFile(t, "rules.yaml", "name: x\nworkers: 2\n"), &cfg); err != nil || cfg.Workers != 2 {
+		t.Fatalf("LoadYAML = %v, config %+v", err, cfg)
+	}
+	path := writeFile(t, "rules.yaml", "name: x\nworkerz: 2\n")
+	if err := LoadYAML(path, &testConfig{}); err == nil || !strings.Contains(err.Error(), "workerz") || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected an unknown key error naming the file, got %v", err)
+	}
+	if err := LoadYAML(writeFile(t, "rules.yaml", "name: x\n"), &testConfig{}); err == nil || !strings.Contains(err.Error(), "workers must be positive") {
+		t.Errorf("expected a validation error, got %v", err)
+	}
+}
+
+// TestLoadYAML_EmptyFileKeepsDefaults checks that LoadYAML and Load both
+// read an empty YAML file as an empty configuration.
+func TestLoadYAML_EmptyFileKeepsDefaults(t *testing.T) {
+	path := writeFile(t, "config.yaml", "")
+	cfg := testConfig{Name: "default", Workers: 1}
+	if err := LoadYAML(path, &cfg); err != nil || cfg.Name != "default" {
+		t.Errorf("LoadYAML = %v, config %+v", err, cfg)
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	if _, err := Load(fs, []string{"-config", path}, &cfg); err != nil || cfg.Workers != 1 {
+		t.Errorf("Load = %v, config %+v", err, cfg)
+	}
+}
+
+// TestPrint_RedactsSecrets checks that secrets never appear in the output.
+func TestPrint_RedactsSecrets(t *testing.T) {
+	cfg := &testConfig{Name: "ingestor", Workers: 4}
+	cfg.DB.DSN = "postgres://user:hunter2@db/telemetry"
+	var buf bytes.Buffer
+	if err := Print(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, "db.dsn = "+redacted) {
+		t.Fatalf("secret not redacted:\n%s", out)
+	}
+	if !strings.Contains(out, `workers = "4"`) {
+		t.Fatalf("missing workers:\n%s", out)
+	}
+}
+
+// TestDiff_SplitsReloadable checks that only fields tagged reload are
+// reported as applied.
+func TestDiff_SplitsReloadable(t *testing.T) {
+	old := &testConfig{Name: "a", Workers: 1, Interval: time.Second}
+	next := &testConfig{Name: "a", Workers: 2, Interval: 2 * time.Second}
+	reloaded, restart := Diff(old, next)
+	if len(reloaded) != 1 || reloaded[0] != "interval" || len(restart) != 1 || restart[0] != "workers" {
+		t.Fatalf("reloaded=%v restart=%v", reloaded, restart)
+	}
+}
@@ -0,0 +1,20 @@
+package configtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"iot-insighthub/pkg/config"
+)
+
+// LoadYAML writes yaml to a file in a temporary directory removed after the
+// test, and reads it into cfg with config.LoadYAML.
+func LoadYAML(t testing.TB, yaml string, cfg interface{}) error {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return config.LoadYAML(path, cfg)
+}
@@ -0,0 +1,28 @@
+package config
+
+import (
+	"fmt"
+	"io"
+)
+
+// redacted replaces secret values when a configuration is printed.
+const redacted = "[REDACTED]"
+
+// Print writes the effective configuration as "key = value" lines, one per
+// setting, with secrets redacted.
+func Print(w io.Writer, cfg interface{}) error {
+	fields, err := walk(cfg)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		value := fmt.Sprintf("%q", format(f.value))
+		if f.secret && !f.value.IsZero() {
+			value = redacted
+		}
+		if _, err := fmt.Fprintf(w, "%s = %s\n", f.key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
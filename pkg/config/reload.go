@@ -0,0 +1,50 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// Diff compares two configurations of the same type and returns the keys
+// that changed, split into those tagged reload and those that only take
+// effect after a restart.
+func Diff(old, next interface{}) (reloaded, restart []string) {
+	oldFields, err := walk(old)
+	if err != nil {
+		return nil, nil
+	}
+	nextFields, err := walk(next)
+	if err != nil || len(nextFields) != len(oldFields) {
+		return nil, nil
+	}
+	for i, f := range oldFields {
+		if reflect.DeepEqual(f.value.Interface(), nextFields[i].value.Interface()) {
+			continue
+		}
+		if f.reload {
+			reloaded = append(reloaded, f.key)
+		} else {
+			restart = append(restart, f.key)
+		}
+	}
+	return reloaded, restart
+}
+
+// OnSIGHUP calls reload every time the process receives SIGHUP, until ctx is
+// cancelled.
+func OnSIGHUP(ctx context.Context, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package dlq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DirSink stores one JSON file per entry in a local directory.
+type DirSink struct {
+	dir string
+}
+
+// NewDirSink returns a DirSink rooted at dir, creating it if needed.
+func NewDirSink(dir string) (*DirSink, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirSink{dir: dir}, nil
+}
+
+// Put writes e atomically to <dir>/<id>.json.
+func (s *DirSink) Put(ctx context.Context, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(e.ID))
+}
+
+// List reads every entry in the directory.
+func (s *DirSink) List(ctx context.Context) ([]Entry, error) {
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FailedAt.Before(entries[j].FailedAt) })
+	return entries, nil
+}
+
+// Delete removes the entry with the given ID.
+func (s *DirSink) Delete(ctx context.Context, id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Count returns the number of entry files.
+func (s *DirSink) Count(ctx context.Context) (int, error) {
+	names, err := s.names()
+	return len(names), err
+}
+
+// names returns the entry file names in the directory.
+func (s *DirSink) names() ([]string, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, de := range dirEntries {
+		if !de.IsDir() && strings.HasSuffix(de.Name(), ".json") {
+			names = append(names, de.Name())
+		}
+	}
+	return names, nil
+}
+
+// path returns the file path for an entry ID.
+func (s *DirSink) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,49 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/source"
+)
+
+func TestDirSink_PutListDelete(t *testing.T) {
+	ctx := context.Background()
+	sink, err := NewDirSink(t.TempDir())
+	if err != nil {
+		t.Fatalf("create sink: %v", err)
+	}
+
+	record := source.NewRecord("dev-1", []byte("{bad json"), "shard-0", "495", time.Now(), nil)
+	entry := NewEntry(record, errors.New("unexpected end of JSON input"), 1)
+	if err := sink.Put(ctx, entry); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	// A second failure of the same record replaces the entry instead of duplicating it.
+	entry.Attempts = 2
+	if err := sink.Put(ctx, entry); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	entries, err := sink.List(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	got := entries[0]
+	if got.Partition != "shard-0" || got.Offset != "495" || got.Attempts != 2 || string(got.Data) != "{bad json" {
+		t.Errorf("unexpected entry: %+v", got)
+	}
+
+	if err := sink.Delete(ctx, got.ID); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if n, _ := sink.Count(ctx); n != 0 {
+		t.Errorf("expected empty sink after delete, got %d entries", n)
+	}
+}
@@ -0,0 +1,65 @@
+package dlq
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	"iot-insighthub/pkg/source"
+)
+
+// Entry is a record that failed processing, kept with enough context to
+// diagnose and replay it.
+type Entry struct {
+	ID        string    `json:"id"`
+	Partition string    `json:"partition"` // shard or partition the record came from
+	Offset    string    `json:"offset"`    // sequence number or offset within the partition
+	Key       string    `json:"key"`
+	Data      []byte    `json:"data"` // raw payload, base64 in JSON
+	ArrivedAt time.Time `json:"arrived_at"`
+	Reason    string    `json:"reason"`
+	Attempts  int       `json:"attempts"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// NewEntry builds an Entry for a record that failed with err.
+// The ID is derived from the record's position, so writing the same
+// record twice overwrites a single entry.
+func NewEntry(record *source.Record, err error, attempts int) Entry {
+	return Entry{
+		ID:        entryID(record.Partition, record.Offset),
+		Partition: record.Partition,
+		Offset:    record.Offset,
+		Key:       record.Key,
+		Data:      record.Data,
+		ArrivedAt: record.ArrivedAt,
+		Reason:    err.Error(),
+		Attempts:  attempts,
+		FailedAt:  time.Now().UTC(),
+	}
+}
+
+// Record rebuilds the source record so the entry can be reprocessed.
+func (e Entry) Record() *source.Record {
+	return source.NewRecord(e.Key, e.Data, e.Partition, e.Offset, e.ArrivedAt, nil)
+}
+
+// Sink stores dead-letter entries.
+// Implementations must be safe for concurrent use.
+type Sink interface {
+	// Put stores e, replacing any entry with the same ID.
+	Put(ctx context.Context, e Entry) error
+	// List returns every stored entry, oldest failure first.
+	List(ctx context.Context) ([]Entry, error)
+	// Delete removes the entry with the given ID.
+	Delete(ctx context.Context, id string) error
+	// Count returns the number of stored entries.
+	Count(ctx context.Context) (int, error)
+}
+
+// entryID returns a filesystem- and object-key-safe ID for a record position.
+func entryID(partition, offset string) string {
+	sum := sha1.Sum([]byte(partition + "/" + offset))
+	return hex.EncodeToString(sum[:])
+}
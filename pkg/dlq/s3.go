@@ -0,0 +1,110 @@
+package dlq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// S3Sink stores one JSON object per entry under a prefix of an S3 or
+// S3-compatible (e.g. MinIO) bucket.
+type S3Sink struct {
+	client s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// NewS3Sink returns an S3Sink that writes to bucket under prefix.
+func NewS3Sink(client s3iface.S3API, bucket, prefix string) *S3Sink {
+	return &S3Sink{client: client, bucket: bucket, prefix: prefix}
+}
+
+// Put uploads e to <prefix>/<id>.json.
+func (s *S3Sink) Put(ctx context.Context, e Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(e.ID)),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+// List downloads every entry under the prefix.
+func (s *S3Sink) List(ctx context.Context) ([]Entry, error) {
+	keys, err := s.keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]Entry, 0, len(keys))
+	for _, key := range keys {
+		out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(out.Body)
+		out.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		var e Entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FailedAt.Before(entries[j].FailedAt) })
+	return entries, nil
+}
+
+// Delete removes the object for the entry with the given ID.
+func (s *S3Sink) Delete(ctx context.Context, id string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(id)),
+	})
+	return err
+}
+
+// Count returns the number of entry objects under the prefix.
+func (s *S3Sink) Count(ctx context.Context) (int, error) {
+	keys, err := s.keys(ctx)
+	return len(keys), err
+}
+
+// keys lists the entry object keys under the prefix.
+func (s *S3Sink) keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			if key := aws.StringValue(obj.Key); strings.HasSuffix(key, ".json") {
+				keys = append(keys, key)
+			}
+		}
+		return true
+	})
+	return keys, err
+}
+
+// key returns the object key for an entry ID.
+func (s *S3Sink) key(id string) string {
+	return path.Join(s.prefix, id+".json")
+}
@@ -0,0 +1,164 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Check reports whether one part of the service is healthy. details, if not
+// nil, is included in the JSON view whether or not the check passed.
+type Check func(ctx context.Context) (details interface{}, err error)
+
+// Result is the outcome of one check.
+type Result struct {
+	Healthy bool `json:"healthy"`
+	// Liveness reports whether the check also gates /healthz.
+	Liveness bool        `json:"liveness"`
+	Error    string      `json:"error,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+// Report is the outcome of every check.
+type Report struct {
+	Live      bool              `json:"live"`
+	Ready     bool              `json:"ready"`
+	CheckedAt time.Time         `json:"checked_at"`
+	Checks    map[string]Result `json:"checks"`
+}
+
+// Checker runs named checks. Liveness checks gate both /healthz and /readyz;
+// readiness checks gate only /readyz. A liveness check should fail only when
+// restarting the process would help, since Kubernetes restarts the pod.
+type Checker struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]check
+}
+
+// check is a registered Check.
+type check struct {
+	fn       Check
+	liveness bool
+}
+
+// NewChecker returns a Checker that gives each check up to timeout.
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	return &Checker{timeout: timeout, checks: make(map[string]check)}
+}
+
+// AddLiveness registers a check that gates liveness and readiness.
+func (c *Checker) AddLiveness(name string, fn Check) {
+	c.add(name, fn, true)
+}
+
+// AddReadiness registers a check that gates readiness only.
+func (c *Checker) AddReadiness(name string, fn Check) {
+	c.add(name, fn, false)
+}
+
+// add registers fn under name, replacing any check with that name.
+func (c *Checker) add(name string, fn Check, liveness bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check{fn: fn, liveness: liveness}
+}
+
+// Run runs every check concurrently and returns their results.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]check, len(c.checks))
+	for name, chk := range c.checks {
+		checks[name] = chk
+	}
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	report := Report{Live: true, Ready: true, CheckedAt: time.Now().UTC(), Checks: make(map[string]Result, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, chk := range checks {
+		wg.Add(1)
+		go func(name string, chk check) {
+			defer wg.Done()
+			details, err := chk.fn(ctx)
+			result := Result{Healthy: err == nil, Liveness: chk.liveness, Details: details}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Ready = false
+				if chk.liveness {
+					report.Live = false
+				}
+			}
+		}(name, chk)
+	}
+	wg.Wait()
+	return report
+}
+
+// LiveHandler serves /healthz: 200 while every liveness check passes,
+// otherwise 503 with the failing checks.
+func (c *Checker) LiveHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		writeStatus(w, report, report.Live, true)
+	})
+}
+
+// ReadyHandler serves /readyz: 200 while every check passes, otherwise 503
+// with the failing checks.
+func (c *Checker) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		writeStatus(w, report, report.Ready, false)
+	})
+}
+
+// DetailHandler serves the full Report as JSON. It responds 503 when the
+// service is not ready, so it can be used as a readiness probe as well.
+func (c *Checker) DetailHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := c.Run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		if !report.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	})
+}
+
+// writeStatus writes a plain-text probe response. Only liveness checks are
+// listed when liveOnly is set.
+func writeStatus(w http.ResponseWriter, report Report, ok, liveOnly bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if ok {
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	var failed []string
+	for name, result := range report.Checks {
+		if !result.Healthy && (result.Liveness || !liveOnly) {
+			failed = append(failed, name+": "+result.Error)
+		}
+	}
+	sort.Strings(failed)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, strings.Join(failed, "\n"))
+}
@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func pass(context.Context) (interface{}, error) { return map[string]int{"shards": 2}, nil }
+
+func fail(context.Context) (interface{}, error) { return nil, errors.New("database unreachable") }
+
+func TestChecker_ReadinessFailureKeepsLiveness(t *testing.T) {
+	c := NewChecker(0)
+	c.AddLiveness("partitions", pass)
+	c.AddReadiness("sink", fail)
+
+	rec := httptest.NewRecorder()
+	c.LiveHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected /healthz to pass, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	c.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "sink: database unreachable") {
+		t.Errorf("expected /readyz to fail on the sink, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestChecker_LivenessFailureFailsBoth(t *testing.T) {
+	c := NewChecker(0)
+	c.AddLiveness("partitions", fail)
+
+	for _, h := range []http.Handler{c.LiveHandler(), c.ReadyHandler()} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected 503, got %d", rec.Code)
+		}
+	}
+}
+
+func TestChecker_DetailReportsEveryCheck(t *testing.T) {
+	c := NewChecker(0)
+	c.AddLiveness("partitions", pass)
+	c.AddReadiness("sink", fail)
+
+	rec := httptest.NewRecorder()
+	c.DetailHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statusz", nil))
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if !report.Live || report.Ready {
+		t.Errorf("expected live and not ready, got live=%v ready=%v", report.Live, report.Ready)
+	}
+	if r := report.Checks["partitions"]; !r.Healthy || r.Details == nil {
+		t.Errorf("expected healthy partitions with details, got %+v", r)
+	}
+	if r := report.Checks["sink"]; r.Healthy || r.Error != "database unreachable" {
+		t.Errorf("expected failed sink check, got %+v", r)
+	}
+}
@@ -0,0 +1,132 @@
+package kafka
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	kafkago "github.com/segmentio/kafka-go"
+	"iot-insighthub/pkg/source"
+)
+
+// Source reads a Kafka topic as a source.Source. Partitions are split
+// between replicas with the same lease table used for Kinesis shards, and
+// offsets are checkpointed through the shared checkpoint store instead of
+// consumer-group commits, so every transport behaves the same way.
+type Source struct {
+	brokers     []string
+	topic       string
+	cfg         source.PartitionedConfig
+	partitioned *source.Partitioned
+}
+
+// NewSource returns a Source that reads topic from brokers.
+func NewSource(brokers []string, topic string, cfg source.PartitionedConfig) *Source {
+	s := &Source{brokers: brokers, topic: topic, cfg: cfg}
+	s.partitioned = source.NewPartitioned(s, cfg)
+	return s
+}
+
+// Run consumes every partition this replica holds a lease for until ctx is cancelled.
+func (s *Source) Run(ctx context.Context, out chan<- *source.Record) error {
+	return s.partitioned.Run(ctx, out)
+}
+
+// Release hands this replica's partition leases back to the lease table.
+func (s *Source) Release(ctx context.Context) {
+	s.partitioned.Release(ctx)
+}
+
+// Partitions reports the partitions this replica holds a lease for.
+func (s *Source) Partitions() []source.PartitionStatus {
+	return s.partitioned.Partitions()
+}
+
+// Discover returns every partition of the topic. Kafka partitions never
+// close, so none are reported as finished.
+func (s *Source) Discover(ctx context.Context) (ready, finished []string, err error) {
+	var conn *kafkago.Conn
+	for _, broker := range s.brokers {
+		if conn, err = kafkago.DialContext(ctx, "tcp", broker); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions(s.topic)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range partitions {
+		ready = append(ready, strconv.Itoa(p.ID))
+	}
+	sort.Strings(ready)
+	return ready, nil, nil
+}
+
+// Read consumes one partition, starting after its checkpointed offset or
+// from the earliest retained message.
+func (s *Source) Read(ctx context.Context, partition string, out chan<- *source.Record) {
+	id, err := strconv.Atoi(partition)
+	if err != nil {
+		log.Printf("Invalid Kafka partition %q: %v", partition, err)
+		return
+	}
+	seq, err := s.cfg.Checkpointer.Resume(ctx, partition)
+	if err != nil {
+		log.Printf("Error reading checkpoint for partition %s: %v", partition, err)
+		return
+	}
+	offset := kafkago.FirstOffset
+	if seq != "" {
+		last, err := strconv.ParseInt(seq, 10, 64)
+		if err != nil {
+			log.Printf("Invalid checkpoint %q for partition %s: %v", seq, partition, err)
+			return
+		}
+		offset = last + 1
+		log.Printf("Resuming partition %s at offset %d", partition, offset)
+	}
+
+	reader := kafkago.NewReader(kafkago.ReaderConfig{
+		Brokers:   s.brokers,
+		Topic:     s.topic,
+		Partition: id,
+		MaxBytes:  10e6,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(offset); err != nil {
+		log.Printf("Error seeking partition %s: %v", partition, err)
+		return
+	}
+	tracker := s.cfg.Checkpointer.Tracker(partition)
+
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Error fetching from partition %s: %v", partition, err)
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		s.partitioned.MarkRead(partition)
+		pos := strconv.FormatInt(m.Offset, 10)
+		record := source.NewRecord(string(m.Key), m.Value, partition, pos, m.Time, tracker.Track(pos))
+		select {
+		case out <- record:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/source"
+)
+
+// BackfillConfig selects the records a Backfill reads.
+type BackfillConfig struct {
+	// Start and End bound the records' approximate arrival times. End must
+	// not be in the future.
+	Start, End time.Time
+	// Shards restricts the backfill to these shards; empty means every shard
+	// still within the stream's retention period.
+	Shards []string
+	// Polling paces GetRecords calls. Backfill shares each shard's 5 reads/s
+	// with the live consumer, so MaxReadsPerSecond should leave room for it.
+	Polling PollingConfig
+}
+
+// ShardProgress reports how far a Backfill has read one shard.
+type ShardProgress struct {
+	ShardID string
+	// Records is the number of Kinesis records read so far. An aggregated
+	// KPL record counts once however many user records it holds.
+	Records int64
+	// Position is the arrival time of the last record read.
+	Position time.Time
+	// Done is set once the shard has been read up to End.
+	Done bool
+	// Err is set if the shard could not be read.
+	Err error
+}
+
+// Backfill reads every shard of a stream from AT_TIMESTAMP Start up to End
+// and then stops. It is a source.Source, so records go through the normal
+// pipeline; it neither reads nor writes checkpoints and holds no leases, so
+// it can run next to the live ingestor.
+type Backfill struct {
+	client     kinesisiface.KinesisAPI
+	streamName string
+	cfg        BackfillConfig
+
+	mu       sync.Mutex
+	progress map[string]*ShardProgress
+}
+
+// NewBackfill returns a Backfill of streamName.
+func NewBackfill(client kinesisiface.KinesisAPI, streamName string, cfg BackfillConfig) *Backfill {
+	cfg.Polling = cfg.Polling.withDefaults()
+	return &Backfill{
+		client:     client,
+		streamName: streamName,
+		cfg:        cfg,
+		progress:   make(map[string]*ShardProgress),
+	}
+}
+
+// Run reads every selected shard concurrently and returns once each has
+// been read up to End, or ctx is cancelled. It returns an error if a shard
+// could not be read at all.
+func (b *Backfill) Run(ctx context.Context, out chan<- *source.Record) error {
+	if !b.cfg.Start.Before(b.cfg.End) {
+		return errors.New("backfill start must be before end")
+	}
+	shardIDs, err := b.selectShards(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Backfilling %d shards of %s from %s to %s", len(shardIDs), b.streamName, b.cfg.Start.Format(time.RFC3339), b.cfg.End.Format(time.RFC3339))
+
+	// Trackers are only needed to hand records an Ack; nothing is stored.
+	cp := checkpoint.NewCheckpointer(nil, b.streamName)
+	b.mu.Lock()
+	for _, id := range shardIDs {
+		b.progress[id] = &ShardProgress{ShardID: id}
+	}
+	b.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, id := range shardIDs {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			b.readShard(ctx, id, cp.Tracker(id), out)
+		}(id)
+	}
+	wg.Wait()
+
+	var failed int
+	for _, p := range b.Progress() {
+		if p.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.New("some shards could not be backfilled")
+	}
+	return ctx.Err()
+}
+
+// Progress returns the progress of every shard, in shard ID order.
+func (b *Backfill) Progress() []ShardProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	progress := make([]ShardProgress, 0, len(b.progress))
+	for _, p := range b.progress {
+		progress = append(progress, *p)
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].ShardID < progress[j].ShardID })
+	return progress
+}
+
+// selectShards returns the configured shards, or every listed shard.
+func (b *Backfill) selectShards(ctx context.Context) ([]string, error) {
+	if len(b.cfg.Shards) > 0 {
+		return b.cfg.Shards, nil
+	}
+	shards, err := ListShards(ctx, b.client, b.streamName)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(shards))
+	for i, s := range shards {
+		ids[i] = aws.StringValue(s.ShardId)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// update applies fn to the progress of shardID.
+func (b *Backfill) update(shardID string, fn func(p *ShardProgress)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fn(b.progress[shardID])
+}
+
+// readShard reads one shard from Start until it reaches a record that
+// arrived after End, the tip of the shard or the end of a closed shard.
+func (b *Backfill) readShard(ctx context.Context, shardID string, tracker *checkpoint.Tracker, out chan<- *source.Record) {
+	scheduler := newPollScheduler(b.cfg.Polling)
+	iteratorType, seq := kinesis.ShardIteratorTypeAtTimestamp, ""
+	var iterator *string
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		if iterator == nil {
+			var err error
+			iterator, err = shardIterator(ctx, b.client, b.streamName, shardID, iteratorType, seq, b.cfg.Start)
+			if err != nil {
+				if errorCode(err) == kinesis.ErrCodeResourceNotFoundException {
+					// The shard does not exist or has been trimmed.
+					b.update(shardID, func(p *ShardProgress) { p.Err = err })
+					return
+				}
+				log.Printf("Error getting shard iterator for shard %s: %v", shardID, err)
+				sleepContext(ctx, scheduler.failed())
+				continue
+			}
+		}
+
+		if err := scheduler.wait(ctx); err != nil {
+			return
+		}
+		output, err := b.client.GetRecordsWithContext(ctx, &kinesis.GetRecordsInput{
+			ShardIterator: iterator,
+			Limit:         aws.Int64(scheduler.limit),
+		})
+		if err != nil {
+			countGetRecordsError(shardID, err)
+			switch errorCode(err) {
+			case kinesis.ErrCodeExpiredIteratorException:
+				iterator = nil
+			case kinesis.ErrCodeProvisionedThroughputExceededException:
+				sleepContext(ctx, scheduler.throttled())
+			default:
+				log.Printf("Error fetching records from shard %s: %v", shardID, err)
+				sleepContext(ctx, scheduler.failed())
+			}
+			continue
+		}
+
+		// Records are in arrival order, so everything from the first record
+		// after End onwards is outside the window.
+		records := output.Records
+		n := sort.Search(len(records), func(i int) bool {
+			return aws.TimeValue(records[i].ApproximateArrivalTimestamp).After(b.cfg.End)
+		})
+		pastEnd := n < len(records)
+		records = records[:n]
+
+		if !dispatch(ctx, shardID, records, nil, tracker, out) {
+			return
+		}
+		if n > 0 {
+			last := records[n-1]
+			iteratorType = kinesis.ShardIteratorTypeAfterSequenceNumber
+			seq = aws.StringValue(last.SequenceNumber)
+			b.update(shardID, func(p *ShardProgress) {
+				p.Records += int64(n)
+				p.Position = aws.TimeValue(last.ApproximateArrivalTimestamp)
+			})
+		}
+
+		// A response with no lag holds every record up to the tip; anything
+		// after it arrived after the backfill started, so after End.
+		caughtUp := aws.Int64Value(output.MillisBehindLatest) == 0
+		if pastEnd || caughtUp || output.NextShardIterator == nil {
+			b.update(shardID, func(p *ShardProgress) { p.Done = true })
+			return
+		}
+		iterator = output.NextShardIterator
+		lag := time.Duration(aws.Int64Value(output.MillisBehindLatest)) * time.Millisecond
+		sleepContext(ctx, scheduler.success(len(output.Records), lag))
+	}
+}
@@ -0,0 +1,95 @@
+package kinesis
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"iot-insighthub/pkg/source"
+)
+
+// fakeShard serves one shard's records two at a time, as if the stream had
+// no more data after the last one.
+type fakeShard struct {
+	kinesisiface.KinesisAPI
+	records  []*kinesis.Record
+	iterator *kinesis.GetShardIteratorInput // the first request
+}
+
+func (f *fakeShard) GetShardIteratorWithContext(ctx aws.Context, in *kinesis.GetShardIteratorInput, _ ...request.Option) (*kinesis.GetShardIteratorOutput, error) {
+	if f.iterator == nil {
+		f.iterator = in
+	}
+	start := 0
+	for start < len(f.records) && f.records[start].ApproximateArrivalTimestamp.Before(aws.TimeValue(in.Timestamp)) {
+		start++
+	}
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(strconv.Itoa(start))}, nil
+}
+
+func (f *fakeShard) GetRecordsWithContext(ctx aws.Context, in *kinesis.GetRecordsInput, _ ...request.Option) (*kinesis.GetRecordsOutput, error) {
+	i, _ := strconv.Atoi(aws.StringValue(in.ShardIterator))
+	j := i + 2
+	if j > len(f.records) {
+		j = len(f.records)
+	}
+	behind := int64(len(f.records)-j) * 1000
+	return &kinesis.GetRecordsOutput{
+		Records:            f.records[i:j],
+		NextShardIterator:  aws.String(strconv.Itoa(j)),
+		MillisBehindLatest: aws.Int64(behind),
+	}, nil
+}
+
+func TestBackfill_ReadsTimeWindow(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	client := &fakeShard{}
+	for i := 0; i < 10; i++ {
+		client.records = append(client.records, &kinesis.Record{
+			SequenceNumber:              aws.String(strconv.Itoa(100 + i)),
+			PartitionKey:                aws.String("dev-1"),
+			Data:                        []byte("x"),
+			ApproximateArrivalTimestamp: aws.Time(base.Add(time.Duration(i) * time.Minute)),
+		})
+	}
+
+	b := NewBackfill(client, "stream", BackfillConfig{
+		Start:   base.Add(3 * time.Minute),
+		End:     base.Add(6 * time.Minute),
+		Shards:  []string{"shard-0"},
+		Polling: PollingConfig{MaxReadsPerSecond: 1000, IdleInterval: time.Millisecond},
+	})
+	out := make(chan *source.Record, 20)
+	if err := b.Run(context.Background(), out); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	close(out)
+
+	if it := aws.StringValue(client.iterator.ShardIteratorType); it != kinesis.ShardIteratorTypeAtTimestamp {
+		t.Errorf("expected an AT_TIMESTAMP iterator, got %s", it)
+	}
+	var offsets []string
+	for r := range out {
+		offsets = append(offsets, r.Offset)
+		r.Ack()
+	}
+	want := []string{"103", "104", "105", "106"}
+	if len(offsets) != len(want) {
+		t.Fatalf("expected offsets %v, got %v", want, offsets)
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Fatalf("expected offsets %v, got %v", want, offsets)
+		}
+	}
+
+	progress := b.Progress()
+	if len(progress) != 1 || !progress[0].Done || progress[0].Records != 4 || !progress[0].Position.Equal(base.Add(6*time.Minute)) {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+}
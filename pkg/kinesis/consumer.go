@@ -2,44 +2,179 @@ package kinesis
 
 import (
 	"context"
+	"fmt"
 	"log"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/source"
 )
 
-// Consumer wraps the Kinesis client and stream info.
+// Shard read modes.
+const (
+	// ModePolling reads shards with GetRecords, sharing each shard's
+	// 2 MB/s read limit with every other polling consumer.
+	ModePolling = "polling"
+	// ModeFanOut reads shards through enhanced fan-out subscriptions with
+	// dedicated throughput and push delivery.
+	ModeFanOut = "fanout"
+)
+
+// ConsumerConfig configures a Consumer.
+type ConsumerConfig struct {
+	// Mode is ModePolling or ModeFanOut.
+	Mode string
+	// ConsumerName is the enhanced fan-out consumer to register in ModeFanOut.
+	ConsumerName string
+	// Polling paces GetRecords calls in ModePolling.
+	Polling PollingConfig
+
+	source.PartitionedConfig
+}
+
+// Consumer reads a Kinesis stream as a source.Source. Shards are split
+// between replicas with leases, resumed from their checkpoints and followed
+// across splits and merges.
 type Consumer struct {
-	client     *kinesis.Kinesis
-	streamName string
+	client      kinesisiface.KinesisAPI
+	streamName  string
+	cfg         ConsumerConfig
+	consumerARN string
+	partitioned *source.Partitioned
 }
 
 // NewConsumer instantiates a new Kinesis consumer.
-func NewConsumer(client *kinesis.Kinesis, streamName string) *Consumer {
-	return &Consumer{
+func NewConsumer(client kinesisiface.KinesisAPI, streamName string, cfg ConsumerConfig) *Consumer {
+	if cfg.Mode == "" {
+		cfg.Mode = ModePolling
+	}
+	cfg.Polling = cfg.Polling.withDefaults()
+	c := &Consumer{
 		client:     client,
 		streamName: streamName,
+		cfg:        cfg,
 	}
+	c.partitioned = source.NewPartitioned(c, cfg.PartitionedConfig)
+	return c
 }
 
-// GetRecords retrieves records from Kinesis (simplified example).
-func (c *Consumer) GetRecords(ctx context.Context) ([]*kinesis.Record, error) {
-	shardIteratorOutput, err := c.client.GetShardIterator(&kinesis.GetShardIteratorInput{
-		StreamName:        &c.streamName,
-		ShardId:           aws.String("shardId-000000000000"), // In production, iterate across shards.
-		ShardIteratorType: aws.String("TRIM_HORIZON"),
-	})
-	if err != nil {
-		return nil, err
+// Run consumes every shard this replica holds a lease for until ctx is cancelled.
+func (c *Consumer) Run(ctx context.Context, out chan<- *source.Record) error {
+	switch c.cfg.Mode {
+	case ModePolling:
+	case ModeFanOut:
+		arn, err := RegisterConsumer(ctx, c.client, c.streamName, c.cfg.ConsumerName)
+		if err != nil {
+			return err
+		}
+		log.Printf("Using enhanced fan-out consumer %s", arn)
+		c.consumerARN = arn
+	default:
+		return fmt.Errorf("unknown consumer mode %q", c.cfg.Mode)
 	}
+	return c.partitioned.Run(ctx, out)
+}
+
+// Release hands this replica's shard leases back to the lease table.
+func (c *Consumer) Release(ctx context.Context) {
+	c.partitioned.Release(ctx)
+}
+
+// Partitions reports the shards this replica holds a lease for.
+func (c *Consumer) Partitions() []source.PartitionStatus {
+	return c.partitioned.Partitions()
+}
 
-	shardIterator := shardIteratorOutput.ShardIterator
-	output, err := c.client.GetRecords(&kinesis.GetRecordsInput{
-		ShardIterator: shardIterator,
-	})
+// Discover lists the stream's shards. Closed shards whose checkpoint is
+// ShardEnd are reported as finished, and only shards whose parents are
+// drained are reported as ready, so new children of a split or merge are
+// consumed after every record of their parents.
+func (c *Consumer) Discover(ctx context.Context) (ready, finished []string, err error) {
+	shards, err := ListShards(ctx, c.client, c.streamName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	log.Printf("fetched %d records", len(output.Records))
-	return output.Records, nil
+	done := make(map[string]bool)
+	for _, s := range shards {
+		id := aws.StringValue(s.ShardId)
+		seq, err := c.cfg.Checkpointer.Stored(ctx, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if seq == checkpoint.ShardEnd {
+			done[id] = true
+			finished = append(finished, id)
+		}
+	}
+	ready = ReadyShards(shards, func(id string) bool { return done[id] })
+	return ready, finished, nil
+}
+
+// Read consumes a single shard using the configured mode.
+func (c *Consumer) Read(ctx context.Context, shardID string, out chan<- *source.Record) {
+	// Stop reporting lag for shards this replica no longer reads.
+	defer metrics.ShardMillisBehindLatest.DeleteLabelValues(shardID)
+	if c.cfg.Mode == ModeFanOut {
+		c.readFanOut(ctx, shardID, out)
+		return
+	}
+	c.readPolling(ctx, shardID, out)
+}
+
+// dispatch tracks each record for checkpointing and sends it to out.
+// KPL aggregated records are unpacked into their user records, each tracked
+// under its own sub-sequence number; user records marked done were processed
+// before a restart and are skipped. It returns false if ctx was cancelled
+// before all were sent.
+func dispatch(ctx context.Context, shardID string, records []*kinesis.Record, done *processed, tracker *checkpoint.Tracker, out chan<- *source.Record) bool {
+	for _, r := range records {
+		seq := aws.StringValue(r.SequenceNumber)
+		arrivedAt := aws.TimeValue(r.ApproximateArrivalTimestamp)
+		if !IsAggregated(r.Data) {
+			record := source.NewRecord(aws.StringValue(r.PartitionKey), r.Data, shardID, seq, arrivedAt, tracker.Track(seq))
+			if !send(ctx, out, record) {
+				return false
+			}
+			continue
+		}
+
+		users, err := Deaggregate(r.Data)
+		if err != nil {
+			// Pass the record through unchanged so it fails decoding and is
+			// kept in the dead-letter queue rather than silently dropped.
+			log.Printf("Error de-aggregating record %s from shard %s: %v", seq, shardID, err)
+			metrics.KPLErrors.Inc()
+			record := source.NewRecord(aws.StringValue(r.PartitionKey), r.Data, shardID, seq, arrivedAt, tracker.Track(seq))
+			if !send(ctx, out, record) {
+				return false
+			}
+			continue
+		}
+		metrics.KPLAggregatedRecords.Inc()
+		for i, u := range users {
+			if done.skip(seq, i) {
+				continue
+			}
+			subSeq := subSequenceNumber(seq, i)
+			record := source.NewRecord(u.PartitionKey, u.Data, shardID, subSeq, arrivedAt, tracker.Track(subSeq))
+			if !send(ctx, out, record) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// send delivers record to out. It returns false if ctx is cancelled first.
+func send(ctx context.Context, out chan<- *source.Record, record *source.Record) bool {
+	select {
+	case out <- record:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
@@ -0,0 +1,171 @@
+package kinesis
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/source"
+)
+
+// RegisterConsumer makes sure an enhanced fan-out consumer named
+// consumerName is registered on the stream and returns its ARN once the
+// consumer is ACTIVE. An existing registration is reused, so every replica
+// of a deployment shares one consumer and its dedicated throughput.
+func RegisterConsumer(ctx context.Context, client kinesisiface.KinesisAPI, streamName, consumerName string) (string, error) {
+	summary, err := client.DescribeStreamSummaryWithContext(ctx, &kinesis.DescribeStreamSummaryInput{
+		StreamName: aws.String(streamName),
+	})
+	if err != nil {
+		return "", err
+	}
+	streamARN := summary.StreamDescriptionSummary.StreamARN
+
+	desc, err := client.DescribeStreamConsumerWithContext(ctx, &kinesis.DescribeStreamConsumerInput{
+		StreamARN:    streamARN,
+		ConsumerName: aws.String(consumerName),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == kinesis.ErrCodeResourceNotFoundException {
+		log.Printf("Registering enhanced fan-out consumer %s", consumerName)
+		reg, regErr := client.RegisterStreamConsumerWithContext(ctx, &kinesis.RegisterStreamConsumerInput{
+			StreamARN:    streamARN,
+			ConsumerName: aws.String(consumerName),
+		})
+		// Another replica may have registered the consumer concurrently.
+		if aerr, ok := regErr.(awserr.Error); ok && aerr.Code() == kinesis.ErrCodeResourceInUseException {
+			return waitForConsumer(ctx, client, streamARN, consumerName)
+		}
+		if regErr != nil {
+			return "", regErr
+		}
+		if aws.StringValue(reg.Consumer.ConsumerStatus) == kinesis.ConsumerStatusActive {
+			return aws.StringValue(reg.Consumer.ConsumerARN), nil
+		}
+		return waitForConsumer(ctx, client, streamARN, consumerName)
+	}
+	if err != nil {
+		return "", err
+	}
+	if aws.StringValue(desc.ConsumerDescription.ConsumerStatus) == kinesis.ConsumerStatusActive {
+		return aws.StringValue(desc.ConsumerDescription.ConsumerARN), nil
+	}
+	return waitForConsumer(ctx, client, streamARN, consumerName)
+}
+
+// waitForConsumer polls the consumer until it becomes ACTIVE.
+func waitForConsumer(ctx context.Context, client kinesisiface.KinesisAPI, streamARN *string, consumerName string) (string, error) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		desc, err := client.DescribeStreamConsumerWithContext(ctx, &kinesis.DescribeStreamConsumerInput{
+			StreamARN:    streamARN,
+			ConsumerName: aws.String(consumerName),
+		})
+		if err != nil {
+			return "", err
+		}
+		if aws.StringValue(desc.ConsumerDescription.ConsumerStatus) == kinesis.ConsumerStatusActive {
+			return aws.StringValue(desc.ConsumerDescription.ConsumerARN), nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// readFanOut consumes a shard through an enhanced fan-out subscription.
+// Records are pushed over an HTTP/2 event stream, so each consumer gets its
+// own 2 MB/s per shard and no polling delay. Subscriptions expire after five
+// minutes; they are renewed from the last continuation sequence number so no
+// record is skipped or read twice.
+func (c *Consumer) readFanOut(ctx context.Context, shardID string, out chan<- *source.Record) {
+	resumeFrom, err := c.cfg.Checkpointer.Resume(ctx, shardID)
+	if err != nil {
+		log.Printf("Error reading checkpoint for shard %s: %v", shardID, err)
+		return
+	}
+	if resumeFrom == checkpoint.ShardEnd {
+		log.Printf("Shard %s has already been fully processed", shardID)
+		return
+	}
+	tracker := c.cfg.Checkpointer.Tracker(shardID)
+
+	backoff := 1 * time.Second
+	const maxBackoff = 30 * time.Second
+
+	iteratorType, seq, done := startingPosition(resumeFrom)
+	for ctx.Err() == nil {
+		position := &kinesis.StartingPosition{Type: aws.String(iteratorType)}
+		if seq != "" {
+			position.SequenceNumber = aws.String(seq)
+		}
+		output, err := c.client.SubscribeToShardWithContext(ctx, &kinesis.SubscribeToShardInput{
+			ConsumerARN:      aws.String(c.consumerARN),
+			ShardId:          aws.String(shardID),
+			StartingPosition: position,
+		})
+		if err != nil {
+			// ResourceInUseException is expected briefly while a previous
+			// subscription for the shard is still being torn down.
+			log.Printf("Error subscribing to shard %s: %v", shardID, err)
+			sleepContext(ctx, backoff)
+			backoff = time.Duration(math.Min(float64(maxBackoff), float64(backoff)*2))
+			continue
+		}
+		backoff = 1 * time.Second
+
+		next, ended := c.readSubscription(ctx, output.GetStream(), shardID, done, tracker, out)
+		if next != "" {
+			iteratorType, seq = kinesis.ShardIteratorTypeAfterSequenceNumber, next
+		}
+		if ended {
+			log.Printf("Reached the end of closed shard %s", shardID)
+			tracker.End()
+			return
+		}
+	}
+}
+
+// readSubscription forwards the records of one subscription until the
+// stream closes. It returns the last continuation sequence number and
+// whether the shard has been read to the end.
+func (c *Consumer) readSubscription(ctx context.Context, stream *kinesis.SubscribeToShardEventStream, shardID string, done *processed, tracker *checkpoint.Tracker, out chan<- *source.Record) (string, bool) {
+	defer stream.Close()
+	var seq string
+	for {
+		select {
+		case event, ok := <-stream.Events():
+			if !ok {
+				if err := stream.Err(); err != nil {
+					log.Printf("Subscription for shard %s ended with error: %v", shardID, err)
+				}
+				return seq, false
+			}
+			e, ok := event.(*kinesis.SubscribeToShardEvent)
+			if !ok {
+				continue
+			}
+			c.partitioned.MarkRead(shardID)
+			metrics.ShardMillisBehindLatest.WithLabelValues(shardID).Set(float64(aws.Int64Value(e.MillisBehindLatest)))
+			if !dispatch(ctx, shardID, e.Records, done, tracker, out) {
+				return seq, false
+			}
+			// A missing continuation sequence number marks the end of a closed shard.
+			if e.ContinuationSequenceNumber == nil {
+				return seq, true
+			}
+			seq = *e.ContinuationSequenceNumber
+		case <-ctx.Done():
+			return seq, false
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package kinesis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/source"
+)
+
+// subscription is what one SubscribeToShard call of fakeFanOut returns:
+// an error, or events followed by the stream closing with err.
+type subscription struct {
+	subscribeErr error
+	events       []*kinesis.SubscribeToShardEvent
+	err          error
+}
+
+// fakeFanOut serves its subscriptions in turn and records the requests.
+type fakeFanOut struct {
+	kinesisiface.KinesisAPI
+	subscriptions []subscription
+	requests      []*kinesis.SubscribeToShardInput
+}
+
+func (f *fakeFanOut) SubscribeToShardWithContext(ctx aws.Context, in *kinesis.SubscribeToShardInput, _ ...request.Option) (*kinesis.SubscribeToShardOutput, error) {
+	if len(f.requests) == len(f.subscriptions) {
+		return nil, errors.New("no more subscriptions")
+	}
+	sub := f.subscriptions[len(f.requests)]
+	f.requests = append(f.requests, in)
+	if sub.subscribeErr != nil {
+		return nil, sub.subscribeErr
+	}
+	events := make(chan kinesis.SubscribeToShardEventStreamEvent, len(sub.events))
+	for _, e := range sub.events {
+		events <- e
+	}
+	close(events)
+	stream := kinesis.NewSubscribeToShardEventStream(func(es *kinesis.SubscribeToShardEventStream) {
+		es.Reader = &fakeEventReader{events: events, err: sub.err}
+		es.StreamCloser = io.NopCloser(nil)
+	})
+	return &kinesis.SubscribeToShardOutput{EventStream: stream}, nil
+}
+
+// fakeEventReader replays a subscription's events.
+type fakeEventReader struct {
+	events chan kinesis.SubscribeToShardEventStreamEvent
+	err    error
+}
+
+func (r *fakeEventReader) Events() <-chan kinesis.SubscribeToShardEventStreamEvent { return r.events }
+func (r *fakeEventReader) Close() error                                            { return nil }
+func (r *fakeEventReader) Err() error                                              { return r.err }
+
+func event(continuation string, records ...*kinesis.Record) *kinesis.SubscribeToShardEvent {
+	e := &kinesis.SubscribeToShardEvent{Records: records, MillisBehindLatest: aws.Int64(0)}
+	if continuation != "" {
+		e.ContinuationSequenceNumber = aws.String(continuation)
+	}
+	return e
+}
+
+func TestReadFanOut_ResubscribesWhereItLeftOff(t *testing.T) {
+	store := checkpoint.NewFileStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	// User record 0 of the aggregated record 100 was processed before a
+	// restart.
+	if err := store.Put(context.Background(), "stream", "shard-0", subSequenceNumber("100", 0)); err != nil {
+		t.Fatal(err)
+	}
+	aggregated := &kinesis.Record{
+		SequenceNumber: aws.String("100"),
+		PartitionKey:   aws.String("dev-1"),
+		Data: aggregate([]string{"dev-1"}, []UserRecord{
+			{PartitionKey: "dev-1", Data: []byte("a")},
+			{PartitionKey: "dev-1", Data: []byte("b")},
+			{PartitionKey: "dev-1", Data: []byte("c")},
+		}),
+	}
+	plain := func(seq string) *kinesis.Record {
+		return &kinesis.Record{SequenceNumber: aws.String(seq), PartitionKey: aws.String("dev-1"), Data: []byte(seq)}
+	}
+	client := &fakeFanOut{subscriptions: []subscription{
+		// The previous subscription is still being torn down.
+		{subscribeErr: awserr.New(kinesis.ErrCodeResourceInUseException, "in use", nil)},
+		// The connection drops before any event.
+		{err: errors.New("connection reset")},
+		// Subscriptions expire after five minutes and are renewed.
+		{events: []*kinesis.SubscribeToShardEvent{event("100", aggregated)}},
+		{events: []*kinesis.SubscribeToShardEvent{event("101", plain("101"))}},
+		// No continuation: the closed shard has been read to the end.
+		{events: []*kinesis.SubscribeToShardEvent{event("", plain("102"))}},
+	}}
+
+	cp := checkpoint.NewCheckpointer(store, "stream")
+	c := NewConsumer(client, "stream", ConsumerConfig{
+		Mode:              ModeFanOut,
+		PartitionedConfig: source.PartitionedConfig{Checkpointer: cp},
+	})
+	c.consumerARN = "arn:consumer"
+	out := make(chan *source.Record, 10)
+	c.readFanOut(context.Background(), "shard-0", out)
+	close(out)
+
+	var positions []string
+	for _, in := range client.requests {
+		positions = append(positions, aws.StringValue(in.StartingPosition.Type)+" "+aws.StringValue(in.StartingPosition.SequenceNumber))
+	}
+	want := []string{
+		"AT_SEQUENCE_NUMBER 100",
+		"AT_SEQUENCE_NUMBER 100",
+		"AT_SEQUENCE_NUMBER 100",
+		"AFTER_SEQUENCE_NUMBER 100",
+		"AFTER_SEQUENCE_NUMBER 101",
+	}
+	if strings.Join(positions, ", ") != strings.Join(want, ", ") {
+		t.Errorf("subscribed at %v, want %v", positions, want)
+	}
+
+	var offsets []string
+	for r := range out {
+		offsets = append(offsets, r.Offset)
+		r.Ack()
+	}
+	if got := strings.Join(offsets, ", "); got != "100:1, 100:2, 101, 102" {
+		t.Errorf("offsets = %s, want the unprocessed user records of 100, then 101 and 102", got)
+	}
+	if pos := cp.Tracker("shard-0").Position(); pos != checkpoint.ShardEnd {
+		t.Errorf("position = %s, want %s", pos, checkpoint.ShardEnd)
+	}
+}
@@ -0,0 +1,204 @@
+package kinesis
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+// kplMagic prefixes every record aggregated by the Kinesis Producer Library.
+// It is followed by a protobuf-encoded AggregatedRecord and the MD5 digest of
+// that protobuf message.
+var kplMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// UserRecord is one of the records packed into a KPL aggregated record.
+type UserRecord struct {
+	PartitionKey string
+	Data         []byte
+}
+
+// errNotAggregated is returned by Deaggregate for plain records.
+var errNotAggregated = errors.New("not a KPL aggregated record")
+
+// IsAggregated reports whether data starts with the KPL magic prefix.
+func IsAggregated(data []byte) bool {
+	return len(data) > len(kplMagic)+md5.Size && bytes.HasPrefix(data, kplMagic)
+}
+
+// Deaggregate unpacks a KPL aggregated record into its user records, in the
+// order they were aggregated. It fails if data is not aggregated, its MD5
+// digest does not match or the protobuf message is malformed.
+func Deaggregate(data []byte) ([]UserRecord, error) {
+	if !IsAggregated(data) {
+		return nil, errNotAggregated
+	}
+	message := data[len(kplMagic) : len(data)-md5.Size]
+	digest := md5.Sum(message)
+	if !bytes.Equal(digest[:], data[len(data)-md5.Size:]) {
+		return nil, errors.New("KPL aggregated record has a bad MD5 checksum")
+	}
+	return decodeAggregatedRecord(message)
+}
+
+// decodeAggregatedRecord decodes the AggregatedRecord protobuf message:
+//
+//	message AggregatedRecord {
+//	  repeated string partition_key_table     = 1;
+//	  repeated string explicit_hash_key_table = 2;
+//	  repeated Record records                 = 3;
+//	}
+//	message Record {
+//	  required uint64 partition_key_index     = 1;
+//	  optional uint64 explicit_hash_key_index = 2;
+//	  required bytes  data                    = 3;
+//	  repeated Tag    tags                    = 4;
+//	}
+func decodeAggregatedRecord(message []byte) ([]UserRecord, error) {
+	type record struct {
+		keyIndex uint64
+		data     []byte
+	}
+	var keys []string
+	var records []record
+
+	err := decodeFields(message, func(field int, value []byte, _ uint64) error {
+		switch field {
+		case 1:
+			keys = append(keys, string(value))
+		case 3:
+			var r record
+			err := decodeFields(value, func(field int, value []byte, n uint64) error {
+				switch field {
+				case 1:
+					r.keyIndex = n
+				case 3:
+					r.data = value
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]UserRecord, len(records))
+	for i, r := range records {
+		if r.keyIndex >= uint64(len(keys)) {
+			return nil, fmt.Errorf("KPL record %d references missing partition key %d", i, r.keyIndex)
+		}
+		users[i] = UserRecord{PartitionKey: keys[r.keyIndex], Data: r.data}
+	}
+	return users, nil
+}
+
+// decodeFields walks the fields of a protobuf message and calls fn with each
+// field number and either its length-delimited bytes or its varint value.
+// Fixed-size fields are skipped.
+func decodeFields(b []byte, fn func(field int, value []byte, n uint64) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("malformed protobuf tag")
+		}
+		b = b[n:]
+		field, wireType := int(tag>>3), tag&7
+		switch wireType {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("malformed protobuf varint")
+			}
+			b = b[n:]
+			if err := fn(field, nil, v); err != nil {
+				return err
+			}
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errors.New("truncated protobuf field")
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("truncated protobuf field")
+			}
+			value := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := fn(field, value, 0); err != nil {
+				return err
+			}
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errors.New("truncated protobuf field")
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", wireType)
+		}
+	}
+	return nil
+}
+
+// subSequenceSeparator joins a sequence number and a sub-record index in the
+// offsets and checkpoints of de-aggregated records.
+const subSequenceSeparator = ":"
+
+// subSequenceNumber returns the offset of the index-th user record of the
+// aggregated record seq.
+func subSequenceNumber(seq string, index int) string {
+	return seq + subSequenceSeparator + strconv.Itoa(index)
+}
+
+// processed marks the user records of an aggregated record that were
+// processed before a restart: those of seq up to and including index sub.
+type processed struct {
+	seq string
+	sub int
+}
+
+// skip reports whether the index-th user record of seq was already processed.
+func (p *processed) skip(seq string, index int) bool {
+	return p != nil && seq == p.seq && index <= p.sub
+}
+
+// startingPosition returns the shard iterator type and sequence number to
+// resume from checkpoint. A checkpoint inside an aggregated record resumes at
+// that record so its remaining user records are read again; the returned
+// processed value lets dispatch skip the ones already done.
+func startingPosition(checkpoint string) (iteratorType, seq string, done *processed) {
+	if checkpoint == "" {
+		return kinesis.ShardIteratorTypeTrimHorizon, "", nil
+	}
+	seq, sub := parseCheckpoint(checkpoint)
+	if sub < 0 {
+		return kinesis.ShardIteratorTypeAfterSequenceNumber, seq, nil
+	}
+	return kinesis.ShardIteratorTypeAtSequenceNumber, seq, &processed{seq: seq, sub: sub}
+}
+
+// parseCheckpoint splits a checkpoint into a sequence number and the index
+// of the last processed user record within it, or -1 if the whole record
+// was processed.
+func parseCheckpoint(checkpoint string) (seq string, sub int) {
+	i := strings.LastIndex(checkpoint, subSequenceSeparator)
+	if i < 0 {
+		return checkpoint, -1
+	}
+	sub, err := strconv.Atoi(checkpoint[i+1:])
+	if err != nil {
+		return checkpoint, -1
+	}
+	return checkpoint[:i], sub
+}
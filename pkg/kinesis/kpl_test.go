@@ -0,0 +1,115 @@
+package kinesis
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/source"
+)
+
+// appendField appends a length-delimited protobuf field.
+func appendField(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// aggregate builds a KPL aggregated record the way the producer library does.
+func aggregate(keys []string, records []UserRecord) []byte {
+	var message []byte
+	index := make(map[string]int)
+	for i, k := range keys {
+		message = appendField(message, 1, []byte(k))
+		index[k] = i
+	}
+	for _, r := range records {
+		var inner []byte
+		inner = binary.AppendUvarint(inner, 1<<3|0)
+		inner = binary.AppendUvarint(inner, uint64(index[r.PartitionKey]))
+		inner = appendField(inner, 3, r.Data)
+		message = appendField(message, 3, inner)
+	}
+	digest := md5.Sum(message)
+	data := append(append([]byte{}, kplMagic...), message...)
+	return append(data, digest[:]...)
+}
+
+func TestDeaggregate_UnpacksUserRecords(t *testing.T) {
+	want := []UserRecord{
+		{PartitionKey: "dev-1", Data: []byte(`{"device_id":"dev-1","value":1,"time":1}`)},
+		{PartitionKey: "dev-2", Data: []byte(`{"device_id":"dev-2","value":2,"time":1}`)},
+		{PartitionKey: "dev-1", Data: []byte(`{"device_id":"dev-1","value":3,"time":2}`)},
+	}
+	got, err := Deaggregate(aggregate([]string{"dev-1", "dev-2"}, want))
+	if err != nil {
+		t.Fatalf("deaggregate: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d user records, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i].PartitionKey != want[i].PartitionKey || string(got[i].Data) != string(want[i].Data) {
+			t.Errorf("record %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	if IsAggregated([]byte(`{"device_id":"dev-1"}`)) {
+		t.Error("plain JSON detected as aggregated")
+	}
+}
+
+func TestDeaggregate_RejectsBadChecksum(t *testing.T) {
+	data := aggregate([]string{"dev-1"}, []UserRecord{{PartitionKey: "dev-1", Data: []byte("x")}})
+	data[len(data)-1] ^= 0xFF
+	if _, err := Deaggregate(data); err == nil {
+		t.Fatal("expected a checksum error")
+	}
+}
+
+func TestDispatch_SkipsProcessedUserRecords(t *testing.T) {
+	data := aggregate([]string{"dev-1"}, []UserRecord{
+		{PartitionKey: "dev-1", Data: []byte("a")},
+		{PartitionKey: "dev-1", Data: []byte("b")},
+		{PartitionKey: "dev-1", Data: []byte("c")},
+	})
+	records := []*kinesis.Record{
+		{SequenceNumber: aws.String("100"), PartitionKey: aws.String("dev-1"), Data: data},
+		{SequenceNumber: aws.String("101"), PartitionKey: aws.String("dev-1"), Data: []byte("plain")},
+	}
+
+	// The checkpoint says user record 0 of sequence 100 was already processed.
+	iteratorType, seq, done := startingPosition(subSequenceNumber("100", 0))
+	if iteratorType != kinesis.ShardIteratorTypeAtSequenceNumber || seq != "100" {
+		t.Fatalf("expected to resume at sequence 100, got %s %s", iteratorType, seq)
+	}
+
+	tracker := checkpoint.NewCheckpointer(nil, "stream").Tracker("shard-0")
+	out := make(chan *source.Record, 10)
+	if !dispatch(context.Background(), "shard-0", records, done, tracker, out) {
+		t.Fatal("dispatch cancelled")
+	}
+	close(out)
+
+	var offsets []string
+	for r := range out {
+		offsets = append(offsets, r.Offset)
+		r.Ack()
+	}
+	want := []string{"100:1", "100:2", "101"}
+	if len(offsets) != len(want) {
+		t.Fatalf("expected offsets %v, got %v", want, offsets)
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Fatalf("expected offsets %v, got %v", want, offsets)
+		}
+	}
+	if pos := tracker.Position(); pos != "101" {
+		t.Errorf("expected position 101, got %s", pos)
+	}
+}
@@ -0,0 +1,161 @@
+package kinesis
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/source"
+)
+
+// readPolling continuously fetches records from a given shard.
+// It resumes after the last checkpointed sequence number (or from TRIM_HORIZON
+// for a new shard) and sends records to out. Calls are paced by a
+// pollScheduler: at most Polling.MaxReadsPerSecond per shard, larger and
+// more frequent while the shard is behind, with jittered backoff on
+// failures. An expired iterator is replaced by one positioned after the last
+// record read. When a closed shard has been read to the end it marks the
+// shard's tracker as ended so its children can be started once it drains.
+func (c *Consumer) readPolling(ctx context.Context, shardID string, out chan<- *source.Record) {
+	resumeFrom, err := c.cfg.Checkpointer.Resume(ctx, shardID)
+	if err != nil {
+		log.Printf("Error reading checkpoint for shard %s: %v", shardID, err)
+		return
+	}
+	if resumeFrom == checkpoint.ShardEnd {
+		log.Printf("Shard %s has already been fully processed", shardID)
+		return
+	}
+
+	iteratorType, seq, done := startingPosition(resumeFrom)
+	if seq != "" {
+		log.Printf("Resuming shard %s from checkpoint %s", shardID, resumeFrom)
+	}
+	tracker := c.cfg.Checkpointer.Tracker(shardID)
+	scheduler := newPollScheduler(c.cfg.Polling)
+
+	var iterator *string
+	for {
+		// Stop when the shard's lease is lost or the ingestor shuts down.
+		if ctx.Err() != nil {
+			return
+		}
+
+		if iterator == nil {
+			iterator, err = c.shardIterator(ctx, shardID, iteratorType, seq)
+			if err != nil {
+				log.Printf("Error getting shard iterator for shard %s: %v", shardID, err)
+				sleepContext(ctx, scheduler.failed())
+				continue
+			}
+		}
+
+		if err := scheduler.wait(ctx); err != nil {
+			return
+		}
+		recordsOutput, err := c.client.GetRecordsWithContext(ctx, &kinesis.GetRecordsInput{
+			ShardIterator: iterator,
+			Limit:         aws.Int64(scheduler.limit),
+		})
+		if err != nil {
+			countGetRecordsError(shardID, err)
+			switch errorCode(err) {
+			case kinesis.ErrCodeExpiredIteratorException:
+				// Iterators expire five minutes after they are issued, e.g.
+				// while out is blocked by backpressure. Get a new one from
+				// where this one left off.
+				log.Printf("Shard iterator for shard %s expired; re-acquiring", shardID)
+				iterator = nil
+			case kinesis.ErrCodeProvisionedThroughputExceededException:
+				sleepContext(ctx, scheduler.throttled())
+			default:
+				log.Printf("Error fetching records from shard %s: %v", shardID, err)
+				sleepContext(ctx, scheduler.failed())
+			}
+			continue
+		}
+		c.partitioned.MarkRead(shardID)
+		lag := time.Duration(aws.Int64Value(recordsOutput.MillisBehindLatest)) * time.Millisecond
+		metrics.ShardMillisBehindLatest.WithLabelValues(shardID).Set(float64(lag.Milliseconds()))
+
+		if !dispatch(ctx, shardID, recordsOutput.Records, done, tracker, out) {
+			return
+		}
+		if n := len(recordsOutput.Records); n > 0 {
+			// Every user record of the last record has been dispatched, so a
+			// replacement iterator starts after it.
+			iteratorType = kinesis.ShardIteratorTypeAfterSequenceNumber
+			seq = aws.StringValue(recordsOutput.Records[n-1].SequenceNumber)
+		}
+
+		// A nil iterator means the shard was closed by a split or merge and
+		// every record in it has been read.
+		if recordsOutput.NextShardIterator == nil {
+			log.Printf("Reached the end of closed shard %s", shardID)
+			tracker.End()
+			return
+		}
+		iterator = recordsOutput.NextShardIterator
+
+		sleepContext(ctx, scheduler.success(len(recordsOutput.Records), lag))
+	}
+}
+
+// shardIterator returns an iterator for shardID at the given position.
+func (c *Consumer) shardIterator(ctx context.Context, shardID, iteratorType, seq string) (*string, error) {
+	return shardIterator(ctx, c.client, c.streamName, shardID, iteratorType, seq, time.Time{})
+}
+
+// shardIterator returns an iterator for shardID of streamName starting at
+// sequence number seq or, for AT_TIMESTAMP, at time at.
+func shardIterator(ctx context.Context, client kinesisiface.KinesisAPI, streamName, shardID, iteratorType, seq string, at time.Time) (*string, error) {
+	input := &kinesis.GetShardIteratorInput{
+		StreamName:        aws.String(streamName),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(iteratorType),
+	}
+	if seq != "" {
+		input.StartingSequenceNumber = aws.String(seq)
+	}
+	if iteratorType == kinesis.ShardIteratorTypeAtTimestamp {
+		input.Timestamp = aws.Time(at)
+	}
+	output, err := client.GetShardIteratorWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return output.ShardIterator, nil
+}
+
+// errorCode returns the AWS error code of err, or "Unknown".
+func errorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return "Unknown"
+}
+
+// countGetRecordsError records a failed GetRecords call by error code.
+func countGetRecordsError(shardID string, err error) {
+	code := errorCode(err)
+	metrics.GetRecordsErrors.WithLabelValues(code).Inc()
+	if code == kinesis.ErrCodeProvisionedThroughputExceededException {
+		metrics.GetRecordsThrottled.WithLabelValues(shardID).Inc()
+	}
+}
+
+// sleepContext pauses for d or until ctx is cancelled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
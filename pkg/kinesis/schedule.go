@@ -0,0 +1,143 @@
+package kinesis
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// PollingConfig tunes how ModePolling reads each shard.
+type PollingConfig struct {
+	// MaxReadsPerSecond caps GetRecords calls per shard. Kinesis allows 5
+	// per shard shared by every polling consumer of the stream.
+	MaxReadsPerSecond float64
+	// MinLimit and MaxLimit bound the number of records requested per call.
+	// The limit grows while the shard is behind and shrinks when it is idle.
+	MinLimit, MaxLimit int64
+	// IdleInterval is the wait between polls of a shard that is caught up.
+	IdleInterval time.Duration
+	// LagThreshold is the MillisBehindLatest above which a shard is read as
+	// fast as MaxReadsPerSecond allows.
+	LagThreshold time.Duration
+}
+
+// withDefaults fills unset fields with values suited to a single consumer.
+func (c PollingConfig) withDefaults() PollingConfig {
+	if c.MaxReadsPerSecond <= 0 {
+		c.MaxReadsPerSecond = 5
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = 100
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 10000 // the GetRecords maximum
+	}
+	if c.MaxLimit < c.MinLimit {
+		c.MaxLimit = c.MinLimit
+	}
+	if c.IdleInterval <= 0 {
+		c.IdleInterval = time.Second
+	}
+	if c.LagThreshold <= 0 {
+		c.LagThreshold = 10 * time.Second
+	}
+	return c
+}
+
+// Backoff bounds. Throttling clears within a second or two, so it is
+// retried sooner than other failures.
+const (
+	throttleBaseBackoff = 250 * time.Millisecond
+	throttleMaxBackoff  = 10 * time.Second
+	errorBaseBackoff    = time.Second
+	errorMaxBackoff     = 30 * time.Second
+)
+
+// pollScheduler paces GetRecords calls for one shard. It keeps the shard
+// under its read limit, adapts the batch size and poll interval to the
+// shard's lag, and computes jittered backoff after failures.
+type pollScheduler struct {
+	cfg      PollingConfig
+	limiter  *rate.Limiter
+	limit    int64
+	failures int // consecutive failed calls
+	jitter   func(time.Duration) time.Duration
+}
+
+// newPollScheduler returns a scheduler for one shard.
+func newPollScheduler(cfg PollingConfig) *pollScheduler {
+	cfg = cfg.withDefaults()
+	return &pollScheduler{
+		cfg:     cfg,
+		limiter: rate.NewLimiter(rate.Limit(cfg.MaxReadsPerSecond), 1),
+		limit:   cfg.MinLimit,
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(d) + 1))
+		},
+	}
+}
+
+// wait blocks until the next GetRecords call is allowed by the read limit.
+func (s *pollScheduler) wait(ctx context.Context) error {
+	return s.limiter.Wait(ctx)
+}
+
+// success records a successful call that returned n records while the shard
+// was behind by lag, and returns how long to wait before polling again.
+func (s *pollScheduler) success(n int, lag time.Duration) time.Duration {
+	s.failures = 0
+	switch {
+	case int64(n) >= s.limit || lag > s.cfg.LagThreshold:
+		// Falling behind: ask for more per call and poll as fast as allowed.
+		s.limit *= 2
+		if s.limit > s.cfg.MaxLimit {
+			s.limit = s.cfg.MaxLimit
+		}
+		return 0
+	case n == 0:
+		// Caught up and idle: smaller calls, polled less often.
+		s.limit /= 2
+		if s.limit < s.cfg.MinLimit {
+			s.limit = s.cfg.MinLimit
+		}
+		return s.cfg.IdleInterval
+	default:
+		// Caught up with some traffic: poll sooner the further behind the
+		// shard is, so lag is worked off before it reaches the threshold.
+		return time.Duration(float64(s.cfg.IdleInterval) * (1 - float64(lag)/float64(s.cfg.LagThreshold)))
+	}
+}
+
+// throttled records a ProvisionedThroughputExceededException and returns the
+// backoff before the next call. Requesting fewer records per call also
+// lowers the bytes read, which is often what exceeded the limit.
+func (s *pollScheduler) throttled() time.Duration {
+	s.limit /= 2
+	if s.limit < s.cfg.MinLimit {
+		s.limit = s.cfg.MinLimit
+	}
+	return s.backoff(throttleBaseBackoff, throttleMaxBackoff)
+}
+
+// failed records any other failed call and returns the backoff before the
+// next one.
+func (s *pollScheduler) failed() time.Duration {
+	return s.backoff(errorBaseBackoff, errorMaxBackoff)
+}
+
+// backoff returns a "full jitter" exponential backoff: a random duration up
+// to base doubled once per consecutive failure, capped at max. The jitter
+// keeps replicas that were throttled together from retrying together.
+func (s *pollScheduler) backoff(base, max time.Duration) time.Duration {
+	d := base
+	for i := 0; i < s.failures && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	s.failures++
+	return s.jitter(d)
+}
@@ -0,0 +1,73 @@
+package kinesis
+
+import (
+	"testing"
+	"time"
+)
+
+// fullJitter makes backoff deterministic by always returning the upper bound.
+func fullJitter(d time.Duration) time.Duration { return d }
+
+func TestPollScheduler_AdaptsLimitToLag(t *testing.T) {
+	s := newPollScheduler(PollingConfig{MinLimit: 100, MaxLimit: 400, IdleInterval: time.Second, LagThreshold: 10 * time.Second})
+
+	// A full batch means the shard is behind: the limit grows and the next
+	// poll is immediate.
+	if wait := s.success(100, time.Second); wait != 0 || s.limit != 200 {
+		t.Fatalf("full batch: expected limit 200 and no wait, got %d and %v", s.limit, wait)
+	}
+	// Lag above the threshold does the same, up to MaxLimit.
+	s.success(10, time.Minute)
+	s.success(10, time.Minute)
+	if s.limit != 400 {
+		t.Fatalf("expected limit capped at 400, got %d", s.limit)
+	}
+
+	// Some records with little lag: the wait shrinks as lag grows.
+	if wait := s.success(10, 5*time.Second); wait != 500*time.Millisecond {
+		t.Errorf("expected a 500ms wait at half the lag threshold, got %v", wait)
+	}
+
+	// An empty batch on a caught-up shard shrinks the limit and waits the
+	// idle interval.
+	if wait := s.success(0, 0); wait != time.Second || s.limit != 200 {
+		t.Fatalf("idle: expected limit 200 and a 1s wait, got %d and %v", s.limit, wait)
+	}
+	s.success(0, 0)
+	s.success(0, 0)
+	if s.limit != 100 {
+		t.Errorf("expected limit floored at 100, got %d", s.limit)
+	}
+}
+
+func TestPollScheduler_BacksOffWithJitter(t *testing.T) {
+	s := newPollScheduler(PollingConfig{})
+	s.jitter = fullJitter
+
+	want := []time.Duration{250 * time.Millisecond, 500 * time.Millisecond, time.Second, 2 * time.Second}
+	for i, w := range want {
+		if got := s.throttled(); got != w {
+			t.Fatalf("throttle %d: expected %v, got %v", i+1, w, got)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		s.throttled()
+	}
+	if got := s.throttled(); got != throttleMaxBackoff {
+		t.Errorf("expected backoff capped at %v, got %v", throttleMaxBackoff, got)
+	}
+
+	// A success resets the backoff.
+	s.success(1, 0)
+	if got := s.failed(); got != errorBaseBackoff {
+		t.Errorf("expected backoff reset to %v after a success, got %v", errorBaseBackoff, got)
+	}
+
+	// The real jitter never exceeds the bound.
+	s = newPollScheduler(PollingConfig{})
+	for i := 0; i < 100; i++ {
+		if got := s.throttled(); got < 0 || got > throttleMaxBackoff {
+			t.Fatalf("jittered backoff %v out of range", got)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package kinesis
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go/service/kinesis/kinesisiface"
+)
+
+// ListShards returns every shard of the stream, following NextToken until
+// the listing is complete. Closed shards that are still within the retention
+// period are included so their lineage can be resolved.
+func ListShards(ctx context.Context, client kinesisiface.KinesisAPI, streamName string) ([]*kinesis.Shard, error) {
+	var shards []*kinesis.Shard
+	input := &kinesis.ListShardsInput{StreamName: aws.String(streamName)}
+	for {
+		output, err := client.ListShardsWithContext(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, output.Shards...)
+		if output.NextToken == nil {
+			return shards, nil
+		}
+		// StreamName must not be set together with NextToken.
+		input = &kinesis.ListShardsInput{NextToken: output.NextToken}
+	}
+}
+
+// ReadyShards returns the IDs of the shards that may be consumed now, in
+// shard ID order. A shard is ready when it is not finished itself and each of
+// its parents is either finished or no longer listed (trimmed after the
+// retention period). Holding back children until their parents are drained
+// keeps records for a partition key in order across splits and merges.
+func ReadyShards(shards []*kinesis.Shard, finished func(shardID string) bool) []string {
+	listed := make(map[string]bool, len(shards))
+	for _, s := range shards {
+		listed[aws.StringValue(s.ShardId)] = true
+	}
+	parentDone := func(parentID *string) bool {
+		id := aws.StringValue(parentID)
+		return id == "" || !listed[id] || finished(id)
+	}
+
+	var ready []string
+	for _, s := range shards {
+		id := aws.StringValue(s.ShardId)
+		if finished(id) {
+			continue
+		}
+		if parentDone(s.ParentShardId) && parentDone(s.AdjacentParentShardId) {
+			ready = append(ready, id)
+		}
+	}
+	sort.Strings(ready)
+	return ready
+}
@@ -0,0 +1,59 @@
+package kinesis
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+)
+
+func shard(id, parent, adjacent string) *kinesis.Shard {
+	s := &kinesis.Shard{ShardId: aws.String(id)}
+	if parent != "" {
+		s.ParentShardId = aws.String(parent)
+	}
+	if adjacent != "" {
+		s.AdjacentParentShardId = aws.String(adjacent)
+	}
+	return s
+}
+
+func TestReadyShards_HoldsChildrenUntilParentsFinish(t *testing.T) {
+	// s0 split into s1 and s2, which were later merged into s3.
+	shards := []*kinesis.Shard{
+		shard("s0", "", ""),
+		shard("s1", "s0", ""),
+		shard("s2", "s0", ""),
+		shard("s3", "s1", "s2"),
+	}
+	finished := map[string]bool{}
+	isFinished := func(id string) bool { return finished[id] }
+
+	if got := ReadyShards(shards, isFinished); !reflect.DeepEqual(got, []string{"s0"}) {
+		t.Errorf("expected only the root shard to be ready, got %v", got)
+	}
+
+	finished["s0"] = true
+	if got := ReadyShards(shards, isFinished); !reflect.DeepEqual(got, []string{"s1", "s2"}) {
+		t.Errorf("expected split children to be ready, got %v", got)
+	}
+
+	// A merge child needs both parents drained.
+	finished["s1"] = true
+	if got := ReadyShards(shards, isFinished); !reflect.DeepEqual(got, []string{"s2"}) {
+		t.Errorf("expected merge child to wait for s2, got %v", got)
+	}
+	finished["s2"] = true
+	if got := ReadyShards(shards, isFinished); !reflect.DeepEqual(got, []string{"s3"}) {
+		t.Errorf("expected merge child to be ready, got %v", got)
+	}
+}
+
+func TestReadyShards_TrimmedParentsCountAsFinished(t *testing.T) {
+	shards := []*kinesis.Shard{shard("s5", "s1", "s2")}
+	got := ReadyShards(shards, func(string) bool { return false })
+	if !reflect.DeepEqual(got, []string{"s5"}) {
+		t.Errorf("expected shard with trimmed parents to be ready, got %v", got)
+	}
+}
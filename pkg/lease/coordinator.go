@@ -0,0 +1,310 @@
+package lease
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Config controls how a Coordinator claims and renews leases.
+type Config struct {
+	// WorkerID uniquely identifies this replica (e.g. the pod name).
+	WorkerID string
+	// LeaseDuration is how long a lease may go without a heartbeat before
+	// other workers treat it as expired and take it over.
+	LeaseDuration time.Duration
+	// RenewInterval is how often leases are renewed and rebalanced.
+	// Defaults to a third of LeaseDuration.
+	RenewInterval time.Duration
+	// OnAcquire is called when this worker starts owning a shard.
+	// Callbacks run synchronously and must not call back into the Coordinator.
+	OnAcquire func(shardID string)
+	// OnLose is called when this worker stops owning a shard, either because
+	// another worker took it or because renewals kept failing.
+	OnLose func(shardID string)
+}
+
+// Coordinator claims an even share of the stream's shards for one worker.
+// Every RenewInterval it heartbeats the leases it owns, takes over free or
+// expired leases, and steals from the most loaded worker until every active
+// worker owns roughly len(shards)/len(workers) shards.
+type Coordinator struct {
+	store Store
+	cfg   Config
+	now   func() time.Time
+
+	mu       sync.Mutex
+	owned    map[string]*ownedLease
+	observed map[string]observation
+}
+
+// ownedLease is a lease this worker holds and the last time it was renewed.
+type ownedLease struct {
+	lease   Lease
+	renewed time.Time
+}
+
+// observation is the last counter seen for a lease and when it changed.
+// A lease whose counter stops moving for LeaseDuration is expired.
+type observation struct {
+	counter int64
+	changed time.Time
+}
+
+// NewCoordinator returns a Coordinator that manages leases in store.
+func NewCoordinator(store Store, cfg Config) *Coordinator {
+	if cfg.LeaseDuration <= 0 {
+		cfg.LeaseDuration = 30 * time.Second
+	}
+	if cfg.RenewInterval <= 0 {
+		cfg.RenewInterval = cfg.LeaseDuration / 3
+	}
+	return &Coordinator{
+		store:    store,
+		cfg:      cfg,
+		now:      time.Now,
+		owned:    make(map[string]*ownedLease),
+		observed: make(map[string]observation),
+	}
+}
+
+// SyncShards makes sure a lease exists for every shard in shardIDs.
+func (c *Coordinator) SyncShards(ctx context.Context, shardIDs []string) error {
+	for _, id := range shardIDs {
+		if err := c.store.Create(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveShards deletes the leases of shards that no longer need consuming,
+// such as closed shards that have been read to the end. A worker holding one
+// of these leases gives it up on its next renewal.
+func (c *Coordinator) RemoveShards(ctx context.Context, shardIDs []string) error {
+	for _, id := range shardIDs {
+		if err := c.store.Delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Owned returns the shard IDs this worker currently holds.
+func (c *Coordinator) Owned() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ids := make([]string, 0, len(c.owned))
+	for id := range c.owned {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Run renews and rebalances leases until ctx is cancelled.
+func (c *Coordinator) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.RenewInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.Tick(ctx); err != nil {
+			log.Printf("Lease coordination error: %v", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Hold renews the leases this worker owns, without taking any more, until
+// ctx is cancelled. It keeps leases alive between the end of Run and
+// ReleaseAll, while a shutting-down worker drains and checkpoints.
+func (c *Coordinator) Hold(ctx context.Context) {
+	ticker := time.NewTicker(c.cfg.RenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.renew(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Tick performs a single renew-and-rebalance round.
+func (c *Coordinator) Tick(ctx context.Context) error {
+	c.renew(ctx)
+
+	leases, err := c.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Track counter changes so expiry is judged on our own clock.
+	listed := make(map[string]bool, len(leases))
+	for _, l := range leases {
+		listed[l.ShardID] = true
+		obs, ok := c.observed[l.ShardID]
+		if !ok || obs.counter != l.Counter {
+			c.observed[l.ShardID] = observation{counter: l.Counter, changed: now}
+		}
+	}
+	for id := range c.observed {
+		if !listed[id] {
+			delete(c.observed, id)
+		}
+	}
+
+	// Count live leases per worker.
+	load := map[string]int{c.cfg.WorkerID: len(c.owned)}
+	var available []Lease
+	for _, l := range leases {
+		if _, mine := c.owned[l.ShardID]; mine {
+			continue
+		}
+		if c.expired(l, now) {
+			available = append(available, l)
+			continue
+		}
+		load[l.Owner]++
+	}
+	if len(leases) == 0 {
+		return nil
+	}
+
+	// Each active worker should own ceil(shards/workers) leases at most.
+	target := (len(leases) + len(load) - 1) / len(load)
+	need := target - len(c.owned)
+	if need <= 0 {
+		return nil
+	}
+
+	// Prefer free and expired leases, in random order to spread contention.
+	rand.Shuffle(len(available), func(i, j int) { available[i], available[j] = available[j], available[i] })
+	for _, l := range available {
+		if need == 0 {
+			return nil
+		}
+		if c.take(ctx, l, now) {
+			need--
+		}
+	}
+	if need == 0 {
+		return nil
+	}
+
+	// Steal a single lease per round from the most loaded worker, but only if
+	// that evens the load: the victim holds more than floor(shards/workers)
+	// while this worker holds less, or at least two more than this worker.
+	// Either way the move leaves no worker further from its share, so
+	// leases do not ping-pong.
+	share, mine := len(leases)/len(load), len(c.owned)
+	victim, most := "", 0
+	for owner, n := range load {
+		if owner == c.cfg.WorkerID || n <= most {
+			continue
+		}
+		if (n > share && mine < share) || n >= mine+2 {
+			victim, most = owner, n
+		}
+	}
+	if victim == "" {
+		return nil
+	}
+	for _, l := range leases {
+		if l.Owner == victim && !c.expired(l, now) {
+			c.take(ctx, l, now)
+			break
+		}
+	}
+	return nil
+}
+
+// expired reports whether l is free or its owner has stopped heartbeating.
+// The caller must hold c.mu.
+func (c *Coordinator) expired(l Lease, now time.Time) bool {
+	if l.Owner == "" {
+		return true
+	}
+	obs, ok := c.observed[l.ShardID]
+	return ok && now.Sub(obs.changed) > c.cfg.LeaseDuration
+}
+
+// take claims l for this worker. The caller must hold c.mu.
+func (c *Coordinator) take(ctx context.Context, l Lease, now time.Time) bool {
+	next := Lease{ShardID: l.ShardID, Owner: c.cfg.WorkerID, Counter: l.Counter + 1}
+	ok, err := c.store.Update(ctx, next, l.Counter)
+	if err != nil {
+		log.Printf("Error taking lease for shard %s: %v", l.ShardID, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if l.Owner != "" {
+		log.Printf("Worker %s took lease for shard %s from %s", c.cfg.WorkerID, l.ShardID, l.Owner)
+	} else {
+		log.Printf("Worker %s acquired lease for shard %s", c.cfg.WorkerID, l.ShardID)
+	}
+	c.owned[l.ShardID] = &ownedLease{lease: next, renewed: now}
+	c.observed[l.ShardID] = observation{counter: next.Counter, changed: now}
+	if c.cfg.OnAcquire != nil {
+		c.cfg.OnAcquire(l.ShardID)
+	}
+	return true
+}
+
+// renew heartbeats every owned lease and drops the ones that were lost.
+func (c *Coordinator) renew(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for id, o := range c.owned {
+		next := o.lease
+		next.Counter++
+		ok, err := c.store.Update(ctx, next, o.lease.Counter)
+		switch {
+		case err != nil:
+			// Keep the lease through transient store errors, but give it up
+			// once another worker could legitimately consider it expired.
+			log.Printf("Error renewing lease for shard %s: %v", id, err)
+			if now.Sub(o.renewed) <= c.cfg.LeaseDuration {
+				continue
+			}
+		case ok:
+			o.lease = next
+			o.renewed = now
+			continue
+		}
+		log.Printf("Worker %s lost lease for shard %s", c.cfg.WorkerID, id)
+		delete(c.owned, id)
+		if c.cfg.OnLose != nil {
+			c.cfg.OnLose(id)
+		}
+	}
+}
+
+// ReleaseAll gives up every owned lease so other workers can take them
+// immediately instead of waiting for expiry. It is meant for shutdown.
+func (c *Coordinator) ReleaseAll(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, o := range c.owned {
+		next := Lease{ShardID: id, Counter: o.lease.Counter + 1}
+		if _, err := c.store.Update(ctx, next, o.lease.Counter); err != nil {
+			log.Printf("Error releasing lease for shard %s: %v", id, err)
+		}
+		delete(c.owned, id)
+		if c.cfg.OnLose != nil {
+			c.cfg.OnLose(id)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fakeClock lets tests move time forward without sleeping.
+type fakeClock struct{ t time.Time }
+
+func (f *fakeClock) now() time.Time { return f.t }
+
+func newTestCoordinator(store Store, workerID string, clock *fakeClock) *Coordinator {
+	c := NewCoordinator(store, Config{WorkerID: workerID, LeaseDuration: 30 * time.Second})
+	c.now = clock.now
+	return c
+}
+
+func TestCoordinator_BalancesShardsAcrossWorkers(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	clock := &fakeClock{t: time.Unix(0, 0)}
+
+	a := newTestCoordinator(store, "a", clock)
+	b := newTestCoordinator(store, "b", clock)
+	if err := a.SyncShards(ctx, []string{"s0", "s1", "s2", "s3"}); err != nil {
+		t.Fatalf("sync shards: %v", err)
+	}
+
+	// A starts alone and claims every shard.
+	a.Tick(ctx)
+	if n := len(a.Owned()); n != 4 {
+		t.Fatalf("expected a to own 4 shards, got %d", n)
+	}
+
+	// B joins and steals one lease per round until the load is even.
+	for i := 0; i < 4; i++ {
+		clock.t = clock.t.Add(10 * time.Second)
+		a.Tick(ctx)
+		b.Tick(ctx)
+	}
+	if na, nb := len(a.Owned()), len(b.Owned()); na != 2 || nb != 2 {
+		t.Errorf("expected 2/2 split, got a=%d b=%d", na, nb)
+	}
+}
+
+// settle ticks workers until their leases stop moving, and returns how
+// many shards each owns.
+func settle(t *testing.T, clock *fakeClock, workers ...*Coordinator) []int {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		clock.t = clock.t.Add(10 * time.Second)
+		for _, w := range workers {
+			w.Tick(ctx)
+		}
+	}
+	counts := make([]int, len(workers))
+	for i, w := range workers {
+		counts[i] = len(w.Owned())
+	}
+	return counts
+}
+
+func TestCoordinator_ThirdWorkerGetsItsShare(t *testing.T) {
+	tests := []struct {
+		shards   int
+		min, max int
+	}{
+		{4, 1, 2},
+		{10, 3, 4},
+	}
+	for _, tt := range tests {
+		ctx := context.Background()
+		store := NewMemoryStore()
+		clock := &fakeClock{t: time.Unix(0, 0)}
+		var ids []string
+		for i := 0; i < tt.shards; i++ {
+			ids = append(ids, fmt.Sprintf("s%d", i))
+		}
+		a := newTestCoordinator(store, "a", clock)
+		b := newTestCoordinator(store, "b", clock)
+		c := newTestCoordinator(store, "c", clock)
+		a.SyncShards(ctx, ids)
+
+		// A and B split the shards before C joins.
+		settle(t, clock, a, b)
+		counts := settle(t, clock, a, b, c)
+		total := 0
+		for _, n := range counts {
+			total += n
+			if n < tt.min || n > tt.max {
+				t.Errorf("%d shards: counts = %v, want each between %d and %d", tt.shards, counts, tt.min, tt.max)
+				break
+			}
+		}
+		if total != tt.shards {
+			t.Errorf("%d shards: counts = %v, want every shard owned once", tt.shards, counts)
+		}
+	}
+}
+
+func TestCoordinator_HoldRenewsWithoutTakingMore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	clock := &fakeClock{t: time.Unix(0, 0)}
+
+	a := NewCoordinator(store, Config{WorkerID: "a", RenewInterval: time.Millisecond})
+	a.now = clock.now
+	a.SyncShards(ctx, []string{"s0"})
+	a.Tick(ctx)
+	a.SyncShards(ctx, []string{"s1"})
+
+	holdCtx, cancel := context.WithCancel(ctx)
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		a.Hold(holdCtx)
+	}()
+	counter := func() int64 {
+		leases, _ := store.List(ctx)
+		for _, l := range leases {
+			if l.ShardID == "s0" {
+				return l.Counter
+			}
+		}
+		return 0
+	}
+	start := counter()
+	deadline := time.Now().Add(5 * time.Second)
+	for counter() < start+2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-held
+
+	if counter() < start+2 {
+		t.Errorf("s0's counter went from %d to %d, want it renewed", start, counter())
+	}
+	if owned := a.Owned(); len(owned) != 1 || owned[0] != "s0" {
+		t.Errorf("owned = %v, want only s0", owned)
+	}
+}
+
+func TestCoordinator_TakesOverFromCrashedWorker(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	clock := &fakeClock{t: time.Unix(0, 0)}
+
+	a := newTestCoordinator(store, "a", clock)
+	b := newTestCoordinator(store, "b", clock)
+	a.SyncShards(ctx, []string{"s0", "s1"})
+
+	var lost []string
+	a.cfg.OnLose = func(shardID string) { lost = append(lost, shardID) }
+
+	a.Tick(ctx)
+	b.Tick(ctx)
+	if n := len(a.Owned()); n != 2 {
+		t.Fatalf("expected a to own 2 shards, got %d", n)
+	}
+
+	// A stops heartbeating; B must wait for expiry before taking over.
+	clock.t = clock.t.Add(20 * time.Second)
+	b.Tick(ctx)
+	if n := len(b.Owned()); n != 1 {
+		t.Fatalf("expected b to steal only its share before expiry, got %d", n)
+	}
+	clock.t = clock.t.Add(31 * time.Second)
+	b.Tick(ctx)
+	if n := len(b.Owned()); n != 2 {
+		t.Errorf("expected b to own both shards after expiry, got %d", n)
+	}
+
+	// When A comes back its renewals fail and it gives the shards up.
+	a.Tick(ctx)
+	if len(lost) != 2 {
+		t.Errorf("expected a to lose 2 leases, got %v", lost)
+	}
+}
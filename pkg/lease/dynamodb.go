@@ -0,0 +1,115 @@
+package lease
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// DynamoDBStore keeps leases in a DynamoDB-compatible table with a string
+// partition key "stream_name" and a string sort key "shard_id".
+type DynamoDBStore struct {
+	client     dynamodbiface.DynamoDBAPI
+	tableName  string
+	streamName string
+}
+
+// NewDynamoDBStore returns a DynamoDBStore for the leases of streamName.
+func NewDynamoDBStore(client dynamodbiface.DynamoDBAPI, tableName, streamName string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, tableName: tableName, streamName: streamName}
+}
+
+// List returns every lease of the stream.
+func (s *DynamoDBStore) List(ctx context.Context) ([]Lease, error) {
+	var leases []Lease
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.tableName),
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("stream_name = :stream"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":stream": {S: aws.String(s.streamName)},
+		},
+	}
+	err := s.client.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			l := Lease{ShardID: aws.StringValue(item["shard_id"].S)}
+			if owner, ok := item["owner"]; ok {
+				l.Owner = aws.StringValue(owner.S)
+			}
+			if counter, ok := item["lease_counter"]; ok {
+				l.Counter, _ = strconv.ParseInt(aws.StringValue(counter.N), 10, 64)
+			}
+			leases = append(leases, l)
+		}
+		return true
+	})
+	return leases, err
+}
+
+// Create inserts a free lease for shardID unless one already exists.
+func (s *DynamoDBStore) Create(ctx context.Context, shardID string) error {
+	_, err := s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.tableName),
+		Item: map[string]*dynamodb.AttributeValue{
+			"stream_name":   {S: aws.String(s.streamName)},
+			"shard_id":      {S: aws.String(shardID)},
+			"owner":         {S: aws.String("")},
+			"lease_counter": {N: aws.String("0")},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(shard_id)"),
+	})
+	if isConditionFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// Update writes l if the stored counter matches expectedCounter.
+func (s *DynamoDBStore) Update(ctx context.Context, l Lease, expectedCounter int64) (bool, error) {
+	_, err := s.client.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"stream_name": {S: aws.String(s.streamName)},
+			"shard_id":    {S: aws.String(l.ShardID)},
+		},
+		UpdateExpression:    aws.String("SET #owner = :owner, lease_counter = :counter"),
+		ConditionExpression: aws.String("lease_counter = :expected"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner":    {S: aws.String(l.Owner)},
+			":counter":  {N: aws.String(strconv.FormatInt(l.Counter, 10))},
+			":expected": {N: aws.String(strconv.FormatInt(expectedCounter, 10))},
+		},
+	})
+	if isConditionFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete removes the lease for shardID.
+func (s *DynamoDBStore) Delete(ctx context.Context, shardID string) error {
+	_, err := s.client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.tableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"stream_name": {S: aws.String(s.streamName)},
+			"shard_id":    {S: aws.String(shardID)},
+		},
+	})
+	return err
+}
+
+// isConditionFailed reports whether err is a failed conditional write.
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,79 @@
+package lease
+
+import (
+	"context"
+	"database/sql"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresStore keeps leases in the shard_leases table
+// (see migration/003_create_shard_leases_table.sql).
+type PostgresStore struct {
+	db         *sql.DB
+	streamName string
+}
+
+// NewPostgresStore returns a PostgresStore for the leases of streamName.
+func NewPostgresStore(db *sql.DB, streamName string) *PostgresStore {
+	return &PostgresStore{db: db, streamName: streamName}
+}
+
+// List returns every lease of the stream.
+func (s *PostgresStore) List(ctx context.Context) ([]Lease, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT shard_id, owner, lease_counter FROM shard_leases WHERE stream_name = $1 ORDER BY shard_id`,
+		s.streamName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var leases []Lease
+	for rows.Next() {
+		var l Lease
+		if err := rows.Scan(&l.ShardID, &l.Owner, &l.Counter); err != nil {
+			return nil, err
+		}
+		leases = append(leases, l)
+	}
+	return leases, rows.Err()
+}
+
+// Create inserts a free lease for shardID unless one already exists.
+func (s *PostgresStore) Create(ctx context.Context, shardID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO shard_leases (stream_name, shard_id, owner, lease_counter, updated_at)
+		 VALUES ($1, $2, '', 0, now())
+		 ON CONFLICT (stream_name, shard_id) DO NOTHING`,
+		s.streamName, shardID,
+	)
+	return err
+}
+
+// Update writes l if the stored counter matches expectedCounter.
+func (s *PostgresStore) Update(ctx context.Context, l Lease, expectedCounter int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE shard_leases SET owner = $1, lease_counter = $2, updated_at = now()
+		 WHERE stream_name = $3 AND shard_id = $4 AND lease_counter = $5`,
+		l.Owner, l.Counter, s.streamName, l.ShardID, expectedCounter,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Delete removes the lease for shardID.
+func (s *PostgresStore) Delete(ctx context.Context, shardID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM shard_leases WHERE stream_name = $1 AND shard_id = $2`,
+		s.streamName, shardID,
+	)
+	return err
+}
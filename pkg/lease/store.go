@@ -0,0 +1,84 @@
+package lease
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Lease records which worker currently owns a shard.
+// Counter is incremented on every heartbeat and ownership change; it is the
+// only value used to detect expiry, so workers never compare wall clocks.
+type Lease struct {
+	ShardID string
+	Owner   string // empty when the lease is free
+	Counter int64
+}
+
+// Store is the shared lease table for one stream.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// List returns every lease of the stream.
+	List(ctx context.Context) ([]Lease, error)
+	// Create inserts a free lease for shardID unless one already exists.
+	Create(ctx context.Context, shardID string) error
+	// Update writes l only if the stored counter still equals expectedCounter.
+	// It reports false when another worker changed the lease first.
+	Update(ctx context.Context, l Lease, expectedCounter int64) (bool, error)
+	// Delete removes the lease for shardID. Deleting a missing lease is not an error.
+	Delete(ctx context.Context, shardID string) error
+}
+
+// MemoryStore is an in-process Store. It only coordinates workers within a
+// single process and is meant for tests and single-replica deployments.
+type MemoryStore struct {
+	mu     sync.Mutex
+	leases map[string]Lease
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{leases: make(map[string]Lease)}
+}
+
+// List returns every lease sorted by shard ID.
+func (s *MemoryStore) List(ctx context.Context) ([]Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leases := make([]Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		leases = append(leases, l)
+	}
+	sort.Slice(leases, func(i, j int) bool { return leases[i].ShardID < leases[j].ShardID })
+	return leases, nil
+}
+
+// Create inserts a free lease for shardID unless one already exists.
+func (s *MemoryStore) Create(ctx context.Context, shardID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.leases[shardID]; !ok {
+		s.leases[shardID] = Lease{ShardID: shardID}
+	}
+	return nil
+}
+
+// Update writes l if the stored counter matches expectedCounter.
+func (s *MemoryStore) Update(ctx context.Context, l Lease, expectedCounter int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.leases[l.ShardID]
+	if !ok || cur.Counter != expectedCounter {
+		return false, nil
+	}
+	s.leases[l.ShardID] = l
+	return true, nil
+}
+
+// Delete removes the lease for shardID.
+func (s *MemoryStore) Delete(ctx context.Context, shardID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, shardID)
+	return nil
+}
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Ingestion metrics shared by the ingestor's packages. Dashboards and alerts
+// depend on these names and labels, so they must not be renamed; add new
+// metrics instead.
+var (
+	// ShardMillisBehindLatest reports how far each shard's reader is behind
+	// the tip of the stream, as returned by Kinesis.
+	ShardMillisBehindLatest = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kinesis_shard_millis_behind_latest",
+		Help: "Milliseconds the shard's reader is behind the tip of the stream",
+	}, []string{"shard"})
+
+	// GetRecordsErrors counts failed GetRecords calls by AWS error code.
+	GetRecordsErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kinesis_get_records_errors_total",
+		Help: "Failed GetRecords calls by error code",
+	}, []string{"code"})
+
+	// GetRecordsThrottled counts GetRecords calls rejected with
+	// ProvisionedThroughputExceededException, per shard.
+	GetRecordsThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kinesis_get_records_throttled_total",
+		Help: "GetRecords calls throttled by Kinesis, per shard",
+	}, []string{"shard"})
+
+	// KPLAggregatedRecords counts KPL aggregated records that were unpacked
+	// into their user records.
+	KPLAggregatedRecords = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kinesis_kpl_aggregated_records_total",
+		Help: "KPL aggregated records unpacked into user records",
+	})
+
+	// KPLErrors counts KPL aggregated records that could not be unpacked,
+	// such as those failing the MD5 check.
+	KPLErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "kinesis_kpl_deaggregation_errors_total",
+		Help: "KPL aggregated records that failed the checksum or could not be decoded",
+	})
+
+	// ProcessingLatency measures the time from a record's arrival in the
+	// stream to the end of its processing.
+	ProcessingLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "record_processing_latency_seconds",
+		Help:    "Time from record arrival in the stream to processing completion",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300},
+	})
+
+	// ProcessingFailures counts records that failed processing, by cause.
+	ProcessingFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "record_processing_failures_total",
+		Help: "Records that failed processing, by cause (decode, validate, sink)",
+	}, []string{"cause"})
+
+	// StageDuration measures the time each pipeline stage takes per record.
+	StageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pipeline_stage_duration_seconds",
+		Help:    "Time each pipeline stage spent on a record",
+		Buckets: []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5},
+	}, []string{"stage"})
+
+	// StageOutcomes counts records leaving each pipeline stage, by outcome:
+	// "ok", or the error policy applied to a failure ("drop", "dlq", "fail").
+	StageOutcomes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pipeline_stage_records_total",
+		Help: "Records processed by each pipeline stage, by outcome (ok, drop, dlq, fail)",
+	}, []string{"stage", "outcome"})
+
+	// Duplicates counts readings recognised as duplicates, by where they were
+	// caught: "cache" (recently stored, dropped before the sink), "batch"
+	// (repeated within one batch) or "database" (already stored with the
+	// same value).
+	Duplicates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ingest_duplicates_total",
+		Help: "Duplicate readings suppressed, by layer (cache, batch, database)",
+	}, []string{"layer"})
+
+	// AnomaliesDetected counts readings flagged by the detect stage, by
+	// detector type.
+	AnomaliesDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "anomalies_detected_total",
+		Help: "Readings flagged as anomalies, by detector (threshold, zscore, ewma, mad)",
+	}, []string{"detector"})
+
+	// LateReadings counts readings that arrived after the windows they
+	// belong to had closed, or after a newer reading from their device, by
+	// stage.
+	LateReadings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pipeline_late_readings_total",
+		Help: "Readings that arrived after their window closed or a newer reading, by stage",
+	}, []string{"stage"})
+
+	// Rollups counts window rollups written by the aggregate stage, by
+	// window and result ("ok" or "failed").
+	Rollups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aggregate_rollups_total",
+		Help: "Window rollups written by the aggregate stage, by window and result (ok, failed)",
+	}, []string{"window", "result"})
+
+	// AlertTransitions counts alerts entering each state ("pending",
+	// "firing" or "resolved"), by rule.
+	AlertTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_transitions_total",
+		Help: "Alerts entering a state (pending, firing, resolved), by rule",
+	}, []string{"rule", "state"})
+
+	// AlertNotifications counts alert notifications sent, by result ("ok"
+	// or "failed").
+	AlertNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_notifications_total",
+		Help: "Alert notifications sent, by result (ok, failed)",
+	}, []string{"result"})
+
+	// RegistryLookups counts device registry lookups by the enrich stage's
+	// cache, by result: "hit", "negative_hit" (cached as missing), "miss"
+	// or "refresh" (reloaded ahead of expiry).
+	RegistryLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "registry_cache_lookups_total",
+		Help: "Device registry cache lookups, by result (hit, negative_hit, miss, refresh)",
+	}, []string{"result"})
+
+	// UnknownDevices counts readings from devices missing from the registry,
+	// by the policy applied ("tag", "quarantine" or "reject").
+	UnknownDevices = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "enrich_unknown_device_readings_total",
+		Help: "Readings from devices missing from the registry, by policy (tag, quarantine, reject)",
+	}, []string{"policy"})
+
+	// QuarantinedReadings counts readings moved to quarantine, by stage and
+	// rule.
+	QuarantinedReadings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "quarantined_readings_total",
+		Help: "Readings moved to quarantine, by stage and rule",
+	}, []string{"stage", "rule"})
+
+	// Transformations counts what the transform stage did to readings, by
+	// action: "calibrated", "converted" (to the canonical unit) or
+	// "unknown_unit" (left as sent).
+	Transformations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "transform_readings_total",
+		Help: "Readings changed by the transform stage, by action (calibrated, converted, unknown_unit)",
+	}, []string{"action"})
+
+	// ValidationFailures counts the rules broken by invalid readings, by
+	// rule ("required", "device_id", "value_range" or "clock_skew").
+	ValidationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "validation_failures_total",
+		Help: "Rules broken by invalid readings, by rule (required, device_id, value_range, clock_skew)",
+	}, []string{"rule"})
+
+	// TooLateReadings counts readings behind the watermark by more than
+	// the allowed lateness, by the policy applied ("drop", "side-output" or
+	// "update").
+	TooLateReadings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pipeline_too_late_readings_total",
+		Help: "Readings more than the allowed lateness behind the watermark, by policy (drop, side-output, update)",
+	}, []string{"policy"})
+
+	// Watermark reports the pipeline's event-time watermark, in Unix
+	// seconds.
+	Watermark = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "pipeline_watermark_seconds",
+		Help: "Event time up to which readings are expected to have arrived, in Unix seconds",
+	})
+)
+
+// init registers the ingestion metrics.
+func init() {
+	prometheus.MustRegister(
+		ShardMillisBehindLatest,
+		GetRecordsErrors,
+		GetRecordsThrottled,
+		KPLAggregatedRecords,
+		KPLErrors,
+		ProcessingLatency,
+		ProcessingFailures,
+		Duplicates,
+		StageDuration,
+		StageOutcomes,
+		AnomaliesDetected,
+		LateReadings,
+		Rollups,
+		AlertTransitions,
+		AlertNotifications,
+		RegistryLookups,
+		UnknownDevices,
+		QuarantinedReadings,
+		Transformations,
+		ValidationFailures,
+		TooLateReadings,
+		Watermark,
+	)
+}
@@ -0,0 +1,150 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"iot-insighthub/pkg/source"
+)
+
+// Handler processes a single record on the given worker.
+type Handler func(workerID int, record *source.Record)
+
+// Pool processes records on a fixed set of workers, each with its own queue.
+// Records with the same routing key always land on the same worker, so
+// readings from one device are processed in the order they were read while
+// different devices are still spread across every worker.
+type Pool struct {
+	queues []chan *source.Record
+	handle Handler
+	busy   []int64 // nanoseconds spent in handle, per worker; accessed atomically
+	active []int64 // UnixNano when the current record was started, or 0; accessed atomically
+	wg     sync.WaitGroup
+}
+
+// New starts a Pool with the given number of workers, each buffering up to
+// queueSize records.
+func New(workers, queueSize int, handle Handler) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Pool{
+		queues: make([]chan *source.Record, workers),
+		handle: handle,
+		busy:   make([]int64, workers),
+		active: make([]int64, workers),
+	}
+	for i := range p.queues {
+		p.queues[i] = make(chan *source.Record, queueSize)
+		p.wg.Add(1)
+		go p.work(i)
+	}
+	return p
+}
+
+// work drains the queue of a single worker.
+func (p *Pool) work(workerID int) {
+	defer p.wg.Done()
+	for record := range p.queues[workerID] {
+		start := time.Now()
+		atomic.StoreInt64(&p.active[workerID], start.UnixNano())
+		p.handle(workerID, record)
+		atomic.StoreInt64(&p.active[workerID], 0)
+		atomic.AddInt64(&p.busy[workerID], int64(time.Since(start)))
+	}
+}
+
+// Submit queues record on the worker that owns its routing key. It blocks
+// while that worker's queue is full, which applies backpressure to the
+// source, and returns ctx.Err() if ctx is cancelled first.
+func (p *Pool) Submit(ctx context.Context, record *source.Record) error {
+	q := p.queues[p.route(RoutingKey(record))]
+	select {
+	case q <- record:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Run submits every record received on in until in is closed or ctx is cancelled.
+func (p *Pool) Run(ctx context.Context, in <-chan *source.Record) {
+	for {
+		select {
+		case record, ok := <-in:
+			if !ok {
+				return
+			}
+			if err := p.Submit(ctx, record); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops accepting records and waits for the workers to finish the
+// records already queued. Submit must not be called after Close.
+func (p *Pool) Close() {
+	for _, q := range p.queues {
+		close(q)
+	}
+	p.wg.Wait()
+}
+
+// Workers returns the number of workers in the pool.
+func (p *Pool) Workers() int {
+	return len(p.queues)
+}
+
+// Busy returns the total time each worker has spent handling records.
+// Sampling it periodically gives each worker's busy ratio.
+func (p *Pool) Busy() []time.Duration {
+	busy := make([]time.Duration, len(p.busy))
+	for i := range p.busy {
+		busy[i] = time.Duration(atomic.LoadInt64(&p.busy[i]))
+	}
+	return busy
+}
+
+// Active returns how long each worker has been handling its current
+// record, or zero for idle workers. A worker that stays active for long is
+// stuck, for example behind a sink that no longer accepts batches.
+func (p *Pool) Active() []time.Duration {
+	now := time.Now().UnixNano()
+	active := make([]time.Duration, len(p.active))
+	for i := range p.active {
+		if start := atomic.LoadInt64(&p.active[i]); start != 0 {
+			active[i] = time.Duration(now - start)
+		}
+	}
+	return active
+}
+
+// route maps a routing key to a worker index.
+func (p *Pool) route(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// RoutingKey returns the key that determines a record's worker: the
+// partition key if the transport provides one, otherwise the payload's
+// device_id, otherwise the record's partition.
+func RoutingKey(record *source.Record) string {
+	if record.Key != "" {
+		return record.Key
+	}
+	var payload struct {
+		DeviceID string `json:"device_id"`
+	}
+	if err := json.Unmarshal(record.Data, &payload); err == nil && payload.DeviceID != "" {
+		return payload.DeviceID
+	}
+	return record.Partition
+}
@@ -0,0 +1,78 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/source"
+)
+
+func TestPool_PreservesOrderPerDevice(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+
+	p := New(4, 10, func(workerID int, r *source.Record) {
+		// Uneven processing times would reorder records on a shared queue.
+		n, _ := strconv.Atoi(r.Offset)
+		time.Sleep(time.Duration(n%3) * time.Microsecond)
+		mu.Lock()
+		seen[r.Key] = append(seen[r.Key], n)
+		mu.Unlock()
+	})
+
+	ctx := context.Background()
+	for i := 0; i < 300; i++ {
+		device := fmt.Sprintf("device-%d", i%7)
+		r := source.NewRecord(device, nil, "shard-0", strconv.Itoa(i), time.Time{}, nil)
+		if err := p.Submit(ctx, r); err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+	}
+	p.Close()
+
+	for device, offsets := range seen {
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] < offsets[i-1] {
+				t.Fatalf("records for %s processed out of order: %v", device, offsets)
+			}
+		}
+	}
+	if len(seen) != 7 {
+		t.Errorf("expected 7 devices, got %d", len(seen))
+	}
+}
+
+func TestRoutingKey_FallsBackToDeviceID(t *testing.T) {
+	r := source.NewRecord("", []byte(`{"device_id":"dev-9","value":1}`), "shard-0", "1", time.Time{}, nil)
+	if key := RoutingKey(r); key != "dev-9" {
+		t.Errorf("expected device_id routing key, got %q", key)
+	}
+
+	r = source.NewRecord("", []byte(`not json`), "shard-0", "1", time.Time{}, nil)
+	if key := RoutingKey(r); key != "shard-0" {
+		t.Errorf("expected partition routing key, got %q", key)
+	}
+}
+
+func TestPool_BusyTime(t *testing.T) {
+	p := New(2, 10, func(workerID int, r *source.Record) {
+		time.Sleep(5 * time.Millisecond)
+	})
+	r := source.NewRecord("device-1", nil, "shard-0", "1", time.Time{}, nil)
+	if err := p.Submit(context.Background(), r); err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	p.Close()
+
+	var total time.Duration
+	for _, d := range p.Busy() {
+		total += d
+	}
+	if total < 5*time.Millisecond {
+		t.Errorf("expected at least 5ms of busy time, got %s", total)
+	}
+}
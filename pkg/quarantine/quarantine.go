@@ -0,0 +1,79 @@
+package quarantine
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"iot-insighthub/pkg/api"
+)
+
+// Entry is a reading held back from storage, with the stage and rule that
+// caught it and a reason for the operator reviewing it.
+type Entry struct {
+	api.TelemetryData
+	Stage         string    `json:"stage"`
+	Rule          string    `json:"rule"`
+	Reason        string    `json:"reason"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// Writer stores quarantined readings. Implementations must be safe for
+// concurrent use.
+type Writer interface {
+	Write(ctx context.Context, entries []Entry) error
+}
+
+// PostgresWriter writes entries to the quarantine table (see
+// migration/008_create_quarantine_table.sql). Entries are keyed by the
+// reading's natural key and the rule, so quarantining a reading again on a
+// replay or backfill updates the stored row.
+type PostgresWriter struct {
+	db *sql.DB
+}
+
+// NewPostgresWriter returns a PostgresWriter that uses db.
+func NewPostgresWriter(db *sql.DB) *PostgresWriter {
+	return &PostgresWriter{db: db}
+}
+
+// Write inserts entries in one statement.
+func (w *PostgresWriter) Write(ctx context.Context, entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	// An upsert cannot touch a row twice, so repeats keep the last entry.
+	type rowKey struct {
+		key  api.ReadingKey
+		rule string
+	}
+	index := make(map[rowKey]int)
+	var rows []Entry
+	for _, e := range entries {
+		k := rowKey{e.Key(), e.Rule}
+		if i, ok := index[k]; ok {
+			rows[i] = e
+			continue
+		}
+		index[k] = len(rows)
+		rows = append(rows, e)
+	}
+
+	var (
+		values []string
+		args   []interface{}
+	)
+	for _, e := range rows {
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8))
+		args = append(args, e.DeviceID, time.Unix(e.Time, 0).UTC(), e.MessageID, e.Value, e.Stage, e.Rule, e.Reason, e.QuarantinedAt)
+	}
+	query := `INSERT INTO quarantine (device_id, timestamp, message_id, value, stage, rule, reason, quarantined_at)
+VALUES ` + strings.Join(values, ", ") + `
+ON CONFLICT (device_id, timestamp, message_id, rule) DO UPDATE
+SET value = EXCLUDED.value, stage = EXCLUDED.stage, reason = EXCLUDED.reason, quarantined_at = EXCLUDED.quarantined_at`
+	_, err := w.db.ExecContext(ctx, query, args...)
+	return err
+}
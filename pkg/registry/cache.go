@@ -0,0 +1,201 @@
+package registry
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+)
+
+// CacheConfig configures a Cache.
+type CacheConfig struct {
+	// Size is the most devices held (default 10000). The least recently
+	// used are evicted first.
+	Size int
+	// TTL is how long a device is served from the cache (default 5m).
+	TTL time.Duration
+	// NegativeTTL is how long a device missing from the registry is
+	// remembered as missing (default 1m).
+	NegativeTTL time.Duration
+	// RefreshAhead is the fraction of TTL after which a cached device is
+	// reloaded in the background when it is used, so devices in use never
+	// expire (default 0.8).
+	RefreshAhead float64
+}
+
+// refreshTimeout bounds a background refresh.
+const refreshTimeout = 10 * time.Second
+
+// Cache is a Lookup that keeps devices in memory. Devices are reloaded
+// ahead of expiry while in use, missing devices are cached too, and
+// concurrent misses for the same device share one lookup.
+type Cache struct {
+	lookup Lookup
+	cfg    CacheConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	order    *list.List // of *cacheEntry, most recently used first
+	entries  map[string]*list.Element
+	inflight map[string]*load
+}
+
+// cacheEntry is a device, or its absence if device is nil.
+type cacheEntry struct {
+	id         string
+	device     *Device
+	expires    time.Time
+	refreshAt  time.Time
+	refreshing bool
+}
+
+// load is a lookup other callers can wait for.
+type load struct {
+	done   chan struct{}
+	device *Device
+	err    error
+}
+
+// NewCache returns a cache in front of lookup.
+func NewCache(lookup Lookup, cfg CacheConfig) *Cache {
+	if cfg.Size <= 0 {
+		cfg.Size = 10000
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 5 * time.Minute
+	}
+	if cfg.NegativeTTL <= 0 {
+		cfg.NegativeTTL = time.Minute
+	}
+	if cfg.RefreshAhead <= 0 || cfg.RefreshAhead > 1 {
+		cfg.RefreshAhead = 0.8
+	}
+	return &Cache{
+		lookup:   lookup,
+		cfg:      cfg,
+		now:      time.Now,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*load),
+	}
+}
+
+// Lookup returns the device, from the cache if it holds it.
+func (c *Cache) Lookup(ctx context.Context, deviceID string) (*Device, error) {
+	now := c.now()
+	c.mu.Lock()
+	if e, ok := c.entries[deviceID]; ok {
+		ent := e.Value.(*cacheEntry)
+		if now.Before(ent.expires) {
+			c.order.MoveToFront(e)
+			if ent.device != nil && !ent.refreshing && !now.Before(ent.refreshAt) {
+				ent.refreshing = true
+				go c.refresh(deviceID)
+			}
+			device := ent.device
+			c.mu.Unlock()
+			if device == nil {
+				metrics.RegistryLookups.WithLabelValues("negative_hit").Inc()
+				return nil, ErrNotFound
+			}
+			metrics.RegistryLookups.WithLabelValues("hit").Inc()
+			return device, nil
+		}
+	}
+	metrics.RegistryLookups.WithLabelValues("miss").Inc()
+	l, waiting := c.inflight[deviceID]
+	if !waiting {
+		l = &load{done: make(chan struct{})}
+		c.inflight[deviceID] = l
+	}
+	c.mu.Unlock()
+
+	if waiting {
+		select {
+		case <-l.done:
+			return l.device, l.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	l.device, l.err = c.fetch(ctx, deviceID)
+	c.mu.Lock()
+	delete(c.inflight, deviceID)
+	c.mu.Unlock()
+	close(l.done)
+	return l.device, l.err
+}
+
+// DeviceType returns the type of the device, or "" if it is not in the
+// registry.
+func (c *Cache) DeviceType(ctx context.Context, deviceID string) (string, error) {
+	device, err := c.Lookup(ctx, deviceID)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return device.Type, nil
+}
+
+// refresh reloads a cached device in the background. If the registry
+// cannot be reached the cached device is kept, and the next use retries.
+func (c *Cache) refresh(deviceID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+	defer cancel()
+	metrics.RegistryLookups.WithLabelValues("refresh").Inc()
+	if _, err := c.fetch(ctx, deviceID); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Printf("Error refreshing device %s: %v", deviceID, err)
+		c.mu.Lock()
+		if e, ok := c.entries[deviceID]; ok {
+			e.Value.(*cacheEntry).refreshing = false
+		}
+		c.mu.Unlock()
+	}
+}
+
+// fetch looks the device up in the registry and caches the answer. Errors
+// other than ErrNotFound are not cached.
+func (c *Cache) fetch(ctx context.Context, deviceID string) (*Device, error) {
+	device, err := c.lookup.Lookup(ctx, deviceID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	c.store(deviceID, device)
+	return device, err
+}
+
+// store caches device, or its absence if device is nil.
+func (c *Cache) store(deviceID string, device *Device) {
+	now := c.now()
+	ent := &cacheEntry{id: deviceID, device: device, expires: now.Add(c.cfg.NegativeTTL)}
+	if device != nil {
+		ent.expires = now.Add(c.cfg.TTL)
+		ent.refreshAt = now.Add(time.Duration(float64(c.cfg.TTL) * c.cfg.RefreshAhead))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[deviceID]; ok {
+		e.Value = ent
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[deviceID] = c.order.PushFront(ent)
+	if c.order.Len() > c.cfg.Size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).id)
+	}
+}
+
+// Len returns the number of devices cached, including missing ones.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
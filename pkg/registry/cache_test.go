@@ -0,0 +1,187 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeLookup serves devices from a map, counting lookups. block, if set,
+// holds every lookup until it is closed.
+type fakeLookup struct {
+	mu      sync.Mutex
+	devices map[string]*Device
+	err     error
+	calls   atomic.Int64
+	block   chan struct{}
+}
+
+func (f *fakeLookup) Lookup(ctx context.Context, deviceID string) (*Device, error) {
+	f.calls.Add(1)
+	if f.block != nil {
+		<-f.block
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	d, ok := f.devices[deviceID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *d
+	return &copied, nil
+}
+
+func (f *fakeLookup) set(d *Device) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devices[d.ID] = d
+}
+
+func newTestCache(lookup Lookup, cfg CacheConfig) (*Cache, *time.Time) {
+	c := NewCache(lookup, cfg)
+	now := time.Unix(1700000000, 0)
+	c.now = func() time.Time {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return now
+	}
+	return c, &now
+}
+
+func TestCache_HitsAndExpiry(t *testing.T) {
+	f := &fakeLookup{devices: map[string]*Device{"t-1": {ID: "t-1", Type: "temperature"}}}
+	c, now := newTestCache(f, CacheConfig{TTL: time.Minute, RefreshAhead: 1})
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		d, err := c.Lookup(ctx, "t-1")
+		if err != nil || d.Type != "temperature" {
+			t.Fatalf("Lookup = %+v, %v", d, err)
+		}
+	}
+	if n := f.calls.Load(); n != 1 {
+		t.Fatalf("registry looked up %d times, want 1", n)
+	}
+	c.mu.Lock()
+	*now = now.Add(time.Minute)
+	c.mu.Unlock()
+	c.Lookup(ctx, "t-1")
+	if n := f.calls.Load(); n != 2 {
+		t.Errorf("registry looked up %d times after expiry, want 2", n)
+	}
+}
+
+func TestCache_NegativeCaching(t *testing.T) {
+	f := &fakeLookup{devices: map[string]*Device{}}
+	c, now := newTestCache(f, CacheConfig{NegativeTTL: 30 * time.Second})
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Lookup(ctx, "ghost"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Lookup error = %v, want ErrNotFound", err)
+		}
+	}
+	if n := f.calls.Load(); n != 1 {
+		t.Fatalf("registry looked up %d times, want 1", n)
+	}
+	f.set(&Device{ID: "ghost", Type: "pressure"})
+	c.mu.Lock()
+	*now = now.Add(30 * time.Second)
+	c.mu.Unlock()
+	if d, err := c.Lookup(ctx, "ghost"); err != nil || d.Type != "pressure" {
+		t.Errorf("Lookup after negative TTL = %+v, %v; want the registered device", d, err)
+	}
+}
+
+func TestCache_ErrorsNotCached(t *testing.T) {
+	f := &fakeLookup{devices: map[string]*Device{"t-1": {ID: "t-1"}}, err: errors.New("connection refused")}
+	c, _ := newTestCache(f, CacheConfig{})
+	if _, err := c.Lookup(context.Background(), "t-1"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Lookup error = %v, want the registry's error", err)
+	}
+	f.mu.Lock()
+	f.err = nil
+	f.mu.Unlock()
+	if _, err := c.Lookup(context.Background(), "t-1"); err != nil {
+		t.Errorf("Lookup after recovery: %v", err)
+	}
+}
+
+func TestCache_RefreshAhead(t *testing.T) {
+	f := &fakeLookup{devices: map[string]*Device{"t-1": {ID: "t-1", Site: "A"}}}
+	c, now := newTestCache(f, CacheConfig{TTL: 100 * time.Second, RefreshAhead: 0.5})
+	ctx := context.Background()
+	c.Lookup(ctx, "t-1")
+
+	f.set(&Device{ID: "t-1", Site: "B"})
+	c.mu.Lock()
+	*now = now.Add(60 * time.Second)
+	c.mu.Unlock()
+	// Served from the cache while the refresh runs.
+	if d, _ := c.Lookup(ctx, "t-1"); d.Site != "A" {
+		t.Fatalf("site = %q, want the cached A", d.Site)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d, _ := c.Lookup(ctx, "t-1")
+		if d.Site == "B" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("device was not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := f.calls.Load(); n != 2 {
+		t.Errorf("registry looked up %d times, want 2", n)
+	}
+}
+
+func TestCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	f := &fakeLookup{devices: map[string]*Device{"a": {ID: "a"}, "b": {ID: "b"}, "c": {ID: "c"}}}
+	c, _ := newTestCache(f, CacheConfig{Size: 2})
+	ctx := context.Background()
+	c.Lookup(ctx, "a")
+	c.Lookup(ctx, "b")
+	c.Lookup(ctx, "a")
+	c.Lookup(ctx, "c") // evicts b
+	if c.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", c.Len())
+	}
+	before := f.calls.Load()
+	c.Lookup(ctx, "a")
+	c.Lookup(ctx, "b")
+	if n := f.calls.Load() - before; n != 1 {
+		t.Errorf("registry looked up %d times, want 1 (for b)", n)
+	}
+}
+
+func TestCache_SharesConcurrentMisses(t *testing.T) {
+	f := &fakeLookup{devices: map[string]*Device{"t-1": {ID: "t-1"}}, block: make(chan struct{})}
+	c, _ := newTestCache(f, CacheConfig{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Lookup(context.Background(), "t-1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for f.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(f.block)
+	wg.Wait()
+	if n := f.calls.Load(); n != 1 {
+		t.Errorf("registry looked up %d times, want 1", n)
+	}
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+// Device is a device's entry in the registry.
+type Device struct {
+	ID   string
+	Type string
+	// Unit is the unit the device reports values in, such as degC or psi.
+	Unit  string
+	Site  string
+	Asset string
+	// Tags are further labels of the device.
+	Tags map[string]string
+}
+
+// ErrNotFound is returned for devices missing from the registry.
+var ErrNotFound = errors.New("device not in registry")
+
+// Lookup finds a device in the registry, returning ErrNotFound if it is
+// not there. Implementations must be safe for concurrent use.
+type Lookup interface {
+	Lookup(ctx context.Context, deviceID string) (*Device, error)
+}
+
+// PostgresLookup reads devices from the devices table (see
+// migration/007_create_devices_table.sql).
+type PostgresLookup struct {
+	db *sql.DB
+}
+
+// NewPostgresLookup returns a PostgresLookup that uses db.
+func NewPostgresLookup(db *sql.DB) *PostgresLookup {
+	return &PostgresLookup{db: db}
+}
+
+// Lookup reads one device.
+func (l *PostgresLookup) Lookup(ctx context.Context, deviceID string) (*Device, error) {
+	d := &Device{ID: deviceID}
+	var tags []byte
+	err := l.db.QueryRowContext(ctx,
+		`SELECT device_type, unit, site, asset, tags FROM devices WHERE device_id = $1`, deviceID,
+	).Scan(&d.Type, &d.Unit, &d.Site, &d.Asset, &tags)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tags, &d.Tags); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/quarantine"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// Policies for readings from devices missing from the registry.
+const (
+	// UnknownTag passes the reading on with the label unknown_device=true.
+	UnknownTag = "tag"
+	// UnknownQuarantine moves the reading to quarantine.
+	UnknownQuarantine = "quarantine"
+	// UnknownReject drops the reading.
+	UnknownReject = "reject"
+)
+
+// LabelUnknownDevice marks readings from devices missing from the registry
+// under the tag policy.
+const LabelUnknownDevice = "unknown_device"
+
+// ruleUnknownDevice is the quarantine rule of unknown devices.
+const ruleUnknownDevice = "unknown_device"
+
+// Stage is the pipeline's enrich stage. It looks every reading's device up
+// in the registry and labels the reading with the device's type, unit,
+// site, asset and tags. Readings from devices missing from the registry
+// are handled by the unknown-device policy. A registry that cannot be
+// reached fails the stage, so the stage's error policy applies.
+type Stage struct {
+	lookup     Lookup
+	unknown    string
+	quarantine quarantine.Writer
+	now        func() time.Time
+}
+
+// NewStage returns an enrich stage looking devices up with lookup, usually
+// a Cache. unknown is UnknownTag, UnknownQuarantine or UnknownReject; q
+// receives the quarantined readings and may be nil for the other policies.
+func NewStage(lookup Lookup, unknown string, q quarantine.Writer) *Stage {
+	return &Stage{lookup: lookup, unknown: unknown, quarantine: q, now: time.Now}
+}
+
+// Name returns "enrich".
+func (*Stage) Name() string { return telemetry.StageEnrich }
+
+// Process labels the readings of m.
+func (s *Stage) Process(ctx context.Context, m *telemetry.Message) error {
+	kept := make([]telemetry.Reading, 0, len(m.Readings))
+	var held []quarantine.Entry
+	for _, r := range m.Readings {
+		device, err := s.lookup.Lookup(ctx, r.DeviceID)
+		switch {
+		case err == nil:
+			r.Labels = labels(r.Labels, device)
+			kept = append(kept, r)
+			continue
+		case !errors.Is(err, ErrNotFound):
+			return fmt.Errorf("looking up device %s: %w", r.DeviceID, err)
+		}
+		metrics.UnknownDevices.WithLabelValues(s.unknown).Inc()
+		switch s.unknown {
+		case UnknownQuarantine:
+			held = append(held, quarantine.Entry{
+				TelemetryData: r.TelemetryData,
+				Stage:         telemetry.StageEnrich,
+				Rule:          ruleUnknownDevice,
+				Reason:        fmt.Sprintf("device %s is not in the registry", r.DeviceID),
+				QuarantinedAt: s.now().UTC(),
+			})
+		case UnknownReject:
+		default:
+			r.Labels = copyLabels(r.Labels, 1)
+			r.Labels[LabelUnknownDevice] = "true"
+			kept = append(kept, r)
+		}
+	}
+	if len(held) > 0 {
+		if err := s.quarantine.Write(ctx, held); err != nil {
+			return fmt.Errorf("quarantining readings: %w", err)
+		}
+		metrics.QuarantinedReadings.WithLabelValues(telemetry.StageEnrich, ruleUnknownDevice).Add(float64(len(held)))
+	}
+	m.Readings = kept
+	return nil
+}
+
+// labels returns a copy of existing with device's labels added. Registry
+// fields replace tags of the same name; empty fields are left out.
+func labels(existing map[string]string, device *Device) map[string]string {
+	out := copyLabels(existing, len(device.Tags)+4)
+	for k, v := range device.Tags {
+		out[k] = v
+	}
+	for k, v := range map[string]string{
+		telemetry.LabelDeviceType: device.Type,
+		telemetry.LabelUnit:       device.Unit,
+		telemetry.LabelSite:       device.Site,
+		telemetry.LabelAsset:      device.Asset,
+	} {
+		if v != "" {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// copyLabels copies labels into a map with room for extra more. Readings
+// may share label maps, so they are never changed in place.
+func copyLabels(labels map[string]string, extra int) map[string]string {
+	out := make(map[string]string, len(labels)+extra)
+	for k, v := range labels {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,92 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/quarantine"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// memoryQuarantine keeps quarantined readings.
+type memoryQuarantine struct {
+	entries []quarantine.Entry
+}
+
+func (q *memoryQuarantine) Write(ctx context.Context, entries []quarantine.Entry) error {
+	q.entries = append(q.entries, entries...)
+	return nil
+}
+
+var testDevices = map[string]*Device{
+	"boiler-1": {ID: "boiler-1", Type: "temperature", Unit: "degC", Site: "A", Asset: "boiler", Tags: map[string]string{"line": "3", "site": "old"}},
+}
+
+func message(deviceIDs ...string) *telemetry.Message {
+	m := &telemetry.Message{}
+	for _, id := range deviceIDs {
+		m.Readings = append(m.Readings, telemetry.Reading{TelemetryData: api.TelemetryData{DeviceID: id, Value: 1, Time: 1700000000}})
+	}
+	return m
+}
+
+func TestStage_AttachesLabels(t *testing.T) {
+	s := NewStage(&fakeLookup{devices: testDevices}, UnknownTag, nil)
+	m := message("boiler-1")
+	if err := s.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"device_type": "temperature", "unit": "degC", "site": "A", "asset": "boiler", "line": "3"}
+	got := m.Readings[0].Labels
+	if len(got) != len(want) {
+		t.Fatalf("labels = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("label %s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestStage_UnknownPolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy      string
+		kept        int
+		quarantined int
+	}{
+		{UnknownTag, 2, 0},
+		{UnknownQuarantine, 1, 1},
+		{UnknownReject, 1, 0},
+	} {
+		q := &memoryQuarantine{}
+		s := NewStage(&fakeLookup{devices: testDevices}, tc.policy, q)
+		m := message("boiler-1", "ghost")
+		if err := s.Process(context.Background(), m); err != nil {
+			t.Fatalf("%s: %v", tc.policy, err)
+		}
+		if len(m.Readings) != tc.kept || len(q.entries) != tc.quarantined {
+			t.Errorf("%s: kept %d and quarantined %d readings, want %d and %d", tc.policy, len(m.Readings), len(q.entries), tc.kept, tc.quarantined)
+		}
+		if tc.policy == UnknownTag && m.Readings[1].Labels[LabelUnknownDevice] != "true" {
+			t.Errorf("tag: labels = %v, want unknown_device=true", m.Readings[1].Labels)
+		}
+		if tc.policy == UnknownQuarantine {
+			if e := q.entries[0]; e.DeviceID != "ghost" || e.Rule != "unknown_device" || e.Stage != telemetry.StageEnrich {
+				t.Errorf("quarantine entry = %+v", e)
+			}
+		}
+	}
+}
+
+func TestStage_RegistryErrorFails(t *testing.T) {
+	s := NewStage(&fakeLookup{err: errors.New("connection refused")}, UnknownTag, nil)
+	m := message("boiler-1")
+	if err := s.Process(context.Background(), m); err == nil {
+		t.Fatal("Process succeeded with the registry down")
+	}
+	if len(m.Readings) != 1 {
+		t.Error("a failed stage changed the readings")
+	}
+}
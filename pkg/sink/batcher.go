@@ -0,0 +1,200 @@
+package sink
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/api"
+)
+
+// BatchConfig controls how readings are grouped and retried.
+type BatchConfig struct {
+	// Size is the number of readings that triggers a flush.
+	Size int
+	// Interval is the longest a reading waits before its batch is flushed.
+	Interval time.Duration
+	// Writers is the number of batches written concurrently.
+	Writers int
+	// MaxRetries is the number of times a failed batch is retried before its
+	// items are completed with the error.
+	MaxRetries int
+	// Backoff is the delay before the first retry; it doubles on every
+	// further retry up to 30s.
+	Backoff time.Duration
+}
+
+// maxBackoff caps the delay between retries of a batch.
+const maxBackoff = 30 * time.Second
+
+// Batcher groups items into batches and writes them with a Writer.
+// A batch is flushed when it reaches Size items or when its oldest item has
+// waited Interval, whichever comes first. Items are completed only after
+// their batch has been committed, so callers can acknowledge (and
+// checkpoint) records from Item.Done.
+type Batcher struct {
+	writer Writer
+	cfg    BatchConfig
+
+	mu      sync.Mutex
+	pending []Item
+	timer   *time.Timer
+	sending sync.WaitGroup // batches taken but not yet handed to a writer
+
+	batches chan []Item
+	wg      sync.WaitGroup
+
+	// closing is cancelled once the context given to Close is done, which
+	// cuts writes and their retries short.
+	closing context.Context
+	cancel  context.CancelFunc
+}
+
+// NewBatcher starts a Batcher that writes with writer.
+func NewBatcher(writer Writer, cfg BatchConfig) *Batcher {
+	if cfg.Size <= 0 {
+		cfg.Size = 1000
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.Writers <= 0 {
+		cfg.Writers = 1
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = 500 * time.Millisecond
+	}
+	b := &Batcher{
+		writer: writer,
+		cfg:    cfg,
+		// Full batches wait here for a writer; once it is full, Add blocks,
+		// which applies backpressure to the workers and the source.
+		batches: make(chan []Item, cfg.Writers),
+	}
+	b.closing, b.cancel = context.WithCancel(context.Background())
+	for i := 0; i < cfg.Writers; i++ {
+		b.wg.Add(1)
+		go b.write()
+	}
+	return b
+}
+
+// Add queues item for the next batch. It blocks while every writer is busy
+// and the flush queue is full.
+func (b *Batcher) Add(item Item) {
+	b.mu.Lock()
+	b.pending = append(b.pending, item)
+	if len(b.pending) == 1 {
+		b.timer = time.AfterFunc(b.cfg.Interval, b.flushTimer)
+	}
+	var batch []Item
+	if len(b.pending) >= b.cfg.Size {
+		batch = b.take()
+	}
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// flushTimer flushes the pending batch once its oldest item has waited long enough.
+func (b *Batcher) flushTimer() {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	b.send(batch)
+}
+
+// send hands a batch returned by take to the writers.
+func (b *Batcher) send(batch []Item) {
+	if batch == nil {
+		return
+	}
+	b.batches <- batch
+	b.sending.Done()
+}
+
+// take removes and returns the pending batch, which the caller must pass to
+// send. b.mu must be held.
+func (b *Batcher) take() []Item {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	b.sending.Add(1)
+	return batch
+}
+
+// Close flushes the pending batch and waits until every batch has been
+// written or ctx is done. Once ctx is done, writes still in progress are
+// cancelled and not retried, so their items complete with an error. Add
+// must not be called after Close.
+func (b *Batcher) Close(ctx context.Context) error {
+	defer b.cancel()
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	b.send(batch)
+	// A timer that fired just before take may still be sending its batch;
+	// the channel is closed only after it has been handed over.
+	b.sending.Wait()
+	close(b.batches)
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write writes batches until the flush queue is closed.
+func (b *Batcher) write() {
+	defer b.wg.Done()
+	for batch := range b.batches {
+		err := b.writeWithRetry(batch)
+		for _, item := range batch {
+			if item.Done != nil {
+				item.Done(err)
+			}
+		}
+	}
+}
+
+// writeWithRetry writes batch, retrying with exponential backoff until the
+// retries run out or the batcher's close context is done.
+func (b *Batcher) writeWithRetry(batch []Item) error {
+	rows := make([]api.TelemetryData, len(batch))
+	for i, item := range batch {
+		rows[i] = item.Data
+	}
+
+	backoff := b.cfg.Backoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = b.writer.Write(b.closing, rows); err == nil {
+			return nil
+		}
+		if attempt >= b.cfg.MaxRetries {
+			return err
+		}
+		log.Printf("Error writing batch of %d readings (attempt %d): %v", len(rows), attempt+1, err)
+		select {
+		case <-time.After(backoff):
+		case <-b.closing.Done():
+			return err
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
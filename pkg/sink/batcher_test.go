@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/api"
+)
+
+// fakeWriter records batches and fails the first failures writes.
+type fakeWriter struct {
+	mu       sync.Mutex
+	batches  [][]api.TelemetryData
+	failures int
+}
+
+func (w *fakeWriter) Write(ctx context.Context, batch []api.TelemetryData) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("connection reset")
+	}
+	w.batches = append(w.batches, batch)
+	return nil
+}
+
+func (w *fakeWriter) sizes() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var sizes []int
+	for _, b := range w.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func TestBatcher_FlushesOnSize(t *testing.T) {
+	w := &fakeWriter{}
+	b := NewBatcher(w, BatchConfig{Size: 3, Interval: time.Hour})
+
+	var done sync.WaitGroup
+	done.Add(3)
+	for i := 0; i < 3; i++ {
+		b.Add(Item{Data: api.TelemetryData{DeviceID: "dev-1", Time: int64(i + 1)}, Done: func(err error) {
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			done.Done()
+		}})
+	}
+	// The batch is full, so it is written without waiting for the interval.
+	done.Wait()
+	if sizes := w.sizes(); len(sizes) != 1 || sizes[0] != 3 {
+		t.Fatalf("expected one batch of 3, got %v", sizes)
+	}
+	b.Close(context.Background())
+}
+
+func TestBatcher_FlushesOnInterval(t *testing.T) {
+	w := &fakeWriter{}
+	b := NewBatcher(w, BatchConfig{Size: 100, Interval: 10 * time.Millisecond})
+
+	committed := make(chan error, 1)
+	b.Add(Item{Data: api.TelemetryData{DeviceID: "dev-1", Time: 1}, Done: func(err error) { committed <- err }})
+	select {
+	case err := <-committed:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("partial batch was not flushed after the interval")
+	}
+	b.Close(context.Background())
+}
+
+func TestBatcher_RetriesThenFails(t *testing.T) {
+	// Two failures are absorbed by two retries.
+	w := &fakeWriter{failures: 2}
+	b := NewBatcher(w, BatchConfig{Size: 1, MaxRetries: 2, Backoff: time.Millisecond})
+	var got error = errors.New("not called")
+	b.Add(Item{Data: api.TelemetryData{DeviceID: "dev-1", Time: 1}, Done: func(err error) { got = err }})
+	b.Close(context.Background())
+	if got != nil {
+		t.Fatalf("expected the batch to commit after retries, got %v", got)
+	}
+
+	// A third failure exhausts them and the item completes with the error.
+	w = &fakeWriter{failures: 3}
+	b = NewBatcher(w, BatchConfig{Size: 1, MaxRetries: 2, Backoff: time.Millisecond})
+	got = nil
+	b.Add(Item{Data: api.TelemetryData{DeviceID: "dev-1", Time: 1}, Done: func(err error) { got = err }})
+	b.Close(context.Background())
+	if got == nil {
+		t.Fatal("expected the batch to fail after exhausting retries")
+	}
+}
+
+func TestBatcher_CloseCutsRetriesShort(t *testing.T) {
+	w := &fakeWriter{failures: 100}
+	b := NewBatcher(w, BatchConfig{Size: 1, MaxRetries: 100, Backoff: time.Hour})
+	completed := make(chan error, 1)
+	b.Add(Item{Data: api.TelemetryData{DeviceID: "dev-1", Time: 1}, Done: func(err error) { completed <- err }})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("close = %v, want the deadline exceeded", err)
+	}
+	select {
+	case err := <-completed:
+		if err == nil {
+			t.Error("expected the item to complete with the write error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the batch was still being retried after Close returned")
+	}
+}
+
+func TestBatcher_CloseFlushesPending(t *testing.T) {
+	w := &fakeWriter{}
+	b := NewBatcher(w, BatchConfig{Size: 100, Interval: time.Hour})
+	for i := 0; i < 5; i++ {
+		b.Add(Item{Data: api.TelemetryData{DeviceID: "dev-1", Time: int64(i + 1)}})
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if sizes := w.sizes(); len(sizes) != 1 || sizes[0] != 5 {
+		t.Fatalf("expected the pending batch to be written on close, got %v", sizes)
+	}
+}
@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"testing"
+
+	"iot-insighthub/pkg/api"
+)
+
+func TestDedupeBatch_KeepsLastValuePerKey(t *testing.T) {
+	batch := []api.TelemetryData{
+		{DeviceID: "dev-1", Time: 1, Value: 1},
+		{DeviceID: "dev-2", Time: 1, Value: 2},
+		{DeviceID: "dev-1", Time: 1, Value: 3},
+		{DeviceID: "dev-1", Time: 1, Value: 4, MessageID: "m-2"},
+	}
+	rows := dedupeBatch(batch)
+	if len(rows) != 3 {
+		t.Fatalf("expected 3 rows, got %+v", rows)
+	}
+	if rows[0].DeviceID != "dev-1" || rows[0].Value != 3 {
+		t.Errorf("expected the repeated reading to keep its position and last value, got %+v", rows[0])
+	}
+	if rows[2].MessageID != "m-2" {
+		t.Errorf("expected a reading with another message ID to be kept, got %+v", rows[2])
+	}
+}
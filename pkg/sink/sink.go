@@ -0,0 +1,36 @@
+package sink
+
+import (
+	"context"
+
+	"iot-insighthub/pkg/api"
+)
+
+// Writer stores a batch of readings atomically: either every reading is
+// committed or none is. Implementations must be safe for concurrent use.
+type Writer interface {
+	Write(ctx context.Context, batch []api.TelemetryData) error
+}
+
+// Pinger is implemented by writers that can check their connection to the
+// store, for readiness probes.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Item is a reading waiting to be stored. Done is called exactly once, with
+// nil after the batch holding the reading has been committed, or with the
+// last error once every retry has failed.
+type Item struct {
+	Data api.TelemetryData
+	Done func(err error)
+}
+
+// DiscardWriter drops every batch. It is meant for local development and
+// load tests of the ingestion path without a database.
+type DiscardWriter struct{}
+
+// Write discards batch.
+func (DiscardWriter) Write(ctx context.Context, batch []api.TelemetryData) error {
+	return nil
+}
@@ -0,0 +1,279 @@
+package source
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/checkpoint"
+	"iot-insighthub/pkg/lease"
+)
+
+// PartitionReader is implemented by sources whose data is split into
+// independently readable partitions, such as Kinesis shards or Kafka
+// partitions.
+type PartitionReader interface {
+	// Discover returns the partitions that are ready to be read and the
+	// partitions that have been read to the end and can be forgotten.
+	Discover(ctx context.Context) (ready, finished []string, err error)
+	// Read sends the records of one partition to out, resuming from its
+	// checkpoint, until ctx is cancelled or the partition ends.
+	Read(ctx context.Context, partition string, out chan<- *Record)
+}
+
+// PartitionedConfig controls how partitions are shared between replicas.
+type PartitionedConfig struct {
+	// Checkpointer tracks per-partition progress. Its tracker for a partition
+	// is released when the partition's lease moves to another replica.
+	Checkpointer *checkpoint.Checkpointer
+	// Leases is the shared lease table used to split partitions between replicas.
+	Leases lease.Store
+	// WorkerID identifies this replica in the lease table.
+	WorkerID string
+	// LeaseDuration is how long a lease survives without a heartbeat.
+	LeaseDuration time.Duration
+	// DiscoveryInterval is how often partitions are re-discovered.
+	DiscoveryInterval time.Duration
+	// OnAcquire, if set, is called when this replica takes a partition's
+	// lease, before its reader starts.
+	OnAcquire func(partition string)
+	// OnRelease, if set, is called when a partition's lease moves to another
+	// replica, after its reader has been stopped.
+	OnRelease func(partition string)
+}
+
+// Partitioned reads every partition this replica holds a lease for.
+// Partitions are re-discovered every DiscoveryInterval so new ones are picked
+// up without a restart.
+type Partitioned struct {
+	reader      PartitionReader
+	cfg         PartitionedConfig
+	runner      *partitionRunner
+	coordinator *lease.Coordinator
+
+	mu       sync.Mutex
+	stopHold func() // stops renewing leases after Run returns
+}
+
+// NewPartitioned returns a Partitioned that reads partitions with reader.
+func NewPartitioned(reader PartitionReader, cfg PartitionedConfig) *Partitioned {
+	if cfg.DiscoveryInterval <= 0 {
+		cfg.DiscoveryInterval = time.Minute
+	}
+	runner := newPartitionRunner(cfg.Checkpointer)
+	return &Partitioned{
+		reader: reader,
+		cfg:    cfg,
+		runner: runner,
+		coordinator: lease.NewCoordinator(cfg.Leases, lease.Config{
+			WorkerID:      cfg.WorkerID,
+			LeaseDuration: cfg.LeaseDuration,
+			OnAcquire: func(partition string) {
+				if cfg.OnAcquire != nil {
+					cfg.OnAcquire(partition)
+				}
+				runner.start(partition)
+			},
+			OnLose: func(partition string) {
+				runner.stop(partition)
+				if cfg.OnRelease != nil {
+					cfg.OnRelease(partition)
+				}
+			},
+		}),
+	}
+}
+
+// Run reads partitions until ctx is cancelled and every reader has exited.
+// Leases are kept, and renewed, after Run returns so that in-flight records
+// can be drained and checkpointed first; call Release to hand them over.
+func (p *Partitioned) Run(ctx context.Context, out chan<- *Record) error {
+	p.runner.run(ctx, func(ctx context.Context, partition string) {
+		p.reader.Read(ctx, partition, out)
+	})
+
+	// Discover partitions once before claiming leases, then keep following
+	// changes in the background.
+	if err := discover(ctx, p.reader, p.coordinator); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(p.cfg.DiscoveryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := discover(ctx, p.reader, p.coordinator); err != nil {
+					log.Printf("Error discovering partitions: %v", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Renew and rebalance leases until shutdown, then only renew them until
+	// Release.
+	p.coordinator.Run(ctx)
+	hold, cancel := context.WithCancel(context.Background())
+	held := make(chan struct{})
+	go func() {
+		defer close(held)
+		p.coordinator.Hold(hold)
+	}()
+	p.mu.Lock()
+	p.stopHold = func() {
+		cancel()
+		<-held
+	}
+	p.mu.Unlock()
+	p.runner.wait()
+	return nil
+}
+
+// Partitions reports every partition this replica holds a lease for and
+// the state of its reader.
+func (p *Partitioned) Partitions() []PartitionStatus {
+	owned := p.coordinator.Owned()
+	statuses := make([]PartitionStatus, len(owned))
+	for i, partition := range owned {
+		statuses[i] = p.runner.status(partition)
+	}
+	return statuses
+}
+
+// MarkRead records a successful read from partition, such as a GetRecords
+// call, even if it returned no records. Readers call it so that Partitions
+// can tell an idle partition from a stuck one.
+func (p *Partitioned) MarkRead(partition string) {
+	p.runner.markRead(partition)
+}
+
+// Release gives up every lease held by this replica so other replicas can
+// take over immediately instead of waiting for the leases to expire.
+func (p *Partitioned) Release(ctx context.Context) {
+	p.mu.Lock()
+	if p.stopHold != nil {
+		p.stopHold()
+	}
+	p.mu.Unlock()
+	p.coordinator.ReleaseAll(ctx)
+}
+
+// discover creates leases for ready partitions and removes the leases of
+// finished ones.
+func discover(ctx context.Context, reader PartitionReader, coordinator *lease.Coordinator) error {
+	ready, finished, err := reader.Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if err := coordinator.RemoveShards(ctx, finished); err != nil {
+		return err
+	}
+	log.Printf("Discovered %d partitions ready to consume", len(ready))
+	return coordinator.SyncShards(ctx, ready)
+}
+
+// partitionRunner starts and stops partition readers as this replica gains
+// and loses leases.
+type partitionRunner struct {
+	cp *checkpoint.Checkpointer
+
+	mu      sync.Mutex
+	ctx     context.Context
+	read    func(ctx context.Context, partition string)
+	running map[string]*partitionReader
+	wg      sync.WaitGroup
+}
+
+// partitionReader is a read goroutine. It stays in partitionRunner.running
+// after exiting, until the lease is lost, so that an owned partition nobody
+// is reading shows up in Partitions.
+type partitionReader struct {
+	cancel   context.CancelFunc
+	since    time.Time // when the reader started, or exited if done
+	lastRead time.Time
+	done     bool
+}
+
+// newPartitionRunner returns a partitionRunner that drops trackers from cp
+// when leases are lost.
+func newPartitionRunner(cp *checkpoint.Checkpointer) *partitionRunner {
+	return &partitionRunner{
+		cp:      cp,
+		running: make(map[string]*partitionReader),
+	}
+}
+
+// run sets the function used to read partitions. Readers started afterwards
+// stop when ctx is cancelled.
+func (r *partitionRunner) run(ctx context.Context, read func(ctx context.Context, partition string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ctx = ctx
+	r.read = read
+}
+
+// start launches a reader for partition unless one is already running.
+func (r *partitionRunner) start(partition string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pr, running := r.running[partition]; (running && !pr.done) || r.read == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	pr := &partitionReader{cancel: cancel, since: time.Now()}
+	r.running[partition] = pr
+	r.wg.Add(1)
+	read := r.read
+	go func() {
+		defer r.wg.Done()
+		read(ctx, partition)
+		r.mu.Lock()
+		pr.done = true
+		pr.since = time.Now()
+		r.mu.Unlock()
+		cancel()
+	}()
+}
+
+// stop cancels the reader for partition and drops its checkpoint tracker,
+// since the replica that now owns the lease also owns the checkpoint.
+func (r *partitionRunner) stop(partition string) {
+	r.mu.Lock()
+	pr, running := r.running[partition]
+	delete(r.running, partition)
+	r.mu.Unlock()
+	if running {
+		pr.cancel()
+	}
+	r.cp.Release(partition)
+}
+
+// markRead records a successful read by the reader of partition.
+func (r *partitionRunner) markRead(partition string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pr, ok := r.running[partition]; ok {
+		pr.lastRead = time.Now()
+	}
+}
+
+// status returns the state of the reader for partition.
+func (r *partitionRunner) status(partition string) PartitionStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := PartitionStatus{Partition: partition}
+	if pr, ok := r.running[partition]; ok {
+		s.Reading = !pr.done
+		s.Since = pr.since
+		s.LastRead = pr.lastRead
+	}
+	return s
+}
+
+// wait blocks until every partition reader has exited.
+func (r *partitionRunner) wait() {
+	r.wg.Wait()
+}
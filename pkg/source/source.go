@@ -0,0 +1,76 @@
+package source
+
+import (
+	"context"
+	"time"
+)
+
+// Record is a single message read from a Source, independent of the
+// transport it came from.
+type Record struct {
+	// Key is the partition key (Kinesis) or message key (Kafka).
+	Key string
+	// Data is the raw payload.
+	Data []byte
+	// Partition identifies the shard or partition the record was read from.
+	Partition string
+	// Offset is the record's position within its partition, such as a
+	// Kinesis sequence number or a Kafka offset.
+	Offset string
+	// ArrivedAt is when the record was accepted by the transport, if known.
+	ArrivedAt time.Time
+
+	ack func()
+}
+
+// NewRecord returns a Record whose Ack calls ack.
+func NewRecord(key string, data []byte, partition, offset string, arrivedAt time.Time, ack func()) *Record {
+	return &Record{
+		Key:       key,
+		Data:      data,
+		Partition: partition,
+		Offset:    offset,
+		ArrivedAt: arrivedAt,
+		ack:       ack,
+	}
+}
+
+// Ack marks the record as fully processed so its source can checkpoint past it.
+// It must be called exactly once per record, whether processing succeeded or not.
+func (r *Record) Ack() {
+	if r.ack != nil {
+		r.ack()
+	}
+}
+
+// Source produces records for the ingestor's worker pool.
+type Source interface {
+	// Run sends records to out until ctx is cancelled or the source is
+	// exhausted. It does not close out.
+	Run(ctx context.Context, out chan<- *Record) error
+}
+
+// Releaser is implemented by sources that hold shared resources, such as
+// partition leases, beyond Run. Release is called during shutdown once every
+// record has been acknowledged and checkpointed.
+type Releaser interface {
+	Release(ctx context.Context)
+}
+
+// PartitionStatus describes a partition this replica holds a lease for.
+type PartitionStatus struct {
+	Partition string `json:"partition"`
+	// Reading reports whether a reader goroutine is running for the partition.
+	Reading bool `json:"reading"`
+	// Since is when the reader started or, if it is not reading, when it
+	// exited. It is zero if no reader was started.
+	Since time.Time `json:"since"`
+	// LastRead is the time of the last successful read, if any.
+	LastRead time.Time `json:"last_read"`
+}
+
+// Reporter is implemented by sources that can report the partitions they
+// are reading.
+type Reporter interface {
+	Partitions() []PartitionStatus
+}
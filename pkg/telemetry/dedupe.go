@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"container/list"
+	"sync"
+
+	"iot-insighthub/pkg/api"
+)
+
+// RecentReadings remembers the most recently stored readings so that exact
+// redeliveries can be dropped before they reach the database. It is bounded:
+// once it holds Size readings, storing another evicts the least recently
+// used one. The database's unique key remains the source of truth; the cache
+// only saves it work.
+type RecentReadings struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List // of *recentReading, most recently used first
+	entries map[api.ReadingKey]*list.Element
+}
+
+// recentReading is an entry of RecentReadings.
+type recentReading struct {
+	key    api.ReadingKey
+	stored storedFields
+}
+
+// storedFields are the stored fields of a reading that are not part of its
+// key, and that a correction may change.
+type storedFields struct {
+	value       float64
+	rawValue    float64
+	hasRawValue bool
+	unit        string
+	rawUnit     string
+}
+
+func storedFieldsOf(data api.TelemetryData) storedFields {
+	f := storedFields{value: data.Value, unit: data.Unit, rawUnit: data.RawUnit}
+	if data.RawValue != nil {
+		f.rawValue, f.hasRawValue = *data.RawValue, true
+	}
+	return f
+}
+
+// NewRecentReadings returns a cache of up to size readings. A size of zero
+// or less returns nil, which disables the cache: a nil *RecentReadings
+// reports no duplicates.
+func NewRecentReadings(size int) *RecentReadings {
+	if size <= 0 {
+		return nil
+	}
+	return &RecentReadings{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[api.ReadingKey]*list.Element, size),
+	}
+}
+
+// Duplicate reports whether a reading with the same key, value, raw value
+// and units was stored recently. A reading with the same key but a
+// different value or unit is not a duplicate: it is a correction and must
+// reach the database.
+func (r *RecentReadings) Duplicate(data api.TelemetryData) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok := r.entries[data.Key()]
+	if !ok || e.Value.(*recentReading).stored != storedFieldsOf(data) {
+		return false
+	}
+	r.order.MoveToFront(e)
+	return true
+}
+
+// Stored records that data has been committed to the database. Readings are
+// added only once stored, so a reading whose write failed is never mistaken
+// for a duplicate when it is redelivered.
+func (r *RecentReadings) Stored(data api.TelemetryData) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := data.Key()
+	if e, ok := r.entries[key]; ok {
+		e.Value.(*recentReading).stored = storedFieldsOf(data)
+		r.order.MoveToFront(e)
+		return
+	}
+	r.entries[key] = r.order.PushFront(&recentReading{key: key, stored: storedFieldsOf(data)})
+	if r.order.Len() > r.size {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*recentReading).key)
+	}
+}
+
+// Len returns the number of readings in the cache.
+func (r *RecentReadings) Len() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.order.Len()
+}
@@ -0,0 +1,80 @@
+package telemetry
+
+import (
+	"testing"
+
+	"iot-insighthub/pkg/api"
+)
+
+func TestRecentReadings_DropsOnlyStoredRepeats(t *testing.T) {
+	r := NewRecentReadings(10)
+	reading := api.TelemetryData{DeviceID: "dev-1", Value: 21.5, Time: 100}
+
+	if r.Duplicate(reading) {
+		t.Fatal("a reading that was never stored is not a duplicate")
+	}
+	r.Stored(reading)
+	if !r.Duplicate(reading) {
+		t.Error("expected a stored reading to be a duplicate")
+	}
+
+	corrected := reading
+	corrected.Value = 22
+	if r.Duplicate(corrected) {
+		t.Error("a reading with a new value is a correction, not a duplicate")
+	}
+	otherUnit := reading
+	otherUnit.Unit = "degF"
+	if r.Duplicate(otherUnit) {
+		t.Error("a reading with a new unit is a correction, not a duplicate")
+	}
+	raw := 70.7
+	reconverted := reading
+	reconverted.RawValue, reconverted.RawUnit = &raw, "degF"
+	if r.Duplicate(reconverted) {
+		t.Error("a reading with a new raw value is a correction, not a duplicate")
+	}
+	r.Stored(reconverted)
+	again := reconverted
+	rawAgain := raw
+	again.RawValue = &rawAgain
+	if !r.Duplicate(again) {
+		t.Error("expected a stored converted reading to be a duplicate")
+	}
+	other := reading
+	other.MessageID = "m-2"
+	if r.Duplicate(other) {
+		t.Error("a reading with another message ID is not a duplicate")
+	}
+}
+
+func TestRecentReadings_EvictsLeastRecentlyUsed(t *testing.T) {
+	r := NewRecentReadings(2)
+	a := api.TelemetryData{DeviceID: "dev-1", Time: 1}
+	b := api.TelemetryData{DeviceID: "dev-1", Time: 2}
+	c := api.TelemetryData{DeviceID: "dev-1", Time: 3}
+
+	r.Stored(a)
+	r.Stored(b)
+	r.Duplicate(a) // a is now more recently used than b
+	r.Stored(c)
+
+	if r.Len() != 2 {
+		t.Fatalf("expected 2 cached readings, got %d", r.Len())
+	}
+	if !r.Duplicate(a) || !r.Duplicate(c) {
+		t.Error("expected a and c to stay cached")
+	}
+	if r.Duplicate(b) {
+		t.Error("expected b to be evicted")
+	}
+}
+
+func TestRecentReadings_NilIsDisabled(t *testing.T) {
+	r := NewRecentReadings(0)
+	reading := api.TelemetryData{DeviceID: "dev-1", Time: 1}
+	r.Stored(reading)
+	if r.Duplicate(reading) {
+		t.Error("a disabled cache must not report duplicates")
+	}
+}
@@ -0,0 +1,299 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/quarantine"
+)
+
+// Policies for readings that arrive too late.
+const (
+	// TooLateDrop drops the reading.
+	TooLateDrop = "drop"
+	// TooLateSideOutput moves the reading to quarantine, under the rule
+	// too_late, where it can be reviewed or replayed.
+	TooLateSideOutput = "side-output"
+	// TooLateUpdate passes the reading on marked Late. It is stored, and
+	// the aggregate stage adds it to the rollups it has already written.
+	TooLateUpdate = "update"
+)
+
+// ruleTooLate is the quarantine rule of side-output readings.
+const ruleTooLate = "too_late"
+
+// EventTimeConfig configures an EventTime.
+type EventTimeConfig struct {
+	// AllowedLateness is how far behind the watermark a reading may be and
+	// still count as on time.
+	AllowedLateness time.Duration
+	// TooLate is the policy for later readings: TooLateDrop (the default),
+	// TooLateSideOutput or TooLateUpdate.
+	TooLate string
+	// IdleTimeout is how long a partition may go without readings before
+	// it stops holding the watermark back (default 1m).
+	IdleTimeout time.Duration
+	// FollowWallClock advances the watermark with the wall clock, less
+	// IdleTimeout, while every partition is idle, so that time still passes
+	// when the whole stream goes quiet. It suits live streams, not
+	// backfills, whose event times are in the past.
+	FollowWallClock bool
+}
+
+// EventTime tracks the pipeline's progress in event time. Each partition
+// has its own watermark, the latest reading time seen in it, which never
+// goes back; whether a reading is late is decided against its partition's
+// watermark, so a partition that starts later or lags behind the others,
+// as during a backfill or after a shard split, does not see its readings
+// treated as late. The pipeline's watermark is the lowest among partitions
+// that are not idle, and so goes back when a partition behind the others
+// appears. An idle partition's watermark follows the pipeline's. Stages
+// share one EventTime.
+//
+// A partition whose lease moves to another replica is released: it is
+// forgotten, and stages holding state for it are told with the callbacks
+// registered with OnRelease.
+type EventTime struct {
+	cfg EventTimeConfig
+	now func() time.Time
+
+	mu         sync.Mutex
+	partitions map[string]*partitionTime
+	released   map[string]bool
+	onRelease  []func(partition string)
+}
+
+// partitionTime is the event-time progress of one partition.
+type partitionTime struct {
+	watermark int64
+	seenAt    time.Time
+}
+
+// NewEventTime returns an EventTime with no partitions and a zero
+// watermark.
+func NewEventTime(cfg EventTimeConfig) *EventTime {
+	if cfg.TooLate == "" {
+		cfg.TooLate = TooLateDrop
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = time.Minute
+	}
+	return &EventTime{
+		cfg:        cfg,
+		now:        time.Now,
+		partitions: make(map[string]*partitionTime),
+		released:   make(map[string]bool),
+	}
+}
+
+// OnRelease registers fn to be called with every partition released. It
+// must be called before the pipeline starts.
+func (e *EventTime) OnRelease(fn func(partition string)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onRelease = append(e.onRelease, fn)
+}
+
+// Acquire marks partition as read by this replica, after it was released.
+func (e *EventTime) Acquire(partition string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.released, partition)
+}
+
+// Release forgets partition, whose lease another replica now holds, and
+// runs the OnRelease callbacks. Until it is acquired again, readings still
+// in flight from it do not move its watermark, and Owned reports false.
+func (e *EventTime) Release(partition string) {
+	e.mu.Lock()
+	delete(e.partitions, partition)
+	e.released[partition] = true
+	callbacks := e.onRelease
+	e.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(partition)
+	}
+}
+
+// Owned reports whether partition is read by this replica: whether it has
+// not been released.
+func (e *EventTime) Owned(partition string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return !e.released[partition]
+}
+
+// AllowedLateness returns the configured allowed lateness.
+func (e *EventTime) AllowedLateness() time.Duration { return e.cfg.AllowedLateness }
+
+// TooLatePolicy returns the policy for readings that arrive too late.
+func (e *EventTime) TooLatePolicy() string { return e.cfg.TooLate }
+
+// Observe records a reading at event time t (Unix seconds) in partition.
+// A device whose clock runs ahead must not move time on for everyone, so t
+// counts as no later than now plus the allowed lateness.
+func (e *EventTime) Observe(partition string, t int64) {
+	now := e.now()
+	if limit := now.Add(e.cfg.AllowedLateness).Unix(); t > limit {
+		t = limit
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.released[partition] {
+		return
+	}
+	p, ok := e.partitions[partition]
+	if !ok {
+		p = &partitionTime{watermark: t}
+		e.partitions[partition] = p
+	}
+	if t > p.watermark {
+		p.watermark = t
+	}
+	p.seenAt = now
+}
+
+// Watermark returns the pipeline's event time (Unix seconds) up to which
+// readings are expected to have arrived, or 0 before any reading.
+func (e *EventTime) Watermark() int64 {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wm := e.watermark(now)
+	metrics.Watermark.Set(float64(wm))
+	return wm
+}
+
+// watermark returns the lowest watermark of the partitions that are not
+// idle at now. If all are idle, time moves on with the latest of them, and
+// with the wall clock if FollowWallClock is set. e.mu must be held.
+func (e *EventTime) watermark(now time.Time) int64 {
+	var (
+		wm     int64
+		active bool
+	)
+	for _, p := range e.partitions {
+		if e.idle(p, now) {
+			continue
+		}
+		if !active || p.watermark < wm {
+			wm = p.watermark
+		}
+		active = true
+	}
+	if active || len(e.partitions) == 0 {
+		return wm
+	}
+	for _, p := range e.partitions {
+		if p.watermark > wm {
+			wm = p.watermark
+		}
+	}
+	if e.cfg.FollowWallClock {
+		if wall := now.Add(-e.cfg.IdleTimeout).Unix(); wall > wm {
+			wm = wall
+		}
+	}
+	return wm
+}
+
+func (e *EventTime) idle(p *partitionTime, now time.Time) bool {
+	return now.Sub(p.seenAt) >= e.cfg.IdleTimeout
+}
+
+// PartitionWatermark returns the watermark of partition, or 0 if it has
+// sent no reading. An idle partition's watermark is moved on to the
+// pipeline's, so its open windows can close.
+func (e *EventTime) PartitionWatermark(partition string) int64 {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	p, ok := e.partitions[partition]
+	if !ok {
+		return 0
+	}
+	if e.idle(p, now) {
+		if wm := e.watermark(now); wm > p.watermark {
+			p.watermark = wm
+		}
+	}
+	return p.watermark
+}
+
+// TooLate reports whether a reading at event time t from partition is
+// behind the partition's watermark by more than the allowed lateness.
+func (e *EventTime) TooLate(partition string, t int64) bool {
+	wm := e.PartitionWatermark(partition)
+	return wm > 0 && t < wm-int64(e.cfg.AllowedLateness/time.Second)
+}
+
+// Partition returns the partition m was read from, or "" if it has no
+// record.
+func (m *Message) Partition() string {
+	if m.Record == nil {
+		return ""
+	}
+	return m.Record.Partition
+}
+
+// ObserveMessage records the readings of m in its partition.
+func (e *EventTime) ObserveMessage(m *Message) {
+	partition := m.Partition()
+	for _, r := range m.Readings {
+		e.Observe(partition, r.Time)
+	}
+}
+
+// WatermarkStage advances the watermark with every reading and applies the
+// too-late policy of Clock to readings that are too late. Placed early in
+// the pipeline, it keeps dropped and side-output readings out of storage and
+// of every later stage. Quarantine receives side-output readings and may be
+// nil for the other policies.
+type WatermarkStage struct {
+	Clock      *EventTime
+	Quarantine quarantine.Writer
+}
+
+// Name returns "watermark".
+func (*WatermarkStage) Name() string { return StageWatermark }
+
+// Process applies the too-late policy to the readings of m, then records
+// them.
+func (s *WatermarkStage) Process(ctx context.Context, m *Message) error {
+	policy := s.Clock.TooLatePolicy()
+	partition := m.Partition()
+	kept := make([]Reading, 0, len(m.Readings))
+	var held []quarantine.Entry
+	for _, r := range m.Readings {
+		if !s.Clock.TooLate(partition, r.Time) {
+			kept = append(kept, r)
+			continue
+		}
+		metrics.TooLateReadings.WithLabelValues(policy).Inc()
+		switch policy {
+		case TooLateUpdate:
+			r.Late = true
+			kept = append(kept, r)
+		case TooLateSideOutput:
+			held = append(held, quarantine.Entry{
+				TelemetryData: r.TelemetryData,
+				Stage:         StageWatermark,
+				Rule:          ruleTooLate,
+				Reason:        fmt.Sprintf("reading at %d is more than %s behind the watermark %d of partition %q", r.Time, s.Clock.AllowedLateness(), s.Clock.PartitionWatermark(partition), partition),
+				QuarantinedAt: time.Now().UTC(),
+			})
+		}
+	}
+	if len(held) > 0 {
+		if err := s.Quarantine.Write(ctx, held); err != nil {
+			return fmt.Errorf("quarantining late readings: %w", err)
+		}
+		metrics.QuarantinedReadings.WithLabelValues(StageWatermark, ruleTooLate).Add(float64(len(held)))
+	}
+	s.Clock.ObserveMessage(m)
+	m.Readings = kept
+	return nil
+}
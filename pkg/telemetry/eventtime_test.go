@@ -0,0 +1,155 @@
+package telemetry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/quarantine"
+	"iot-insighthub/pkg/source"
+)
+
+// newTestClock returns an EventTime whose wall clock the test sets with
+// *now.
+func newTestClock(cfg EventTimeConfig) (*EventTime, *time.Time) {
+	e := NewEventTime(cfg)
+	now := time.Unix(1700000000, 0)
+	e.now = func() time.Time { return now }
+	return e, &now
+}
+
+func TestEventTime_SlowestActivePartition(t *testing.T) {
+	e, now := newTestClock(EventTimeConfig{IdleTimeout: time.Minute})
+	e.Observe("shard-1", 1000)
+	e.Observe("shard-2", 400)
+	e.Observe("shard-1", 1200)
+	if wm := e.Watermark(); wm != 400 {
+		t.Fatalf("watermark = %d, want the lagging partition's 400", wm)
+	}
+
+	*now = now.Add(30 * time.Second)
+	e.Observe("shard-1", 1300)
+	*now = now.Add(30 * time.Second)
+	if wm := e.Watermark(); wm != 1300 {
+		t.Errorf("watermark = %d, want 1300 once shard-2 is idle", wm)
+	}
+	if wm := e.PartitionWatermark("shard-2"); wm != 1300 {
+		t.Errorf("idle shard-2's watermark = %d, want it moved on to 1300", wm)
+	}
+	e.Observe("shard-2", 500)
+	if wm := e.PartitionWatermark("shard-2"); wm != 1300 {
+		t.Errorf("shard-2's watermark = %d, want it never to go back", wm)
+	}
+}
+
+func TestEventTime_PartitionsStartingAtDifferentTimes(t *testing.T) {
+	e, _ := newTestClock(EventTimeConfig{AllowedLateness: time.Minute})
+	// shard-1 reports first, an hour ahead of shard-2, as when a backfill
+	// reads every shard at once or a child shard starts after a split.
+	e.Observe("shard-1", 4600)
+	if e.TooLate("shard-2", 1000) {
+		t.Error("the first reading of a new partition is too late")
+	}
+	e.Observe("shard-2", 1000)
+	if wm := e.Watermark(); wm != 1000 {
+		t.Errorf("watermark = %d, want it held back to shard-2's 1000", wm)
+	}
+	if e.TooLate("shard-2", 950) {
+		t.Error("a reading within shard-2's allowed lateness is too late")
+	}
+	if !e.TooLate("shard-2", 900) {
+		t.Error("a reading behind shard-2's allowed lateness is on time")
+	}
+	if !e.TooLate("shard-1", 1000) {
+		t.Error("an hour-old reading on shard-1 is on time")
+	}
+}
+
+func TestEventTime_FollowsWallClockWhenIdle(t *testing.T) {
+	e, now := newTestClock(EventTimeConfig{IdleTimeout: time.Minute, FollowWallClock: true})
+	e.Observe("shard-1", now.Unix()-3600)
+	*now = now.Add(2 * time.Minute)
+	if wm, want := e.Watermark(), now.Add(-time.Minute).Unix(); wm != want {
+		t.Errorf("watermark = %d, want %d", wm, want)
+	}
+}
+
+func TestEventTime_ClampsClocksRunningAhead(t *testing.T) {
+	e, now := newTestClock(EventTimeConfig{AllowedLateness: time.Minute})
+	e.Observe("shard-1", now.Unix()+86400)
+	if wm, want := e.Watermark(), now.Unix()+60; wm != want {
+		t.Errorf("watermark = %d, want %d", wm, want)
+	}
+}
+
+func TestEventTime_ReleasedPartitionIsForgotten(t *testing.T) {
+	e, _ := newTestClock(EventTimeConfig{})
+	var released []string
+	e.OnRelease(func(partition string) { released = append(released, partition) })
+	e.Observe("shard-1", 1000)
+	e.Observe("shard-2", 400)
+
+	e.Release("shard-2")
+	if len(released) != 1 || released[0] != "shard-2" {
+		t.Errorf("OnRelease callbacks got %v, want shard-2", released)
+	}
+	if e.Owned("shard-2") || !e.Owned("shard-1") {
+		t.Error("want shard-2 released and shard-1 owned")
+	}
+	e.Observe("shard-2", 300) // still in flight
+	if wm := e.Watermark(); wm != 1000 {
+		t.Errorf("watermark = %d, want shard-1's 1000", wm)
+	}
+
+	e.Acquire("shard-2")
+	e.Observe("shard-2", 500)
+	if !e.Owned("shard-2") || e.Watermark() != 500 {
+		t.Errorf("after Acquire: owned %v, watermark %d, want shard-2 owned at 500", e.Owned("shard-2"), e.Watermark())
+	}
+}
+
+// memoryQuarantine keeps quarantined entries.
+type memoryQuarantine struct{ entries []quarantine.Entry }
+
+func (q *memoryQuarantine) Write(ctx context.Context, entries []quarantine.Entry) error {
+	q.entries = append(q.entries, entries...)
+	return nil
+}
+
+func TestWatermarkStage_Policies(t *testing.T) {
+	for _, policy := range []string{TooLateDrop, TooLateSideOutput, TooLateUpdate} {
+		t.Run(policy, func(t *testing.T) {
+			clock, _ := newTestClock(EventTimeConfig{AllowedLateness: time.Minute, TooLate: policy})
+			q := &memoryQuarantine{}
+			s := &WatermarkStage{Clock: clock, Quarantine: q}
+			process := func(times ...int64) *Message {
+				m := &Message{Record: &source.Record{Partition: "shard-1"}}
+				for _, at := range times {
+					m.Readings = append(m.Readings, Reading{TelemetryData: api.TelemetryData{DeviceID: "dev-1", Time: at}})
+				}
+				if err := s.Process(context.Background(), m); err != nil {
+					t.Fatal(err)
+				}
+				return m
+			}
+			process(1000)
+			m := process(950, 900) // 950 is within the allowed lateness
+
+			switch policy {
+			case TooLateDrop:
+				if len(m.Readings) != 1 || m.Readings[0].Time != 950 {
+					t.Errorf("readings = %+v, want only the one within the allowed lateness", m.Readings)
+				}
+			case TooLateSideOutput:
+				if len(m.Readings) != 1 || len(q.entries) != 1 || q.entries[0].Time != 900 || q.entries[0].Rule != ruleTooLate {
+					t.Errorf("readings = %+v, quarantined = %+v, want the late one quarantined", m.Readings, q.entries)
+				}
+			case TooLateUpdate:
+				if len(m.Readings) != 2 || m.Readings[0].Late || !m.Readings[1].Late {
+					t.Errorf("readings = %+v, want both, the late one marked", m.Readings)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/source"
+)
+
+// Message is one source record on its way through a Pipeline. The decode
+// stage fills Readings; later stages inspect, change, add or remove them.
+type Message struct {
+	Record   *source.Record
+	Readings []Reading
+}
+
+// Reading is a decoded reading plus what the pipeline has learned about it.
+type Reading struct {
+	api.TelemetryData
+	// Output names where the reading is delivered; empty means the default
+	// output. It is set by the route stage.
+	Output string
+	// Labels describe the device, such as its type or site. They are set by
+	// the enrich stage.
+	Labels map[string]string
+	// Late marks a reading that arrived too late under the update policy:
+	// results already emitted for its time are updated rather than
+	// computed afresh. It is set by the watermark stage.
+	Late bool
+}
+
+// Labels set on readings by the enrich stage from the device registry.
+const (
+	// LabelDeviceType holds the device's type, such as temperature.
+	LabelDeviceType = "device_type"
+	// LabelUnit holds the unit the device reports values in.
+	LabelUnit  = "unit"
+	LabelSite  = "site"
+	LabelAsset = "asset"
+)
+
+// Stage is one step of a Pipeline. Process may change m in place; returning
+// an error stops the message and applies the stage's ErrorPolicy. A stage
+// that filters readings removes them from m.Readings and returns nil.
+// Stages must be safe for concurrent use: every worker shares them.
+type Stage interface {
+	Name() string
+	Process(ctx context.Context, m *Message) error
+}
+
+// Closer is implemented by stages that hold state or background work, such
+// as open windows, to finish when the pipeline shuts down.
+type Closer interface {
+	Close(ctx context.Context) error
+}
+
+// ErrorPolicy decides what happens to a message whose stage fails.
+type ErrorPolicy string
+
+const (
+	// PolicyDrop acknowledges and discards the message. It is counted but
+	// not kept, so use it only for data that is expected to be bad.
+	PolicyDrop ErrorPolicy = "drop"
+	// PolicyDLQ sends the message to the dead-letter queue for replay. It is
+	// the default.
+	PolicyDLQ ErrorPolicy = "dlq"
+	// PolicyFail leaves the message unacknowledged and stops the ingestor,
+	// so that it is read again after the cause has been fixed.
+	PolicyFail ErrorPolicy = "fail"
+)
+
+// parsePolicy returns the ErrorPolicy named s.
+func parsePolicy(s string) (ErrorPolicy, error) {
+	switch p := ErrorPolicy(s); p {
+	case PolicyDrop, PolicyDLQ, PolicyFail:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown error policy %q (want drop, dlq or fail)", s)
+	}
+}
+
+// Pipeline runs messages through an ordered chain of stages.
+type Pipeline struct {
+	stages []pipelineStage
+}
+
+// pipelineStage is a Stage with its error policy.
+type pipelineStage struct {
+	stage  Stage
+	policy ErrorPolicy
+}
+
+// Stages returns the names of the pipeline's stages, in order.
+func (p *Pipeline) Stages() []string {
+	names := make([]string, len(p.stages))
+	for i, s := range p.stages {
+		names[i] = s.stage.Name()
+	}
+	return names
+}
+
+// Process runs m through every stage in order. It returns nil when m made it
+// through, or was dropped by a stage's PolicyDrop; in that case m.Readings
+// is empty. Otherwise it returns a *ProcessingError whose Cause is the
+// failing stage and whose Policy says how to handle the record.
+func (p *Pipeline) Process(ctx context.Context, m *Message) error {
+	for _, s := range p.stages {
+		name := s.stage.Name()
+		start := time.Now()
+		err := s.stage.Process(ctx, m)
+		metrics.StageDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+		if err == nil {
+			metrics.StageOutcomes.WithLabelValues(name, "ok").Inc()
+			if len(m.Readings) == 0 {
+				// Filtered out entirely: nothing left for later stages.
+				return nil
+			}
+			continue
+		}
+		metrics.StageOutcomes.WithLabelValues(name, string(s.policy)).Inc()
+		if s.policy == PolicyDrop {
+			m.Readings = nil
+			return nil
+		}
+		return &ProcessingError{Cause: name, Policy: s.policy, Err: err}
+	}
+	return nil
+}
+
+// Close closes every stage that implements Closer, in order, and returns
+// the first error.
+func (p *Pipeline) Close(ctx context.Context) error {
+	var first error
+	for _, s := range p.stages {
+		c, ok := s.stage.(Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(ctx); err != nil && first == nil {
+			first = fmt.Errorf("closing stage %s: %w", s.stage.Name(), err)
+		}
+	}
+	return first
+}
+
+// StageFactory creates a stage from a deployment's configuration.
+type StageFactory func() (Stage, error)
+
+// Builder assembles a Pipeline. Stages are added either directly with Use,
+// or by name with UseSpec from factories registered with Register, so each
+// deployment can configure its chain, for example
+// "decode,validate:drop,route".
+type Builder struct {
+	factories map[string]StageFactory
+	stages    []pipelineStage
+	errs      []string
+}
+
+// NewBuilder returns a Builder that knows the decode and validate stages.
+func NewBuilder() *Builder {
+	b := &Builder{factories: make(map[string]StageFactory)}
+	b.Register(StageDecode, func() (Stage, error) { return DecodeStage{}, nil })
+	b.Register(StageValidate, func() (Stage, error) { return ValidateStage{}, nil })
+	return b
+}
+
+// Register makes a stage available to UseSpec under name.
+func (b *Builder) Register(name string, factory StageFactory) *Builder {
+	b.factories[name] = factory
+	return b
+}
+
+// Use appends stage with policy.
+func (b *Builder) Use(stage Stage, policy ErrorPolicy) *Builder {
+	b.stages = append(b.stages, pipelineStage{stage: stage, policy: policy})
+	return b
+}
+
+// UseSpec appends the registered stage described by spec, "name" or
+// "name:policy". The policy defaults to PolicyDLQ.
+func (b *Builder) UseSpec(spec string) *Builder {
+	name, policyName, hasPolicy := strings.Cut(strings.TrimSpace(spec), ":")
+	policy := PolicyDLQ
+	if hasPolicy {
+		p, err := parsePolicy(policyName)
+		if err != nil {
+			b.errs = append(b.errs, fmt.Sprintf("stage %s: %v", name, err))
+			return b
+		}
+		policy = p
+	}
+	factory, ok := b.factories[name]
+	if !ok {
+		b.errs = append(b.errs, fmt.Sprintf("unknown stage %q", name))
+		return b
+	}
+	stage, err := factory()
+	if err != nil {
+		b.errs = append(b.errs, fmt.Sprintf("stage %s: %v", name, err))
+		return b
+	}
+	return b.Use(stage, policy)
+}
+
+// Build returns the pipeline, or every problem found while assembling it.
+// The chain must start with the decode stage, which creates the readings
+// the other stages work on, and a stage may appear only once.
+func (b *Builder) Build() (*Pipeline, error) {
+	errs := append([]string(nil), b.errs...)
+	seen := make(map[string]bool)
+	for _, s := range b.stages {
+		name := s.stage.Name()
+		if seen[name] {
+			errs = append(errs, fmt.Sprintf("stage %s appears more than once", name))
+		}
+		seen[name] = true
+	}
+	switch {
+	case len(b.stages) == 0:
+		errs = append(errs, "the pipeline has no stages")
+	case b.stages[0].stage.Name() != StageDecode:
+		errs = append(errs, "the decode stage must come first")
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "; "))
+	}
+	return &Pipeline{stages: append([]pipelineStage(nil), b.stages...)}, nil
+}
@@ -0,0 +1,214 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/source"
+	"iot-insighthub/pkg/validation"
+)
+
+// funcStage is a Stage backed by a function.
+type funcStage struct {
+	name string
+	fn   func(m *Message) error
+}
+
+func (s funcStage) Name() string { return s.name }
+
+func (s funcStage) Process(ctx context.Context, m *Message) error { return s.fn(m) }
+
+func newMessage(payload string) *Message {
+	return &Message{Record: &source.Record{Data: []byte(payload)}}
+}
+
+func TestPipeline_RunsStagesInOrder(t *testing.T) {
+	var order []string
+	record := func(name string) Stage {
+		return funcStage{name: name, fn: func(m *Message) error {
+			order = append(order, name)
+			return nil
+		}}
+	}
+	p, err := NewBuilder().
+		UseSpec("decode").
+		Use(record("a"), PolicyDLQ).
+		Use(record("b"), PolicyDLQ).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newMessage(`{"device_id":"dev-1","value":1.5,"time":10}`)
+	if err := p.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(order, ",") != "a,b" {
+		t.Errorf("expected stages a,b to run in order, got %v", order)
+	}
+	if len(m.Readings) != 1 || m.Readings[0].DeviceID != "dev-1" {
+		t.Errorf("unexpected readings %+v", m.Readings)
+	}
+	if got := strings.Join(p.Stages(), ","); got != "decode,a,b" {
+		t.Errorf("unexpected stages %s", got)
+	}
+}
+
+func TestPipeline_ErrorPolicies(t *testing.T) {
+	boom := errors.New("boom")
+	tests := []struct {
+		policy  ErrorPolicy
+		wantErr bool
+		fatal   bool
+	}{
+		{PolicyDrop, false, false},
+		{PolicyDLQ, true, false},
+		{PolicyFail, true, true},
+	}
+	for _, tt := range tests {
+		ran := false
+		p, err := NewBuilder().
+			UseSpec("decode").
+			Use(funcStage{name: "detect", fn: func(*Message) error { return boom }}, tt.policy).
+			Use(funcStage{name: "after", fn: func(*Message) error { ran = true; return nil }}, PolicyDLQ).
+			Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := newMessage(`{"device_id":"dev-1","time":10}`)
+		err = p.Process(context.Background(), m)
+		if ran {
+			t.Errorf("%s: stages after a failure must not run", tt.policy)
+		}
+		if !tt.wantErr {
+			if err != nil || len(m.Readings) != 0 {
+				t.Errorf("%s: expected the message to be dropped, got %v, %d readings", tt.policy, err, len(m.Readings))
+			}
+			continue
+		}
+		if Cause(err) != "detect" || !errors.Is(err, boom) {
+			t.Errorf("%s: expected a detect failure wrapping boom, got %v", tt.policy, err)
+		}
+		if Fatal(err) != tt.fatal {
+			t.Errorf("%s: expected Fatal %v", tt.policy, tt.fatal)
+		}
+	}
+}
+
+func TestPipeline_StopsWhenAllReadingsFiltered(t *testing.T) {
+	ran := false
+	p, err := NewBuilder().
+		UseSpec("decode").
+		Use(funcStage{name: "filter", fn: func(m *Message) error { m.Readings = nil; return nil }}, PolicyDLQ).
+		Use(funcStage{name: "after", fn: func(*Message) error { ran = true; return nil }}, PolicyDLQ).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Process(context.Background(), newMessage(`{"device_id":"dev-1","time":10}`)); err != nil {
+		t.Fatal(err)
+	}
+	if ran {
+		t.Error("stages must not run once every reading is filtered out")
+	}
+}
+
+func TestPipeline_DecodeAndValidateFailures(t *testing.T) {
+	p, err := NewBuilder().UseSpec("decode").UseSpec("validate:dlq").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Process(context.Background(), newMessage(`not json`)); Cause(err) != CauseDecode {
+		t.Errorf("expected a decode failure, got %v", err)
+	}
+	if err := p.Process(context.Background(), newMessage(`{"value":1}`)); Cause(err) != CauseValidate {
+		t.Errorf("expected a validate failure, got %v", err)
+	}
+}
+
+func TestValidateStage_QuarantinesInvalidReadings(t *testing.T) {
+	q := &memoryQuarantine{}
+	s := ValidateStage{Quarantine: q}
+	arrived := time.Unix(1700000000, 0)
+	m := &Message{Record: &source.Record{ArrivedAt: arrived}, Readings: []Reading{
+		{TelemetryData: api.TelemetryData{DeviceID: "dev-1", Value: 1, Time: arrived.Unix()}},
+		{TelemetryData: api.TelemetryData{DeviceID: "dev-2", Time: 10}},
+		{TelemetryData: api.TelemetryData{Time: arrived.Unix()}},
+	}}
+	if err := s.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Readings) != 1 || m.Readings[0].DeviceID != "dev-1" {
+		t.Errorf("readings = %+v, want only dev-1", m.Readings)
+	}
+	if len(q.entries) != 2 || q.entries[0].Rule != validation.RuleClockSkew || q.entries[1].Rule != validation.RuleRequired {
+		t.Errorf("quarantined = %+v, want dev-2 for clock skew and the reading without a device", q.entries)
+	}
+}
+
+func TestBuilder_ReportsInvalidChains(t *testing.T) {
+	tests := []struct {
+		specs []string
+		want  string
+	}{
+		{nil, "no stages"},
+		{[]string{"validate", "decode"}, "decode stage must come first"},
+		{[]string{"decode", "decode"}, "more than once"},
+		{[]string{"decode", "enrich"}, `unknown stage "enrich"`},
+		{[]string{"decode", "validate:retry"}, `unknown error policy "retry"`},
+	}
+	for _, tt := range tests {
+		b := NewBuilder()
+		for _, spec := range tt.specs {
+			b.UseSpec(spec)
+		}
+		_, err := b.Build()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%v: expected an error containing %q, got %v", tt.specs, tt.want, err)
+		}
+	}
+}
+
+func TestBuilder_ReportsFactoryErrors(t *testing.T) {
+	_, err := NewBuilder().
+		Register("route", func() (Stage, error) { return nil, errors.New("bad rule") }).
+		UseSpec("decode").
+		UseSpec("route").
+		Build()
+	if err == nil || !strings.Contains(err.Error(), "stage route: bad rule") {
+		t.Errorf("expected the factory error, got %v", err)
+	}
+}
+
+func TestRouteStage_FirstMatchingRuleWins(t *testing.T) {
+	rules, err := ParseRouteRules([]string{"test-=discard", "dev-=telemetry"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &RouteStage{Rules: rules, Default: "archive"}
+	m := &Message{Readings: []Reading{
+		{TelemetryData: newReading("test-1")},
+		{TelemetryData: newReading("dev-1")},
+		{TelemetryData: newReading("other")},
+	}}
+	if err := s.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"discard", "telemetry", "archive"} {
+		if m.Readings[i].Output != want {
+			t.Errorf("reading %d: expected output %s, got %s", i, want, m.Readings[i].Output)
+		}
+	}
+
+	if _, err := ParseRouteRules([]string{"dev-"}); err == nil {
+		t.Error("expected an error for a rule without an output")
+	}
+}
+
+func newReading(deviceID string) api.TelemetryData {
+	return api.TelemetryData{DeviceID: deviceID, Time: 1}
+}
@@ -1,32 +1,70 @@
 package telemetry
 
 import (
-	"encoding/json"
-	"log"
+	"context"
+	"errors"
 
 	"iot-insighthub/pkg/api"
-	"iot-insighthub/pkg/kinesis"
+	"iot-insighthub/pkg/source"
 )
 
-// ProcessRecord converts a raw Kinesis record into TelemetryData and processes it.
-func ProcessRecord(record *kinesis.Record) error {
-	// In production, you would unmarshal record.Data (assumed to be JSON) into TelemetryData.
-	var data api.TelemetryData
-	if err := json.Unmarshal(record.Data, &data); err != nil {
-		log.Printf("Error parsing record data: %v", err)
-		return err
-	}
+// Failure causes. A pipeline stage that fails reports its name as the cause;
+// these are the values of the "cause" label of
+// record_processing_failures_total.
+const (
+	CauseDecode   = StageDecode
+	CauseValidate = StageValidate
+	CauseSink     = "sink"
+)
+
+// ProcessingError is returned when a record fails processing. Cause tells
+// which step failed and Policy how the record should be handled; an empty
+// Policy means PolicyDLQ.
+type ProcessingError struct {
+	Cause  string
+	Policy ErrorPolicy
+	Err    error
+}
+
+func (e *ProcessingError) Error() string {
+	return e.Cause + ": " + e.Err.Error()
+}
 
-	// Process telemetry data (business logic such as anomaly detection, enrichment, etc.)
-	log.Printf("Processed telemetry from device %s: %+v", data.DeviceID, data)
+func (e *ProcessingError) Unwrap() error {
+	return e.Err
+}
 
-	// Simulate checkpointing (acknowledging record processing)
-	Checkpoint(record)
+// Cause returns the failure cause of err, or "unknown" if it is not a
+// *ProcessingError.
+func Cause(err error) string {
+	var perr *ProcessingError
+	if errors.As(err, &perr) {
+		return perr.Cause
+	}
+	return "unknown"
+}
 
-	return nil
+// Fatal reports whether err is a stage failure with PolicyFail.
+func Fatal(err error) bool {
+	var perr *ProcessingError
+	return errors.As(err, &perr) && perr.Policy == PolicyFail
 }
 
-// Checkpoint simulates recording that this record was processed successfully.
-func Checkpoint(record *kinesis.Record) {
-	log.Printf("Checkpointing record: %s", *record.SequenceNumber)
+// ProcessRecord converts a raw source record into readings and validates
+// them with the default rules, like a pipeline of just the decode and
+// validate stages. Storing the readings, and acknowledging the record once
+// they are stored, is left to the caller.
+func ProcessRecord(record *source.Record) ([]api.TelemetryData, error) {
+	m := &Message{Record: record}
+	if err := (DecodeStage{}).Process(context.Background(), m); err != nil {
+		return nil, &ProcessingError{Cause: CauseDecode, Err: err}
+	}
+	if err := (ValidateStage{}).Process(context.Background(), m); err != nil {
+		return nil, &ProcessingError{Cause: CauseValidate, Err: err}
+	}
+	data := make([]api.TelemetryData, len(m.Readings))
+	for i, r := range m.Readings {
+		data[i] = r.TelemetryData
+	}
+	return data, nil
 }
@@ -0,0 +1,163 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"iot-insighthub/pkg/codec"
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/quarantine"
+	"iot-insighthub/pkg/validation"
+)
+
+// Names of the standard stages. They are also the failure causes reported
+// when the stage fails. Decode, validate, watermark and route are implemented
+// here; the others by the packages providing them.
+const (
+	StageDecode    = "decode"
+	StageValidate  = "validate"
+	StageEnrich    = "enrich"
+	StageTransform = "transform"
+	StageWatermark = "watermark"
+	StageDetect    = "detect"
+	StageAggregate = "aggregate"
+	StageAlert     = "alert"
+	StageRoute     = "route"
+)
+
+// DecodeStage turns the record's payload into readings. The codec is
+// chosen from Codecs by the payload's header byte, or by sniffing JSON; see
+// codec.Registry.DecodeRecord. A payload may hold several readings.
+type DecodeStage struct {
+	// Codecs defaults to codec.Default().
+	Codecs *codec.Registry
+}
+
+// Name returns "decode".
+func (DecodeStage) Name() string { return StageDecode }
+
+// Process decodes m.Record into m.Readings.
+func (s DecodeStage) Process(ctx context.Context, m *Message) error {
+	codecs := s.Codecs
+	if codecs == nil {
+		codecs = codec.Default()
+	}
+	data, err := codecs.DecodeRecord(m.Record.Data)
+	if err != nil {
+		return err
+	}
+	m.Readings = make([]Reading, len(data))
+	for i, d := range data {
+		m.Readings[i] = Reading{TelemetryData: d}
+	}
+	return nil
+}
+
+// ValidateStage checks every reading with the validation rules shared with
+// the secure API: required fields, device ID format, value ranges by device
+// type and clock skew relative to the record's arrival. With a Quarantine,
+// invalid readings are moved there, one entry per rule broken, and the rest
+// passed on; without one, a message holding an invalid reading is rejected.
+type ValidateStage struct {
+	// Validator defaults to validation.Default().
+	Validator  *validation.Validator
+	Quarantine quarantine.Writer
+}
+
+// Name returns "validate".
+func (ValidateStage) Name() string { return StageValidate }
+
+// Process validates every reading of m. A reading's device type is taken
+// from its device_type label, set if the enrich stage ran first.
+func (s ValidateStage) Process(ctx context.Context, m *Message) error {
+	v := s.Validator
+	if v == nil {
+		v = validation.Default()
+	}
+	arrival := time.Now()
+	if m.Record != nil && !m.Record.ArrivedAt.IsZero() {
+		arrival = m.Record.ArrivedAt
+	}
+	kept := make([]Reading, 0, len(m.Readings))
+	var held []quarantine.Entry
+	for _, r := range m.Readings {
+		err := v.Check(ctx, r.TelemetryData, r.Labels[LabelDeviceType], arrival)
+		violations := validation.Violations(err)
+		switch {
+		case err == nil:
+			kept = append(kept, r)
+			continue
+		case violations == nil || s.Quarantine == nil:
+			return err
+		}
+		for _, violation := range violations {
+			held = append(held, quarantine.Entry{
+				TelemetryData: r.TelemetryData,
+				Stage:         StageValidate,
+				Rule:          violation.Rule,
+				Reason:        violation.Field + ": " + violation.Reason,
+				QuarantinedAt: time.Now().UTC(),
+			})
+		}
+	}
+	if len(held) > 0 {
+		if err := s.Quarantine.Write(ctx, held); err != nil {
+			return fmt.Errorf("quarantining invalid readings: %w", err)
+		}
+		for _, e := range held {
+			metrics.QuarantinedReadings.WithLabelValues(StageValidate, e.Rule).Inc()
+		}
+	}
+	m.Readings = kept
+	return nil
+}
+
+// RouteRule sends the readings of devices whose ID starts with DevicePrefix
+// to Output.
+type RouteRule struct {
+	DevicePrefix string
+	Output       string
+}
+
+// RouteStage sets each reading's Output from the first matching rule, or to
+// Default if none matches.
+type RouteStage struct {
+	Rules   []RouteRule
+	Default string
+}
+
+// ParseRouteRules parses rules written as "prefix=output".
+func ParseRouteRules(specs []string) ([]RouteRule, error) {
+	rules := make([]RouteRule, 0, len(specs))
+	for _, spec := range specs {
+		prefix, output, ok := strings.Cut(spec, "=")
+		if !ok || output == "" {
+			return nil, fmt.Errorf("invalid route %q (want prefix=output)", spec)
+		}
+		rules = append(rules, RouteRule{DevicePrefix: prefix, Output: output})
+	}
+	return rules, nil
+}
+
+// Name returns "route".
+func (*RouteStage) Name() string { return StageRoute }
+
+// Process routes every reading of m.
+func (s *RouteStage) Process(ctx context.Context, m *Message) error {
+	for i := range m.Readings {
+		m.Readings[i].Output = s.route(m.Readings[i].DeviceID)
+	}
+	return nil
+}
+
+// route returns the output for deviceID.
+func (s *RouteStage) route(deviceID string) string {
+	for _, rule := range s.Rules {
+		if strings.HasPrefix(deviceID, rule.DevicePrefix) {
+			return rule.Output
+		}
+	}
+	return s.Default
+}
@@ -0,0 +1,110 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"iot-insighthub/pkg/config"
+)
+
+// Config chooses each device's calibration and the unit it reports in.
+// A device's own entry and its device type's entry are merged field by
+// field, the device's taking precedence. Units adds to the built-in units.
+//
+//	units:
+//	  - name: inH2O
+//	    quantity: pressure
+//	    scale: 0.249089
+//	device_types:
+//	  legacy-thermometer:
+//	    unit: degF
+//	devices:
+//	  boiler-1:
+//	    unit: psi
+//	    calibration: {type: linear, gain: 1.02, offset: -0.5}
+type Config struct {
+	Units       []Unit             `yaml:"units"`
+	DeviceTypes map[string]Profile `yaml:"device_types"`
+	Devices     map[string]Profile `yaml:"devices"`
+
+	units *Units
+}
+
+// Profile is the transformation of a device or device type.
+type Profile struct {
+	// Unit is the unit the device reports in. It overrides the unit from
+	// the device registry.
+	Unit        string `yaml:"unit"`
+	Calibration *Curve `yaml:"calibration"`
+}
+
+// LoadConfig reads a Config from the YAML file at path with
+// config.LoadYAML.
+func LoadConfig(path string) (*Config, error) {
+	var c Config
+	if err := config.LoadYAML(path, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate reports every invalid unit, curve and unknown unit, and
+// prepares the config for use. An empty Config is valid: it converts the
+// units known from the registry and calibrates nothing.
+func (c *Config) Validate() error {
+	var problems []string
+	units, err := NewUnits(c.Units...)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	check := func(where string, p Profile) {
+		if p.Unit != "" && units != nil {
+			if _, ok := units.Lookup(p.Unit); !ok {
+				problems = append(problems, fmt.Sprintf("%s: unknown unit %q", where, p.Unit))
+			}
+		}
+		if p.Calibration != nil {
+			if err := p.Calibration.validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", where, err))
+			} else {
+				p.Calibration.prepare()
+			}
+		}
+	}
+	for _, name := range sortedKeys(c.DeviceTypes) {
+		check("device_types."+name, c.DeviceTypes[name])
+	}
+	for _, name := range sortedKeys(c.Devices) {
+		check("devices."+name, c.Devices[name])
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	c.units = units
+	return nil
+}
+
+// profile returns the merged profile of a device.
+func (c *Config) profile(deviceID, deviceType string) Profile {
+	p := c.Devices[deviceID]
+	if t, ok := c.DeviceTypes[deviceType]; ok && deviceType != "" {
+		if p.Unit == "" {
+			p.Unit = t.Unit
+		}
+		if p.Calibration == nil {
+			p.Calibration = t.Calibration
+		}
+	}
+	return p
+}
+
+func sortedKeys(m map[string]Profile) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
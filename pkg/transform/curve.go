@@ -0,0 +1,95 @@
+package transform
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Calibration curve types.
+const (
+	CurveLinear     = "linear"
+	CurvePolynomial = "polynomial"
+	CurveTable      = "table"
+)
+
+// Curve maps a sensor's raw value to the calibrated value. Which fields
+// apply depends on Type:
+//
+//   - linear: value*Gain + Offset (Gain defaults to 1).
+//   - polynomial: Coefficients[0] + Coefficients[1]*value +
+//     Coefficients[2]*value² + ...
+//   - table: linear interpolation between Points, each a pair of raw and
+//     calibrated values, extended beyond the first and last points along
+//     the nearest segment.
+type Curve struct {
+	Type         string       `yaml:"type"`
+	Gain         *float64     `yaml:"gain"`
+	Offset       float64      `yaml:"offset"`
+	Coefficients []float64    `yaml:"coefficients"`
+	Points       [][2]float64 `yaml:"points"`
+}
+
+// validate reports a problem with c.
+func (c *Curve) validate() error {
+	switch c.Type {
+	case CurveLinear:
+		if c.Gain != nil && *c.Gain == 0 {
+			return fmt.Errorf("linear curve gain must not be zero")
+		}
+	case CurvePolynomial:
+		if len(c.Coefficients) == 0 {
+			return fmt.Errorf("polynomial curve needs coefficients")
+		}
+	case CurveTable:
+		if len(c.Points) < 2 {
+			return fmt.Errorf("table curve needs at least two points")
+		}
+		seen := make(map[float64]bool)
+		for _, p := range c.Points {
+			if seen[p[0]] {
+				return fmt.Errorf("table curve has raw value %g twice", p[0])
+			}
+			seen[p[0]] = true
+		}
+	default:
+		return fmt.Errorf("unknown curve type %q (want linear, polynomial or table)", c.Type)
+	}
+	return nil
+}
+
+// prepare readies a valid curve for Apply.
+func (c *Curve) prepare() {
+	if c.Type == CurveTable {
+		sort.Slice(c.Points, func(i, j int) bool { return c.Points[i][0] < c.Points[j][0] })
+	}
+}
+
+// Apply returns the calibrated value of raw.
+func (c *Curve) Apply(raw float64) float64 {
+	switch c.Type {
+	case CurveLinear:
+		gain := 1.0
+		if c.Gain != nil {
+			gain = *c.Gain
+		}
+		return raw*gain + c.Offset
+	case CurvePolynomial:
+		// Horner's method.
+		var v float64
+		for i := len(c.Coefficients) - 1; i >= 0; i-- {
+			v = v*raw + c.Coefficients[i]
+		}
+		return v
+	default:
+		// The segment holding raw, or the first or last one outside the table.
+		i := sort.Search(len(c.Points), func(i int) bool { return c.Points[i][0] >= raw })
+		switch {
+		case i == 0:
+			i = 1
+		case i == len(c.Points):
+			i = len(c.Points) - 1
+		}
+		a, b := c.Points[i-1], c.Points[i]
+		return a[1] + (raw-a[0])*(b[1]-a[1])/(b[0]-a[0])
+	}
+}
@@ -0,0 +1,95 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"iot-insighthub/pkg/metrics"
+	"iot-insighthub/pkg/telemetry"
+)
+
+// Stage is the pipeline's transform stage. It applies each reading's
+// calibration curve and converts the result to the canonical unit of its
+// quantity, such as degC for temperatures or kPa for pressures. The unit a
+// reading is in comes from its device's profile, or the reading itself, or
+// the unit label set by the enrich stage. When Value changes, the value the
+// device sent is kept in RawValue; when Unit changes, the unit it sent is
+// kept in RawUnit, and the unit label is updated.
+type Stage struct {
+	cfg *Config
+}
+
+// NewStage returns a transform stage using cfg. A Config that was not
+// validated, such as &Config{}, is validated here.
+func NewStage(cfg *Config) (*Stage, error) {
+	if cfg.units == nil {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Stage{cfg: cfg}, nil
+}
+
+// Name returns "transform".
+func (*Stage) Name() string { return telemetry.StageTransform }
+
+// Process transforms the readings of m. A calibration that yields NaN or
+// an infinite value fails the stage.
+func (s *Stage) Process(ctx context.Context, m *telemetry.Message) error {
+	for i := range m.Readings {
+		if err := s.transform(&m.Readings[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Stage) transform(r *telemetry.Reading) error {
+	p := s.cfg.profile(r.DeviceID, r.Labels[telemetry.LabelDeviceType])
+	raw := r.Value
+	value := raw
+	if p.Calibration != nil {
+		value = p.Calibration.Apply(value)
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("calibrating %g from device %s gives %g", raw, r.DeviceID, value)
+		}
+		metrics.Transformations.WithLabelValues("calibrated").Inc()
+	}
+
+	unit := p.Unit
+	if unit == "" {
+		unit = r.Unit
+	}
+	if unit == "" {
+		unit = r.Labels[telemetry.LabelUnit]
+	}
+	rawUnit := unit
+	if unit != "" {
+		converted, canonical, ok := s.cfg.units.Canonical(value, unit)
+		switch {
+		case !ok:
+			metrics.Transformations.WithLabelValues("unknown_unit").Inc()
+		case canonical != unit:
+			metrics.Transformations.WithLabelValues("converted").Inc()
+		}
+		value, unit = converted, canonical
+	}
+
+	r.Value, r.Unit = value, unit
+	if value != raw {
+		r.RawValue = &raw
+	}
+	if unit != rawUnit {
+		r.RawUnit = rawUnit
+	}
+	if unit != "" && r.Labels[telemetry.LabelUnit] != unit {
+		labels := make(map[string]string, len(r.Labels)+1)
+		for k, v := range r.Labels {
+			labels[k] = v
+		}
+		labels[telemetry.LabelUnit] = unit
+		r.Labels = labels
+	}
+	return nil
+}
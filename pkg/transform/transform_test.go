@@ -0,0 +1,200 @@
+package transform
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/config/configtest"
+	"iot-insighthub/pkg/telemetry"
+)
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestUnits_Canonical(t *testing.T) {
+	units, err := NewUnits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		value     float64
+		unit      string
+		want      float64
+		canonical string
+	}{
+		{212, "degF", 100, "degC"},
+		{32, "°F", 0, "degC"},
+		{273.15, "K", 0, "degC"},
+		{21.5, "degC", 21.5, "degC"},
+		{1, "bar", 100, "kPa"},
+		{14.5037738, "psi", 100, "kPa"},
+	} {
+		got, canonical, ok := units.Canonical(tc.value, tc.unit)
+		if !ok || math.Abs(got-tc.want) > 1e-6 || canonical != tc.canonical {
+			t.Errorf("Canonical(%g, %s) = %g %s %v, want %g %s", tc.value, tc.unit, got, canonical, ok, tc.want, tc.canonical)
+		}
+	}
+	if _, _, ok := units.Canonical(1, "furlong"); ok {
+		t.Error("unknown unit converted")
+	}
+}
+
+func TestNewUnits_Invalid(t *testing.T) {
+	for _, extra := range []Unit{
+		{Name: "inH2O", Quantity: "pressure"},
+		{Name: "kPa2", Quantity: "pressure", Scale: 1},
+		{Name: "ppm", Quantity: "concentration", Scale: 0.0001},
+		{Name: "mmHg", Quantity: "pressure", Scale: 0.133, Aliases: []string{"bar"}},
+	} {
+		if _, err := NewUnits(extra); err == nil {
+			t.Errorf("NewUnits(%+v) succeeded, want an error", extra)
+		}
+	}
+}
+
+func TestCurve_Apply(t *testing.T) {
+	gain := 2.0
+	for _, tc := range []struct {
+		curve Curve
+		raw   float64
+		want  float64
+	}{
+		{Curve{Type: CurveLinear, Gain: &gain, Offset: -1}, 3, 5},
+		{Curve{Type: CurveLinear, Offset: 0.5}, 3, 3.5},
+		{Curve{Type: CurvePolynomial, Coefficients: []float64{1, 0, 2}}, 3, 19},
+		{Curve{Type: CurveTable, Points: [][2]float64{{10, 100}, {0, 0}, {20, 300}}}, 5, 50},
+		{Curve{Type: CurveTable, Points: [][2]float64{{0, 0}, {10, 100}, {20, 300}}}, 15, 200},
+		{Curve{Type: CurveTable, Points: [][2]float64{{0, 0}, {10, 100}, {20, 300}}}, -1, -10},
+		{Curve{Type: CurveTable, Points: [][2]float64{{0, 0}, {10, 100}, {20, 300}}}, 25, 400},
+	} {
+		c := tc.curve
+		if err := c.validate(); err != nil {
+			t.Fatal(err)
+		}
+		c.prepare()
+		if got := c.Apply(tc.raw); !near(got, tc.want) {
+			t.Errorf("%s curve: Apply(%g) = %g, want %g", c.Type, tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	for yaml, want := range map[string]string{
+		"devices:\n  a:\n    unit: furlong\n":                                "unknown unit",
+		"devices:\n  a:\n    calibration: {type: cubic}\n":                   "unknown curve type",
+		"devices:\n  a:\n    calibration: {type: table, points: [[0, 1]]}\n": "at least two points",
+		"devices:\n  a:\n    calibration: {type: linear, gain: 0}\n":         "gain must not be zero",
+		"units:\n  - {name: ppm, quantity: concentration, scale: 0.0001}\n":  "no canonical unit",
+	} {
+		err := configtest.LoadYAML(t, yaml, &Config{})
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadConfig(%q) error = %v, want %q", yaml, err, want)
+		}
+	}
+}
+
+const testConfig = `
+units:
+  - name: inH2O
+    quantity: pressure
+    scale: 0.249089
+device_types:
+  legacy-thermometer:
+    unit: degF
+devices:
+  boiler-1:
+    unit: psi
+    calibration: {type: linear, gain: 1.1}
+  thermo-7:
+    calibration: {type: linear, offset: -2}
+`
+
+func reading(deviceID string, value float64, labels map[string]string) *telemetry.Message {
+	return &telemetry.Message{Readings: []telemetry.Reading{{
+		TelemetryData: api.TelemetryData{DeviceID: deviceID, Value: value, Time: 1700000000},
+		Labels:        labels,
+	}}}
+}
+
+func TestStage_Transforms(t *testing.T) {
+	cfg := &Config{}
+	if err := configtest.LoadYAML(t, testConfig, cfg); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name      string
+		m         *telemetry.Message
+		want      float64
+		unit      string
+		unchanged bool
+	}{
+		{"calibrated then converted", reading("boiler-1", 10, nil), 11 * 6.894757293168361, "kPa", false},
+		{"unit from device type, calibration from device", reading("thermo-7", 214, map[string]string{"device_type": "legacy-thermometer"}), 100, "degC", false},
+		{"unit from registry label", reading("gauge-2", 40, map[string]string{"unit": "inH2O"}), 40 * 0.249089, "kPa", false},
+		{"canonical already", reading("gauge-3", 21.5, map[string]string{"unit": "degC"}), 21.5, "degC", true},
+		{"unknown unit kept", reading("gauge-4", 3, map[string]string{"unit": "furlong"}), 3, "furlong", true},
+		{"no unit", reading("gauge-5", 3, nil), 3, "", true},
+	} {
+		if err := s.Process(context.Background(), tc.m); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		r := tc.m.Readings[0]
+		if !near(r.Value, tc.want) || r.Unit != tc.unit {
+			t.Errorf("%s: got %g %s, want %g %s", tc.name, r.Value, r.Unit, tc.want, tc.unit)
+		}
+		if tc.unit != "" && r.Labels["unit"] != tc.unit {
+			t.Errorf("%s: unit label = %q, want %q", tc.name, r.Labels["unit"], tc.unit)
+		}
+		if tc.unchanged != (r.RawValue == nil) {
+			t.Errorf("%s: raw value = %v, want it kept only if the value changed", tc.name, r.RawValue)
+		}
+	}
+
+	m := reading("boiler-1", 10, nil)
+	s.Process(context.Background(), m)
+	if *m.Readings[0].RawValue != 10 || m.Readings[0].RawUnit != "psi" {
+		t.Errorf("raw reading = %g %s, want 10 psi", *m.Readings[0].RawValue, m.Readings[0].RawUnit)
+	}
+	m = reading("gauge-3", 21.5, map[string]string{"unit": "degC"})
+	s.Process(context.Background(), m)
+	if m.Readings[0].RawUnit != "" {
+		t.Errorf("raw unit = %q, want none for a canonical unit", m.Readings[0].RawUnit)
+	}
+}
+
+func TestStage_NonFiniteCalibrationFails(t *testing.T) {
+	cfg := &Config{}
+	if err := configtest.LoadYAML(t, "devices:\n  a:\n    calibration: {type: polynomial, coefficients: [0, 0, 1e300]}\n", cfg); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStage(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Process(context.Background(), reading("a", 1e10, nil)); err == nil {
+		t.Error("Process succeeded with an infinite value")
+	}
+}
+
+func TestNewStage_DefaultsAnUnvalidatedConfig(t *testing.T) {
+	s, err := NewStage(&Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := reading("gauge-1", 212, map[string]string{"unit": "degF"})
+	if err := s.Process(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	if r := m.Readings[0]; !near(r.Value, 100) || r.Unit != "degC" {
+		t.Errorf("got %g %s, want 100 degC", r.Value, r.Unit)
+	}
+	if _, err := NewStage(&Config{Devices: map[string]Profile{"a": {Unit: "furlong"}}}); err == nil {
+		t.Error("NewStage accepted an unknown unit")
+	}
+}
@@ -0,0 +1,125 @@
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Unit is a unit of measurement and how to convert it to the canonical
+// unit of its quantity: canonical = value*Scale + Offset.
+type Unit struct {
+	Name     string   `yaml:"name"`
+	Quantity string   `yaml:"quantity"`
+	Scale    float64  `yaml:"scale"`
+	Offset   float64  `yaml:"offset"`
+	Aliases  []string `yaml:"aliases"`
+}
+
+// builtinUnits are the units known without configuration. The canonical
+// unit of each quantity is the one with Scale 1 and Offset 0.
+var builtinUnits = []Unit{
+	{Name: "degC", Quantity: "temperature", Scale: 1, Aliases: []string{"°C", "C", "celsius"}},
+	{Name: "degF", Quantity: "temperature", Scale: 5.0 / 9, Offset: -32 * 5.0 / 9, Aliases: []string{"°F", "F", "fahrenheit"}},
+	{Name: "K", Quantity: "temperature", Scale: 1, Offset: -273.15, Aliases: []string{"kelvin"}},
+
+	{Name: "kPa", Quantity: "pressure", Scale: 1},
+	{Name: "Pa", Quantity: "pressure", Scale: 0.001},
+	{Name: "hPa", Quantity: "pressure", Scale: 0.1},
+	{Name: "bar", Quantity: "pressure", Scale: 100},
+	{Name: "mbar", Quantity: "pressure", Scale: 0.1},
+	{Name: "psi", Quantity: "pressure", Scale: 6.894757293168361},
+	{Name: "atm", Quantity: "pressure", Scale: 101.325},
+
+	{Name: "%", Quantity: "ratio", Scale: 1, Aliases: []string{"%RH", "percent"}},
+
+	{Name: "V", Quantity: "voltage", Scale: 1},
+	{Name: "mV", Quantity: "voltage", Scale: 0.001},
+	{Name: "A", Quantity: "current", Scale: 1},
+	{Name: "mA", Quantity: "current", Scale: 0.001},
+	{Name: "W", Quantity: "power", Scale: 1},
+	{Name: "kW", Quantity: "power", Scale: 1000},
+	{Name: "kWh", Quantity: "energy", Scale: 1},
+	{Name: "Wh", Quantity: "energy", Scale: 0.001},
+	{Name: "MWh", Quantity: "energy", Scale: 1000},
+
+	{Name: "L/min", Quantity: "flow", Scale: 1, Aliases: []string{"lpm"}},
+	{Name: "m3/h", Quantity: "flow", Scale: 1000.0 / 60, Aliases: []string{"m³/h"}},
+	{Name: "gpm", Quantity: "flow", Scale: 3.785411784},
+}
+
+// Units is a registry of units by name and alias.
+type Units struct {
+	byName    map[string]Unit
+	canonical map[string]string // quantity to unit name
+}
+
+// NewUnits returns a registry of the built-in units plus extra ones. An
+// extra unit replaces a built-in unit of the same name. Every quantity needs
+// exactly one canonical unit, with Scale 1 and Offset 0.
+func NewUnits(extra ...Unit) (*Units, error) {
+	var (
+		units    []Unit
+		index    = make(map[string]int)
+		problems []string
+	)
+	for _, unit := range append(append([]Unit(nil), builtinUnits...), extra...) {
+		if i, ok := index[unit.Name]; ok {
+			units[i] = unit
+			continue
+		}
+		index[unit.Name] = len(units)
+		units = append(units, unit)
+	}
+
+	u := &Units{byName: make(map[string]Unit), canonical: make(map[string]string)}
+	for _, unit := range units {
+		switch {
+		case unit.Name == "" || unit.Quantity == "":
+			problems = append(problems, fmt.Sprintf("unit %q: name and quantity are required", unit.Name))
+			continue
+		case unit.Scale == 0:
+			problems = append(problems, fmt.Sprintf("unit %s: scale must not be zero", unit.Name))
+			continue
+		}
+		for _, name := range append([]string{unit.Name}, unit.Aliases...) {
+			if other, ok := u.byName[name]; ok {
+				problems = append(problems, fmt.Sprintf("unit %s: %s already names %s", unit.Name, name, other.Name))
+			}
+			u.byName[name] = unit
+		}
+		if unit.Scale == 1 && unit.Offset == 0 {
+			if other, ok := u.canonical[unit.Quantity]; ok {
+				problems = append(problems, fmt.Sprintf("quantity %s has two canonical units, %s and %s", unit.Quantity, other, unit.Name))
+			}
+			u.canonical[unit.Quantity] = unit.Name
+		}
+	}
+	for _, unit := range units {
+		if _, ok := u.canonical[unit.Quantity]; !ok && unit.Quantity != "" {
+			problems = append(problems, fmt.Sprintf("quantity %s has no canonical unit (scale 1, offset 0)", unit.Quantity))
+			u.canonical[unit.Quantity] = "" // reported once
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "; "))
+	}
+	return u, nil
+}
+
+// Lookup returns the unit with the given name or alias.
+func (u *Units) Lookup(name string) (Unit, bool) {
+	unit, ok := u.byName[name]
+	return unit, ok
+}
+
+// Canonical converts value in unit to the canonical unit of its quantity,
+// returning the converted value and the canonical unit's name. ok is false
+// if the unit is unknown.
+func (u *Units) Canonical(value float64, unit string) (float64, string, bool) {
+	from, ok := u.Lookup(unit)
+	if !ok {
+		return value, unit, false
+	}
+	return value*from.Scale + from.Offset, u.canonical[from.Quantity], true
+}
@@ -0,0 +1,265 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/config"
+	"iot-insighthub/pkg/metrics"
+)
+
+// Rules a reading can break. They name the violations, the quarantine
+// entries and the validation_failures_total counts.
+const (
+	// RuleRequired: device_id or time is missing.
+	RuleRequired = "required"
+	// RuleDeviceID: the device ID does not match the configured pattern.
+	RuleDeviceID = "device_id"
+	// RuleValueRange: the value is not finite, or is outside the range of
+	// its device type.
+	RuleValueRange = "value_range"
+	// RuleClockSkew: time is too far before or after the reading arrived.
+	RuleClockSkew = "clock_skew"
+)
+
+// DefaultDeviceID is the device ID pattern used when none is configured.
+const DefaultDeviceID = `^[A-Za-z0-9][A-Za-z0-9_.:-]{0,127}$`
+
+// Config holds the validation rules shared by the secure API and the
+// ingestor.
+//
+//	device_id: '^[a-z]+-[0-9]+$'
+//	max_age: 168h
+//	max_ahead: 5m
+//	ranges:
+//	  thermometer: {min: -50, max: 150}
+//	  humidity: {min: 0, max: 100}
+type Config struct {
+	// DeviceID is the pattern device IDs must match (default
+	// DefaultDeviceID).
+	DeviceID string `yaml:"device_id"`
+	// MaxAge is how long before it arrived a reading may have been taken
+	// (default 168h).
+	MaxAge time.Duration `yaml:"max_age"`
+	// MaxAhead is how far after it arrived a reading's time may be, to allow
+	// for device clocks running fast (default 5m).
+	MaxAhead time.Duration `yaml:"max_ahead"`
+	// Ranges bounds the values of readings by device type. Devices of other
+	// types, or of no known type, only need finite values.
+	Ranges map[string]Range `yaml:"ranges"`
+
+	deviceID *regexp.Regexp
+}
+
+// Range bounds values, inclusively. Either bound may be left out.
+type Range struct {
+	Min *float64 `yaml:"min"`
+	Max *float64 `yaml:"max"`
+}
+
+// contains reports whether v is within r.
+func (r Range) contains(v float64) bool {
+	return (r.Min == nil || v >= *r.Min) && (r.Max == nil || v <= *r.Max)
+}
+
+func (r Range) String() string {
+	bound := func(b *float64, open string) string {
+		if b == nil {
+			return open
+		}
+		return fmt.Sprint(*b)
+	}
+	return "[" + bound(r.Min, "-inf") + ", " + bound(r.Max, "+inf") + "]"
+}
+
+// LoadConfig reads a Config from the YAML file at path with
+// config.LoadYAML.
+func LoadConfig(path string) (*Config, error) {
+	var c Config
+	if err := config.LoadYAML(path, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Validate reports every invalid setting and compiles the device ID
+// pattern. Unset settings take their defaults.
+func (c *Config) Validate() error {
+	if c.DeviceID == "" {
+		c.DeviceID = DefaultDeviceID
+	}
+	if c.MaxAge == 0 {
+		c.MaxAge = 7 * 24 * time.Hour
+	}
+	if c.MaxAhead == 0 {
+		c.MaxAhead = 5 * time.Minute
+	}
+	var problems []string
+	re, err := regexp.Compile(c.DeviceID)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("device_id: %v", err))
+	}
+	c.deviceID = re
+	if c.MaxAge < 0 {
+		problems = append(problems, "max_age must not be negative")
+	}
+	if c.MaxAhead < 0 {
+		problems = append(problems, "max_ahead must not be negative")
+	}
+	for deviceType, r := range c.Ranges {
+		if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			problems = append(problems, fmt.Sprintf("ranges.%s: min is above max", deviceType))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Violation is one rule a reading breaks.
+type Violation struct {
+	Field  string `json:"field"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// Error is returned for a reading that breaks at least one rule.
+type Error struct {
+	Violations []Violation
+}
+
+func (e *Error) Error() string {
+	reasons := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		reasons[i] = v.Field + ": " + v.Reason
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Violations returns the violations of err, or nil if it is not an *Error.
+func Violations(err error) []Violation {
+	var verr *Error
+	if errors.As(err, &verr) {
+		return verr.Violations
+	}
+	return nil
+}
+
+// DeviceTypes finds the type of a device, for the value range of its
+// readings. It returns "" for a device of no known type. registry.Cache
+// implements it.
+type DeviceTypes interface {
+	DeviceType(ctx context.Context, deviceID string) (string, error)
+}
+
+// Validator checks readings against a Config. The required and device_id
+// rules are the validate tags of api.TelemetryData, checked with
+// go-playground/validator. It is safe for concurrent use.
+type Validator struct {
+	cfg     *Config
+	types   DeviceTypes
+	structs *validator.Validate
+}
+
+// New returns a Validator using cfg. A Config that was not validated, such
+// as &Config{}, is validated here to take its defaults. types finds the
+// device types of readings whose type is not known; it may be nil if cfg
+// has no ranges, or if the callers always know the type.
+func New(cfg *Config, types DeviceTypes) (*Validator, error) {
+	if cfg.deviceID == nil {
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	structs := validator.New()
+	structs.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		return name
+	})
+	structs.RegisterValidation(RuleDeviceID, func(fl validator.FieldLevel) bool {
+		return cfg.deviceID.MatchString(fl.Field().String())
+	})
+	return &Validator{cfg: cfg, types: types, structs: structs}, nil
+}
+
+var (
+	defaultOnce      sync.Once
+	defaultValidator *Validator
+)
+
+// Default returns a Validator with the default Config and no ranges.
+func Default() *Validator {
+	defaultOnce.Do(func() {
+		v, err := New(&Config{}, nil)
+		if err != nil {
+			panic(err) // the default Config is valid
+		}
+		defaultValidator = v
+	})
+	return defaultValidator
+}
+
+// Check validates data, which arrived at arrival. deviceType is the
+// device's type, if the caller knows it; otherwise it is looked up when
+// ranges are configured. Violations are counted by rule and returned as an
+// *Error; other errors mean the device type could not be looked up.
+func (v *Validator) Check(ctx context.Context, data api.TelemetryData, deviceType string, arrival time.Time) error {
+	var violations []Violation
+	if err := v.structs.Struct(data); err != nil {
+		var fields validator.ValidationErrors
+		if !errors.As(err, &fields) {
+			return err
+		}
+		for _, f := range fields {
+			reason := "is required"
+			if f.Tag() == RuleDeviceID {
+				reason = fmt.Sprintf("%q does not match %s", f.Value(), v.cfg.DeviceID)
+			}
+			violations = append(violations, Violation{Field: f.Field(), Rule: f.Tag(), Reason: reason})
+		}
+	}
+
+	if math.IsNaN(data.Value) || math.IsInf(data.Value, 0) {
+		violations = append(violations, Violation{Field: "value", Rule: RuleValueRange, Reason: fmt.Sprintf("%v is not a finite number", data.Value)})
+	} else if len(v.cfg.Ranges) > 0 && data.DeviceID != "" {
+		if deviceType == "" && v.types != nil {
+			t, err := v.types.DeviceType(ctx, data.DeviceID)
+			if err != nil {
+				return fmt.Errorf("looking up the type of device %s: %w", data.DeviceID, err)
+			}
+			deviceType = t
+		}
+		if r, ok := v.cfg.Ranges[deviceType]; ok && !r.contains(data.Value) {
+			violations = append(violations, Violation{Field: "value", Rule: RuleValueRange, Reason: fmt.Sprintf("%v is outside %s for device type %s", data.Value, r, deviceType)})
+		}
+	}
+
+	if data.Time != 0 {
+		at := time.Unix(data.Time, 0)
+		switch {
+		case arrival.Sub(at) > v.cfg.MaxAge:
+			violations = append(violations, Violation{Field: "time", Rule: RuleClockSkew, Reason: fmt.Sprintf("%s is more than %s before arrival at %s", at.UTC().Format(time.RFC3339), v.cfg.MaxAge, arrival.UTC().Format(time.RFC3339))})
+		case at.Sub(arrival) > v.cfg.MaxAhead:
+			violations = append(violations, Violation{Field: "time", Rule: RuleClockSkew, Reason: fmt.Sprintf("%s is more than %s after arrival at %s", at.UTC().Format(time.RFC3339), v.cfg.MaxAhead, arrival.UTC().Format(time.RFC3339))})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, violation := range violations {
+		metrics.ValidationFailures.WithLabelValues(violation.Rule).Inc()
+	}
+	return &Error{Violations: violations}
+}
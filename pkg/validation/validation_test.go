@@ -0,0 +1,131 @@
+package validation
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"iot-insighthub/pkg/api"
+	"iot-insighthub/pkg/config/configtest"
+)
+
+// arrival is when the test readings arrived.
+var arrival = time.Unix(1700000000, 0)
+
+// staticTypes maps device IDs to types.
+type staticTypes map[string]string
+
+func (s staticTypes) DeviceType(ctx context.Context, deviceID string) (string, error) {
+	return s[deviceID], nil
+}
+
+func newTestValidator(t *testing.T, yaml string, types DeviceTypes) *Validator {
+	t.Helper()
+	var cfg Config
+	if err := configtest.LoadYAML(t, yaml, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	v, err := New(&cfg, types)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func rules(err error) []string {
+	var out []string
+	for _, v := range Violations(err) {
+		out = append(out, v.Field+"/"+v.Rule)
+	}
+	return out
+}
+
+func TestValidator_Rules(t *testing.T) {
+	v := newTestValidator(t, `
+max_age: 24h
+max_ahead: 1m
+ranges:
+  thermometer: {min: -50, max: 150}
+`, staticTypes{"thermo-1": "thermometer"})
+	now := arrival.Unix()
+	tests := []struct {
+		name       string
+		data       api.TelemetryData
+		deviceType string
+		want       []string
+	}{
+		{"valid", api.TelemetryData{DeviceID: "thermo-1", Value: 21.5, Time: now}, "", nil},
+		{"zero value", api.TelemetryData{DeviceID: "pump-7", Time: now}, "", nil},
+		{"missing fields", api.TelemetryData{Value: 1}, "", []string{"device_id/required", "time/required"}},
+		{"bad device ID", api.TelemetryData{DeviceID: "boiler 1;", Time: now}, "", []string{"device_id/device_id"}},
+		{"out of range, looked up", api.TelemetryData{DeviceID: "thermo-1", Value: 400, Time: now}, "", []string{"value/value_range"}},
+		{"out of range, known type", api.TelemetryData{DeviceID: "probe-2", Value: -60, Time: now}, "thermometer", []string{"value/value_range"}},
+		{"no range for type", api.TelemetryData{DeviceID: "probe-2", Value: 400, Time: now}, "", nil},
+		{"not finite", api.TelemetryData{DeviceID: "probe-2", Value: math.Inf(1), Time: now}, "", []string{"value/value_range"}},
+		{"year 1970", api.TelemetryData{DeviceID: "probe-2", Time: 86400}, "", []string{"time/clock_skew"}},
+		{"too far ahead", api.TelemetryData{DeviceID: "probe-2", Time: now + 120}, "", []string{"time/clock_skew"}},
+		{"slightly ahead", api.TelemetryData{DeviceID: "probe-2", Time: now + 30}, "", nil},
+	}
+	for _, tt := range tests {
+		err := v.Check(context.Background(), tt.data, tt.deviceType, arrival)
+		got := rules(err)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: violations = %v, want %v (%v)", tt.name, got, tt.want, err)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: violations = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+type failingTypes struct{}
+
+func (failingTypes) DeviceType(ctx context.Context, deviceID string) (string, error) {
+	return "", errors.New("registry unavailable")
+}
+
+func TestValidator_LookupErrorIsNotAViolation(t *testing.T) {
+	v := newTestValidator(t, "ranges: {thermometer: {max: 150}}", failingTypes{})
+	err := v.Check(context.Background(), api.TelemetryData{DeviceID: "thermo-1", Time: arrival.Unix()}, "", arrival)
+	if err == nil || Violations(err) != nil {
+		t.Errorf("Check = %v, want a lookup error", err)
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	lo, hi := 10.0, 5.0
+	c := &Config{DeviceID: "([", MaxAhead: -time.Second, Ranges: map[string]Range{"x": {Min: &lo, Max: &hi}}}
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted an invalid config")
+	}
+	for _, want := range []string{"device_id", "max_ahead", "ranges.x"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+}
+
+func TestNew_DefaultsAnUnvalidatedConfig(t *testing.T) {
+	v, err := New(&Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Check(context.Background(), api.TelemetryData{DeviceID: "pump-7", Time: arrival.Unix()}, "", arrival); err != nil {
+		t.Errorf("Check = %v, want a valid reading under the default rules", err)
+	}
+	err = v.Check(context.Background(), api.TelemetryData{DeviceID: "pump 7", Time: arrival.Unix() - 30*86400}, "", arrival)
+	if got := rules(err); len(got) != 2 {
+		t.Errorf("violations = %v, want the default device ID pattern and max age", got)
+	}
+	if _, err := New(&Config{DeviceID: "pump-("}, nil); err == nil {
+		t.Error("New accepted an invalid device ID pattern")
+	}
+}